@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"strings"
 	"time"
 
@@ -131,6 +133,18 @@ func IsSucced(statusCode int32) bool {
 	return statusCode/100 == 2
 }
 
-func GetReqIP(ip string) string {
-	return strings.Split(ip, ",")[0]
+// GetReqIP returns client address of request.
+// Last X-Forwarded-For hop is added by our proxy, earlier hops are sent by client and may be forged
+func GetReqIP(r *http.Request) string {
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if ip := strings.TrimSpace(hops[len(hops)-1]); len(ip) > 0 {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
 }
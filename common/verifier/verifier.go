@@ -0,0 +1,171 @@
+// Package verifier validates auth tokens of session service in process.
+// Public keys and revoked tokens are fetched from session service and cached,
+// so verification needs no call per token
+package verifier
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+
+	sessionService "github.com/farerpath/sessionservice/proto"
+)
+
+// ErrInvalidToken is returned for tokens not signed by session service,
+// expired or revoked
+var ErrInvalidToken = errors.New("invalid token")
+
+const (
+	// keyRefresh is least interval of fetching keys for unknown key ID
+	keyRefresh = time.Minute
+	// revokedRefresh is how long revoked tokens are cached
+	revokedRefresh = 10 * time.Second
+	// maxRevokedAge is how long cached revoked tokens are trusted
+	// when session service cannot be reached, tokens are refused after
+	maxRevokedAge = time.Minute
+
+	fetchTimeout = 5 * time.Second
+)
+
+// claims of auth token, as session service signs them
+type claims struct {
+	Key  string
+	Type string
+	jwt.StandardClaims
+}
+
+// Verifier verifies auth tokens with keys of session service
+type Verifier struct {
+	client sessionService.SessionClient
+
+	mu             sync.Mutex
+	keys           map[string]*rsa.PublicKey
+	keysFetched    time.Time
+	revoked        map[string]int64
+	revokedFetched time.Time
+}
+
+// New gives verifier fetching from session service
+func New(client sessionService.SessionClient) *Verifier {
+	return &Verifier{
+		client:  client,
+		keys:    map[string]*rsa.PublicKey{},
+		revoked: map[string]int64{},
+	}
+}
+
+// Verify gives user of auth token.
+// ErrInvalidToken when token is not valid,
+// other errors when keys or revoked tokens could not be fetched
+func (v *Verifier) Verify(token string) (string, error) {
+	unverified, _, err := new(jwt.Parser).ParseUnverified(token, &claims{})
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	// Only RS256 prevents tokens signed with public key as HMAC secret
+	if unverified.Method != jwt.SigningMethodRS256 {
+		return "", ErrInvalidToken
+	}
+
+	kid, _ := unverified.Header["kid"].(string)
+
+	key, err := v.key(kid)
+	if err != nil {
+		return "", err
+	}
+	if key == nil {
+		return "", ErrInvalidToken
+	}
+
+	t, err := jwt.ParseWithClaims(token, &claims{}, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+	if err != nil || !t.Valid {
+		return "", ErrInvalidToken
+	}
+
+	claim := t.Claims.(*claims)
+
+	if claim.Type != "AUTH" || claim.Issuer != "farerpath" || len(claim.Subject) < 1 {
+		return "", ErrInvalidToken
+	}
+
+	revoked, err := v.isRevoked(claim.Id)
+	if err != nil {
+		return "", err
+	}
+	if revoked {
+		return "", ErrInvalidToken
+	}
+
+	return claim.Subject, nil
+}
+
+// key gives public key of key ID, nil when session service has no such key
+func (v *Verifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, exists := v.keys[kid]; exists {
+		return key, nil
+	}
+
+	// Unknown key IDs would fetch on every token otherwise
+	if time.Since(v.keysFetched) < keyRefresh {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	resp, err := v.client.GetPublicKeys(ctx, &sessionService.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("fetching public keys: %v", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range resp.GetKey() {
+		keys[key.GetKeyID()] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(key.GetModulus()),
+			E: int(key.GetExponent()),
+		}
+	}
+
+	v.keys = keys
+	v.keysFetched = time.Now()
+
+	return v.keys[kid], nil
+}
+
+func (v *Verifier) isRevoked(id string) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if time.Since(v.revokedFetched) > revokedRefresh {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+
+		resp, err := v.client.GetRevokedTokens(ctx, &sessionService.Empty{})
+		if err == nil {
+			revoked := map[string]int64{}
+			for _, token := range resp.GetToken() {
+				revoked[token.GetTokenID()] = token.GetExpiresAt()
+			}
+
+			v.revoked = revoked
+			v.revokedFetched = time.Now()
+		} else if time.Since(v.revokedFetched) > maxRevokedAge {
+			return false, fmt.Errorf("fetching revoked tokens: %v", err)
+		}
+	}
+
+	_, revoked := v.revoked[id]
+	return revoked, nil
+}
@@ -14,6 +14,7 @@ require (
 	github.com/gorilla/mux v1.7.1
 	github.com/jasonwinn/geocoder v0.0.0-20190118154513-0a8a678400b8
 	github.com/mssola/user_agent v0.5.0
+	github.com/oschwald/maxminddb-golang v1.3.1
 	github.com/rs/cors v1.6.0
 	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 	github.com/urfave/negroni v1.0.0
@@ -11,12 +11,40 @@ const (
 	WEB     = 0x000002
 )
 
+// Publish range of album and picture
+// MEMBER picture is visible to members of albums it is in
 const (
 	PRIVATE = 0
 	PUBLIC  = 1
 	MEMBER  = 2
 )
 
+// Location precision of user's pictures for other viewers
+// Account without setting is treated as LOCATION_CITY
+const (
+	LOCATION_EXACT = "exact"
+	LOCATION_CITY  = "city"
+	LOCATION_NONE  = "none"
+)
+
+// Album member roles
+// Member without role is treated as ROLE_CONTRIBUTOR
+const (
+	ROLE_NONE        = 0
+	ROLE_VIEWER      = 1
+	ROLE_CONTRIBUTOR = 2
+	ROLE_EDITOR      = 3
+	ROLE_OWNER       = 4
+)
+
+// Album invitation status
+const (
+	INVITATION_PENDING  = 0
+	INVITATION_ACCEPTED = 1
+	INVITATION_DECLINED = 2
+	INVITATION_REVOKED  = 3
+)
+
 const (
 	DBADDRESS = "127.0.0.1"
 )
@@ -21,4 +21,5 @@ const (
 	FP_PASSWORD_NOT_MATCH  = "744"
 	FP_ACCOUNT_NOT_EXIST   = "745"
 	FP_VALUE_INVALID       = "746"
+	FP_EMAIL_NOT_VERIFIED  = "747"
 )
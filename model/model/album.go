@@ -19,16 +19,34 @@ type AlbumList struct {
 // MongoDB
 // farerpath.albums
 type Album struct {
-	AlbumID      uoid.UOID   `json:"_id" bson:"_id"`
-	AlbumName    string      `json:"albumName" bson:"albumName"`
-	Owner        string      `json:"owner" bson:"owner"`
-	BeginTime    time.Time   `json:"beginTime" bson:"beginTime"`
-	EndTime      time.Time   `json:"endTime" bson:"endTime"`
-	TravelPath   Path        `json:"travelPath" bson:"travelPath"`
-	Members      []string    `json:"members" bson:"members"`
-	Pictures     []uoid.UOID `json:"pictures" bson:"pictures"`
-	PublishRange uint32      `json:"publishRange" bson:"publishRange"`
-	Archived     bool        `json:"archived" bson:"archived"`
+	AlbumID      uoid.UOID         `json:"_id" bson:"_id"`
+	AlbumName    string            `json:"albumName" bson:"albumName"`
+	Owner        string            `json:"owner" bson:"owner"`
+	BeginTime    time.Time         `json:"beginTime" bson:"beginTime"`
+	EndTime      time.Time         `json:"endTime" bson:"endTime"`
+	TravelPath   Path              `json:"travelPath" bson:"travelPath"`
+	Members      []string          `json:"members" bson:"members"`
+	Roles        map[string]uint32 `json:"roles" bson:"roles"`
+	Pictures     []uoid.UOID       `json:"pictures" bson:"pictures"`
+	PublishRange uint32            `json:"publishRange" bson:"publishRange"`
+	Archived     bool              `json:"archived" bson:"archived"`
+}
+
+// Invitation model
+// MongoDB
+// Invitation to album, invitee is user ID or email
+// farerpath.invitations
+type Invitation struct {
+	InvitationID uoid.UOID `json:"_id" bson:"_id"`
+	AlbumID      uoid.UOID `json:"albumID" bson:"albumID"`
+	AlbumName    string    `json:"albumName" bson:"albumName"`
+	Inviter      string    `json:"inviter" bson:"inviter"`
+	InviteeID    string    `json:"inviteeID" bson:"inviteeID"`
+	InviteeEmail string    `json:"inviteeEmail" bson:"inviteeEmail"`
+	Role         uint32    `json:"role" bson:"role"`
+	Status       uint32    `json:"status" bson:"status"`
+	CreatedTime  time.Time `json:"createdTime" bson:"createdTime"`
+	ExpireTime   time.Time `json:"expireTime" bson:"expireTime"`
 }
 
 // DEPRECATED
@@ -39,16 +57,17 @@ type AlbumListNode struct {
 }
 
 type AlbumWithPicture struct {
-	AlbumID      uoid.UOID `json:"_id" bson:"_id"`
-	AlbumName    string    `json:"albumName" bson:"albumName"`
-	Owner        string    `json:"owner" bson:"owner"`
-	BeginTime    time.Time `json:"beginTime" bson:"beginTime"`
-	EndTime      time.Time `json:"endTime" bson:"endTime"`
-	TravelPath   Path      `json:"travelPath" bson:"travelPath"`
-	Members      []string  `json:"members" bson:"members"`
-	Pictures     []Picture `json:"pictures" bson:"pictures"`
-	PublishRange uint32    `json:"publishRange" bson:"publishRange"`
-	Archived     bool      `json:"archived" bson:"archived"`
+	AlbumID      uoid.UOID         `json:"_id" bson:"_id"`
+	AlbumName    string            `json:"albumName" bson:"albumName"`
+	Owner        string            `json:"owner" bson:"owner"`
+	BeginTime    time.Time         `json:"beginTime" bson:"beginTime"`
+	EndTime      time.Time         `json:"endTime" bson:"endTime"`
+	TravelPath   Path              `json:"travelPath" bson:"travelPath"`
+	Members      []string          `json:"members" bson:"members"`
+	Roles        map[string]uint32 `json:"roles" bson:"roles"`
+	Pictures     []Picture         `json:"pictures" bson:"pictures"`
+	PublishRange uint32            `json:"publishRange" bson:"publishRange"`
+	Archived     bool              `json:"archived" bson:"archived"`
 }
 
 type AlbumListWithAlbum struct {
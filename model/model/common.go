@@ -26,6 +26,21 @@ type UserSession struct {
 	DeviceType		int32 		`json:"deviceType" bson:"deviceType"`
 	LoginRegion		string		`json:"loginRegion" bson:"loginRegion"`
 	Sign			string		`json:"sign" bson:"sign"`
+	SessionDuration	int32		`json:"sessionDuration" bson:"sessionDuration"`
+	RefreshExpiresAt	time.Time	`json:"refreshExpiresAt" bson:"refreshExpiresAt"`
+	// Refresh tokens replaced by rotation, presenting one again revokes session
+	UsedRefreshTokens	[]string	`json:"-" bson:"usedRefreshTokens"`
+}
+
+// Session is login of user as shown to user, without its tokens
+type Session struct {
+	SessionID	string		`json:"sessionID"`
+	LoginTime	time.Time	`json:"loginTime"`
+	LoginIP		string		`json:"loginIP"`
+	LoginRegion	string		`json:"loginRegion"`
+	DeviceType	int32		`json:"deviceType"`
+	// Current is session of request
+	Current		bool		`json:"current"`
 }
 
 // 로그인 성공하면 부여하는 값. 이를 ReturnValue의 Value 에 포함하여 보낸다.
@@ -35,6 +50,9 @@ type LoginReturnValue struct {
 	Country  string 			`json:"country" bson:"country"`
 	RefreshToken    string 		`json:"refreshToken" bson:"refreshToken"`
 	AuthToken		string		`json:"authToken" bson:"authToken"`
+	// MfaRequired is set instead of tokens, MfaTicket is given with TOTP code then
+	MfaRequired		bool		`json:"mfaRequired,omitempty" bson:"mfaRequired"`
+	MfaTicket		string		`json:"mfaTicket,omitempty" bson:"mfaTicket"`
 }
 
 type ResponseValue struct {
@@ -14,11 +14,12 @@ type Picture struct {
 	PictureID     	uoid.UOID	`json:"_id" bson:"_id"`
 	TimeMetadata	time.Time	`json:"timeMetadata" bson:"timeMetadata"`
 	PublishRange  	uint32		`json:"publishRange" bson:"publishRange"`
-	CountNiceShot 	uint32		`json:"countNiceShot" bson:"countNiceSot"`
+	CountNiceShot 	uint32		`json:"countNiceShot" bson:"countNiceShot"`
 	Archived      	bool 		`json:"archived" bson:"archived"`
 	Albums        	[]string	`json:"albums" bson:"albums"`
 	Comments      	[]Comment	`json:"comments" bson:"comments"`
 	Path          	Path		`json:"path" bson:"path"`
+	Metadata      	PictureMetadata	`json:"metadata" bson:"metadata"`
 }
 
 type Path struct {
@@ -27,12 +28,54 @@ type Path struct {
 	Location GPSData 		`json:"location" bson:"location"`
 }
 
+// PictureMetadata model
+// Capture details read from EXIF on upload, zero values are unknown
+// CaptureTime is local time of camera, RFC 3339 with offset when offset is known
+type PictureMetadata struct {
+	CaptureTime           string  `json:"captureTime" bson:"captureTime"`
+	Make                  string  `json:"make" bson:"make"`
+	Model                 string  `json:"model" bson:"model"`
+	LensMake              string  `json:"lensMake" bson:"lensMake"`
+	LensModel             string  `json:"lensModel" bson:"lensModel"`
+	FocalLength           float64 `json:"focalLength" bson:"focalLength"`
+	EquivalentFocalLength uint32  `json:"equivalentFocalLength" bson:"equivalentFocalLength"`
+	Aperture              float64 `json:"aperture" bson:"aperture"`
+	ExposureTime          string  `json:"exposureTime" bson:"exposureTime"`
+	ISO                   uint32  `json:"iso" bson:"iso"`
+	Orientation           uint32  `json:"orientation" bson:"orientation"`
+	Altitude              string  `json:"altitude" bson:"altitude"`
+	Width                 uint32  `json:"width" bson:"width"`
+	Height                uint32  `json:"height" bson:"height"`
+}
+
+// NiceShot model
+// MongoDB
+// One user's reaction to picture, _id is "pictureID/userID" so a user can react only once
+// farerpath.niceshots
+type NiceShot struct {
+	NiceShotID string 		`json:"_id" bson:"_id"`
+	PictureID  uoid.UOID 	`json:"pictureID" bson:"pictureID"`
+	UserID     string 		`json:"userID" bson:"userID"`
+	Time       time.Time 	`json:"time" bson:"time"`
+}
+
+// Comment model
+// ParentID is empty for top level comments, otherwise it holds the comment replied to
 type Comment struct {
-	Owner     string 		`json:"owner" bson:"owner"`
-	UserName  string 		`json:"userName" bson:"userName"`
-	Value     string 		`json:"value" bson:"value"`
-	Time      string 		`json:"time" bson:"time"`
-	CommentID uoid.UOID 	`json:"_id" bson:"_id"`
+	Owner       string 			`json:"owner" bson:"owner"`
+	UserName    string 			`json:"userName" bson:"userName"`
+	Value       string 			`json:"value" bson:"value"`
+	Time        string 			`json:"time" bson:"time"`
+	CommentID   uoid.UOID 		`json:"_id" bson:"_id"`
+	ParentID    uoid.UOID 		`json:"parentID" bson:"parentID"`
+	EditedTime  string 			`json:"editedTime" bson:"editedTime"`
+	EditHistory []CommentEdit 	`json:"editHistory" bson:"editHistory"`
+}
+
+// CommentEdit keeps a previous value of an edited comment
+type CommentEdit struct {
+	Value string 		`json:"value" bson:"value"`
+	Time  string 		`json:"time" bson:"time"`
 }
 
 type GPSData struct {
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"github.com/farerpath/server/model/uoid"
+)
+
+// ShareLink model
+// MongoDB
+// Anonymous link to one album or one picture
+// farerpath.sharelinks
+type ShareLink struct {
+	ShareID      uoid.UOID `json:"_id" bson:"_id"`
+	Owner        string    `json:"owner" bson:"owner"`
+	AlbumID      uoid.UOID `json:"albumID" bson:"albumID"`
+	PictureID    uoid.UOID `json:"pictureID" bson:"pictureID"`
+	PasswordHash string    `json:"-" bson:"passwordHash"`
+	ExpireTime   time.Time `json:"expireTime" bson:"expireTime"`
+	MaxViews     uint32    `json:"maxViews" bson:"maxViews"`
+	Views        uint32    `json:"views" bson:"views"`
+	Revoked      bool      `json:"revoked" bson:"revoked"`
+	CreatedTime  time.Time `json:"createdTime" bson:"createdTime"`
+}
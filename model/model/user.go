@@ -20,4 +20,30 @@ type Account struct {
 	IsBirthdayPublic bool          	`json:"isBirthdayPublic" bson:"isBirthdayPublic"`
 	IsCountryPublic  bool          	`json:"isCountryPublic" bson:"isCountryPublic"`
 	IsProfilePublic  bool          	`json:"isProfilePublic" bson:"isProfilePublic"`
+	LocationPrivacy  string        	`json:"locationPrivacy" bson:"locationPrivacy"`
+	EmailVerified    bool          	`json:"emailVerified" bson:"emailVerified"`
+	TOTPEnabled      bool          	`json:"totpEnabled" bson:"totpEnabled"`
+	// TOTPSecret is base32, TOTPPendingSecret waits for first code of enrolment
+	TOTPSecret       string        	`json:"-" bson:"totpSecret,omitempty"`
+	TOTPPendingSecret string        	`json:"-" bson:"totpPendingSecret,omitempty"`
+	// TOTPLastStep is time step of last used code, codes are used once
+	TOTPLastStep     int64         	`json:"-" bson:"totpLastStep,omitempty"`
+	// RecoveryCodes are hex SHA-256 of unused recovery codes
+	RecoveryCodes    []string      	`json:"-" bson:"recoveryCodes,omitempty"`
+	// Identities are accounts of identity providers user logs in with
+	Identities       []Identity    	`json:"-" bson:"identities,omitempty"`
+	// DeleteAt is when deletion of account starts, zero when not requested
+	DeleteAt         time.Time     	`json:"deleteAt" bson:"deleteAt,omitempty"`
+	// Deleting is set when deletion started, user cannot log in any more
+	Deleting         bool          	`json:"-" bson:"deleting,omitempty"`
+}
+
+// Identity model
+// Account of OpenID Connect provider linked to user
+type Identity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	// Subject is ID of user at provider
+	Subject  string    `json:"-" bson:"subject"`
+	Email    string    `json:"email" bson:"email"`
+	LinkedAt time.Time `json:"linkedAt" bson:"linkedAt"`
 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 
 	pb "github.com/farerpath/albumservice/proto"
@@ -16,14 +15,17 @@ import (
 	"google.golang.org/grpc/status"
 
 	"log"
+	"strconv"
 )
 
 // MakeAlbumList func
 // MakeAlbumList to user, initialize user album list
 // Make default album
 func (srv *albumService) MakeAlbumList(ctx context.Context, req *pb.MakeAlbumListRequest) (*pb.MakeAlbumListReply, error) {
+	// Albums is empty array, as $addToSet fails on null
 	defaultAlbumList := &model.AlbumList{
 		UserID: req.ReqUserID,
+		Albums: []uoid.UOID{},
 	}
 
 	_, err := albumListCollection.InsertOne(ctx, defaultAlbumList)
@@ -35,77 +37,47 @@ func (srv *albumService) MakeAlbumList(ctx context.Context, req *pb.MakeAlbumLis
 	return &pb.MakeAlbumListReply{}, nil
 }
 
-// TODO: Aggregate
+// GetAlbumList func
+// Albums in dstUser's album list that reqUser can see
 func (srv *albumService) GetAlbumList(ctx context.Context, req *pb.GetAlbumListRequest) (*pb.GetAlbumListReply, error) {
-	fullAlbumList := &model.AlbumListWithAlbumWithPicture{}
 	albumList := &model.AlbumList{}
 
 	err := albumListCollection.FindOne(ctx, bson.D{{"_id", req.GetDstUserID()}}).Decode(albumList)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &pb.GetAlbumListReply{}, status.Error(codes.NotFound, "album list not found")
+		}
 		log.Printf("Error accured: GetAlbumList\n%v", err)
 		return &pb.GetAlbumListReply{}, status.Error(codes.Internal, err.Error())
 	}
 
+	result := &pb.GetAlbumListReply{UserID: albumList.UserID, AlbumList: []*pb.AlbumNode{}}
+
 	if len(albumList.Albums) < 1 {
-		return &pb.GetAlbumListReply{UserID:albumList.UserID, AlbumList:[]*pb.AlbumNode{}}, nil
+		return result, nil
 	}
 
-	cur, err := albumListCollection.Aggregate(ctx, mongo.Pipeline{
-		{{"$lookup", bson.D{{"from", "albums"}, {"localField", "albums"}, {"foreignField", "_id"}, {"as", "albums"}}}},
-		{{"$unwind", bson.D{{"path", "$albums"}, {"preserveNullAndEmptyArrays", true}}}},
-		{{"$lookup", bson.D{{"from", "picture"}, {"localField", "albums.pictures"}, {"foreignField", "_id"}, {"as", "albums.pictures"}}}},
-		{{"$group", bson.D{{"_id", "$_id"}, {"albums", bson.D{{"$push", "$albums"}}}}}},
-	}, options.Aggregate())
-
-	cur.Next(ctx)
-
-	err = cur.Decode(&fullAlbumList)
+	cur, err := albumCollection.Find(ctx, bson.D{{"_id", bson.D{{"$in", albumList.Albums}}}})
 	if err != nil {
 		log.Printf("Error accured: GetAlbumList\n%v", err)
 		return &pb.GetAlbumListReply{}, status.Error(codes.Internal, err.Error())
 	}
+	defer cur.Close(ctx)
 
-	result := &pb.GetAlbumListReply{
-		UserID: albumList.UserID,
-	}
-
+	v := newViewer(req.GetReqUserID())
 
-	for _, album := range fullAlbumList.Albums {
-		l := pb.GPSData(album.TravelPath.Location)
-		p := &pb.Path{
-			Country:  album.TravelPath.Country,
-			City:     album.TravelPath.City,
-			Location: &l,
-		}
-		a := &pb.AlbumNode{
-			//AlbumID:    album.AlbumID,
-			AlbumName:  album.AlbumName,
-			Owner:      album.Owner,
-			BeginTime:  album.BeginTime.Unix(),
-			EndTime:    album.EndTime.Unix(),
-			TravelPath: p,
+	for cur.Next(ctx) {
+		album := &model.Album{}
+		if err := cur.Decode(album); err != nil {
+			log.Printf("Error accured: GetAlbumList\n%v", err)
+			return &pb.GetAlbumListReply{}, status.Error(codes.Internal, err.Error())
 		}
 
-		result.AlbumList = append(result.AlbumList, a)
+		if v.canSeeAlbum(album) {
+			result.AlbumList = append(result.AlbumList, albumToNode(album))
+		}
 	}
 
-	//log.Printf("Result: %v\n", result.AlbumList[0].Owner)
-	// NOT YET IMPLEMENTED
-	// After aggregate
-	/*
-	if result.GetUserID() != req.GetReqUserID() {
-		reply := &pb.GetAlbumListReply{UserID: result.GetUserID()}
-		
-			for _, node := range result.AlbumList {
-				if node.IsPublic {
-					reply.AlbumList = append(reply.AlbumList, node)
-				}
-			}
-		
-
-		return reply, nil
-	}*/
-
 	return result, nil
 }
 
@@ -120,15 +92,7 @@ func (srv *albumService) DelAlbumList(ctx context.Context, req *pb.DelAlbumListR
 }
 
 func (srv *albumService) MakeAlbum(ctx context.Context, req *pb.MakeAlbumRequest) (*pb.MakeAlbumReply, error) {
-	album := &model.Album{
-		AlbumID: 		uoid.New(),
-		AlbumName:    	req.GetAlbumName(),
-		Owner:        	req.GetOwner(),
-		BeginTime:    	time.Unix(req.GetBeginTime(), 0),
-		EndTime:      	time.Unix(req.GetEndTime(), 0),
-		Members:      	req.GetMembers(),
-		PublishRange: 	req.GetPublishRange(),
-	}
+	album := newAlbum(req)
 
 	_, err := albumCollection.InsertOne(ctx, album)
 	if err != nil {
@@ -141,77 +105,114 @@ func (srv *albumService) MakeAlbum(ctx context.Context, req *pb.MakeAlbumRequest
 		isPublic = true
 	}
 
-	members := []string{album.Owner}
-	members = append(members, album.Members...)
-
-	result := addAlbumToAlbumList(members, album.AlbumID, isPublic)
+	result := addAlbumToAlbumList([]string{album.Owner}, album.AlbumID, isPublic)
 	if result != 200 {
-		return &pb.MakeAlbumReply{}, status.Error(codes.Internal, string(result))
+		return &pb.MakeAlbumReply{}, status.Error(codes.Internal, strconv.Itoa(result))
+	}
+
+	// Members join after accepting invitation
+	for _, member := range req.GetMembers() {
+		if len(member) < 1 || member == album.Owner {
+			continue
+		}
+
+		_, err := createInvitation(ctx, album, album.Owner, member, "", consts.ROLE_NONE, 0)
+		if err != nil {
+			log.Printf("Error accured: MakeAlbum(invite %v)\n%v", member, err)
+		}
 	}
 
 	return &pb.MakeAlbumReply{}, nil
 }
 
-func (srv *albumService) GetAlbum(ctx context.Context, req *pb.GetAlbumRequest) (*pb.GetAlbumReply, error) {
-	album := &model.Album{}
+// newAlbum makes album of request without members and pictures.
+// Nil slices and maps are stored as null, which $addToSet and $set of key fail on
+func newAlbum(req *pb.MakeAlbumRequest) *model.Album {
+	return &model.Album{
+		AlbumID: 		uoid.New(),
+		AlbumName:    	req.GetAlbumName(),
+		Owner:        	req.GetOwner(),
+		BeginTime:    	time.Unix(req.GetBeginTime(), 0),
+		EndTime:      	time.Unix(req.GetEndTime(), 0),
+		Members:      	[]string{},
+		Roles:        	map[string]uint32{},
+		Pictures:     	[]uoid.UOID{},
+		PublishRange: 	req.GetPublishRange(),
+	}
+}
 
-	err := albumCollection.FindOne(ctx, bson.D{{"owner", req.GetReqUserID()}, {"albumID", req.GetAlbumID()}}).Decode(album)
+// GetAlbum func
+// Album is NotFound when reqUser cannot see it,
+// pictures reqUser cannot see are left out
+func (srv *albumService) GetAlbum(ctx context.Context, req *pb.GetAlbumRequest) (*pb.GetAlbumReply, error) {
+	album, err := findAlbum(ctx, req.GetAlbumID())
 	if err != nil {
-		log.Printf("Error accured: GetAlbum\n%v", err)
-		return &pb.GetAlbumReply{}, status.Error(codes.Internal, err.Error())
+		return &pb.GetAlbumReply{}, err
 	}
 
-	var pictures []string
+	v := newViewer(req.GetReqUserID())
 
-	for _, pic := range album.Pictures {
-		pictures = append(pictures, pic.String())
+	if !v.canSeeAlbum(album) {
+		return &pb.GetAlbumReply{}, status.Error(codes.NotFound, "album not found")
+	}
+
+	pictures, err := v.albumPictureIDs(ctx, album)
+	if err != nil {
+		return &pb.GetAlbumReply{}, err
 	}
 
+	l := pb.GPSData(album.TravelPath.Location)
+
 	result := &pb.GetAlbumReply{
+		AlbumID:      album.AlbumID.String(),
 		AlbumName:    album.AlbumName,
 		Owner:        album.Owner,
 		BeginTime:    album.BeginTime.Unix(),
 		EndTime:      album.EndTime.Unix(),
+		TravelPath:   &pb.Path{Country: album.TravelPath.Country, City: album.TravelPath.City, Location: &l},
 		Members:      album.Members,
+		Roles:        album.Roles,
 		Pictures:     pictures,
 		PublishRange: album.PublishRange,
 	}
 
-
 	return result, nil
 }
 
+// GetPublicAlbum func
+// Album of dstUser as anonymous viewer sees it
 func (srv *albumService) GetPublicAlbum(ctx context.Context, req *pb.GetPublicAlbumRequest) (*pb.GetPublicAlbumReply, error) {
-	album := &model.Album{}
-
-	err := albumCollection.FindOne(ctx, bson.D{{"owner", req.DstUserID}, {"albumID", req.GetAlbumID()}}).Decode(album)
+	album, err := findAlbum(ctx, req.GetAlbumID())
 	if err != nil {
-		log.Printf("Error accured: GetPublicAlbum\n%v", err)
-		return &pb.GetPublicAlbumReply{}, status.Error(codes.Internal, err.Error())
+		return &pb.GetPublicAlbumReply{}, err
 	}
 
-	var pictures []string
+	v := newViewer("")
 
-	for _, pic := range album.Pictures {
-		pictures = append(pictures, pic.String())
+	if album.Owner != req.GetDstUserID() || !v.canSeeAlbum(album) {
+		return &pb.GetPublicAlbumReply{}, status.Error(codes.NotFound, "")
 	}
 
+	pictures, err := v.albumPictureIDs(ctx, album)
+	if err != nil {
+		return &pb.GetPublicAlbumReply{}, err
+	}
+
+	l := pb.GPSData(album.TravelPath.Location)
+
 	result := &pb.GetPublicAlbumReply{
 		AlbumID:      album.AlbumID.String(),
 		AlbumName:    album.AlbumName,
 		Owner:        album.Owner,
 		BeginTime:    album.BeginTime.Unix(),
 		EndTime:      album.EndTime.Unix(),
+		TravelPath:   &pb.Path{Country: album.TravelPath.Country, City: album.TravelPath.City, Location: &l},
 		Members:      album.Members,
 		Pictures:     pictures,
 		PublishRange: album.PublishRange,
 	}
 
-	if result.GetPublishRange() == consts.PUBLIC {
-		return result, nil
-	}
-
-	return &pb.GetPublicAlbumReply{}, status.Error(codes.NotFound, "")
+	return result, nil
 }
 
 func (srv *albumService) DelAlbum(ctx context.Context, req *pb.DelAlbumRequest) (*pb.DelAlbumReply, error) {
@@ -225,7 +226,16 @@ func (srv *albumService) DelAlbum(ctx context.Context, req *pb.DelAlbumRequest)
 }
 
 func (srv *albumService) RenameAlbum(ctx context.Context, req *pb.RenameAlbumRequest) (*pb.RenameAlbumReply, error) {
-	_, err := albumCollection.UpdateOne(ctx, bson.D{{"userID", req.GetUserID()}, {"albumID", req.GetAlbumID()}}, bson.D{{"$set", bson.D{{"albumName", req.AlbumName}}}})
+	album, err := findAlbum(ctx, req.GetAlbumID())
+	if err != nil {
+		return &pb.RenameAlbumReply{}, err
+	}
+
+	if err := checkAlbumPermission(album, req.GetUserID(), actionRename); err != nil {
+		return &pb.RenameAlbumReply{}, err
+	}
+
+	_, err = albumCollection.UpdateOne(ctx, bson.D{{"_id", album.AlbumID}}, bson.D{{"$set", bson.D{{"albumName", req.AlbumName}}}})
 	if err != nil {
 		log.Printf("Error accured: RenameAlbum\n%v", err)
 		return &pb.RenameAlbumReply{}, status.Error(codes.Internal, err.Error())
@@ -235,7 +245,16 @@ func (srv *albumService) RenameAlbum(ctx context.Context, req *pb.RenameAlbumReq
 }
 
 func (srv *albumService) EndAlbum(ctx context.Context, req *pb.EndAlbumRequest) (*pb.EndAlbumReply, error) {
-	_, err := albumCollection.UpdateOne(ctx, bson.D{{"albumID", req.GetAlbumID()}}, bson.D{{"$set", bson.D{{"endTime", time.Now().UTC()}}}})
+	album, err := findAlbum(ctx, req.GetAlbumID())
+	if err != nil {
+		return &pb.EndAlbumReply{}, err
+	}
+
+	if err := checkAlbumPermission(album, req.GetReqUserID(), actionEnd); err != nil {
+		return &pb.EndAlbumReply{}, err
+	}
+
+	_, err = albumCollection.UpdateOne(ctx, bson.D{{"_id", album.AlbumID}}, bson.D{{"$set", bson.D{{"endTime", time.Now().UTC()}}}})
 
 	if err != nil {
 		log.Printf("Error accured: EndAlbum\n%v", err)
@@ -246,7 +265,21 @@ func (srv *albumService) EndAlbum(ctx context.Context, req *pb.EndAlbumRequest)
 }
 
 func (srv *albumService) PublishAlbum(ctx context.Context, req *pb.PublishAlbumRequest) (*pb.PublishAlbumReply, error) {
-	_, err := albumCollection.UpdateOne(ctx, bson.D{{"albumID", req.GetAlbumID()}}, bson.D{{"$set", bson.D{{"publishRange", consts.PUBLIC}}}})
+	album, err := findAlbum(ctx, req.GetAlbumID())
+	if err != nil {
+		return &pb.PublishAlbumReply{}, err
+	}
+
+	if err := checkAlbumPermission(album, req.GetReqUserID(), actionPublish); err != nil {
+		return &pb.PublishAlbumReply{}, err
+	}
+
+	publishRange := consts.PRIVATE
+	if req.GetPublish() {
+		publishRange = consts.PUBLIC
+	}
+
+	_, err = albumCollection.UpdateOne(ctx, bson.D{{"_id", album.AlbumID}}, bson.D{{"$set", bson.D{{"publishRange", publishRange}}}})
 	if err != nil {
 		log.Printf("Error accured: PublishAlbum\n%v", err)
 		return &pb.PublishAlbumReply{}, status.Error(codes.Internal, err.Error())
@@ -255,41 +288,105 @@ func (srv *albumService) PublishAlbum(ctx context.Context, req *pb.PublishAlbumR
 	return &pb.PublishAlbumReply{}, nil
 }
 
+// AddMember func
+// Invite members with role, they join the album after accepting the invitation.
+// Members without role in request are invited as contributors
 func (srv *albumService) AddMember(ctx context.Context, req *pb.AddMemberRequest) (*pb.AddMemberReply, error) {
-	album := &model.Album{}
-
-	err := albumCollection.FindOne(ctx, bson.D{{"albumID", req.AlbumID}}).Decode(album)
+	album, err := findAlbum(ctx, req.GetAlbumID())
 	if err != nil {
-		return &pb.AddMemberReply{}, status.Error(codes.Internal, err.Error())
+		return &pb.AddMemberReply{}, err
 	}
 
-	isPublic := false
-	if album.PublishRange == consts.PUBLIC {
-		isPublic = true
-	}
+	for _, member := range req.GetMemberID() {
+		if len(member) < 1 {
+			continue
+		}
 
-	result := addAlbumToAlbumList(req.GetMemberID(), uoid.FromString(req.AlbumID), isPublic)
-	if result != 200 {
-		return &pb.AddMemberReply{}, nil
+		_, err := createInvitation(ctx, album, req.GetReqUserID(), member, "", req.GetRole(), 0)
+		if err != nil {
+			return &pb.AddMemberReply{}, err
+		}
 	}
 
-	return &pb.AddMemberReply{}, status.Error(codes.Internal, string(result))
+	return &pb.AddMemberReply{}, nil
 }
 
+// DelMember func
+// Editors can remove members below their role, anyone can leave the album
 func (srv *albumService) DelMember(ctx context.Context, req *pb.DelMemberRequest) (*pb.DelMemberRelpy, error) {
-	album := &model.Album{}
+	album, err := findAlbum(ctx, req.GetAlbumID())
+	if err != nil {
+		return &pb.DelMemberRelpy{}, err
+	}
 
-	err := albumCollection.FindOne(ctx, bson.D{{"albumID", req.AlbumID}}).Decode(album)
+	if len(req.GetMemberID()) < 1 {
+		return &pb.DelMemberRelpy{}, nil
+	}
+
+	unset := bson.D{}
+	for _, member := range req.GetMemberID() {
+		if member == album.Owner {
+			return &pb.DelMemberRelpy{}, status.Error(codes.PermissionDenied, "owner cannot be removed")
+		}
+
+		if member != req.GetReqUserID() {
+			if err := checkRoleChange(album, req.GetReqUserID(), member, consts.ROLE_VIEWER); err != nil {
+				return &pb.DelMemberRelpy{}, err
+			}
+		}
+
+		key, err := roleKey(member)
+		if err != nil {
+			return &pb.DelMemberRelpy{}, err
+		}
+		unset = append(unset, bson.E{key, ""})
+	}
+
+	_, err = albumCollection.UpdateOne(ctx, bson.D{{"_id", album.AlbumID}}, bson.D{
+		{"$pullAll", bson.D{{"members", req.GetMemberID()}}},
+		{"$unset", unset},
+	})
 	if err != nil {
+		log.Printf("Error accured: DelMember\n%v", err)
 		return &pb.DelMemberRelpy{}, status.Error(codes.Internal, err.Error())
 	}
 
-	result := deleteAlbumFromAlbumList(req.GetMemberID(), uoid.FromString(req.AlbumID))
+	result := deleteAlbumFromAlbumList(req.GetMemberID(), album.AlbumID)
 	if result != 200 {
-		return &pb.DelMemberRelpy{}, nil
+		return &pb.DelMemberRelpy{}, status.Error(codes.Internal, strconv.Itoa(result))
+	}
+
+	return &pb.DelMemberRelpy{}, nil
+}
+
+// SetMemberRole func
+// Change role of existing member
+func (srv *albumService) SetMemberRole(ctx context.Context, req *pb.SetMemberRoleRequest) (*pb.SetMemberRoleReply, error) {
+	album, err := findAlbum(ctx, req.GetAlbumID())
+	if err != nil {
+		return &pb.SetMemberRoleReply{}, err
+	}
+
+	if memberRole(album, req.GetMemberID()) == consts.ROLE_NONE {
+		return &pb.SetMemberRoleReply{}, status.Error(codes.NotFound, "member not found")
+	}
+
+	if err := checkRoleChange(album, req.GetReqUserID(), req.GetMemberID(), req.GetRole()); err != nil {
+		return &pb.SetMemberRoleReply{}, err
+	}
+
+	key, err := roleKey(req.GetMemberID())
+	if err != nil {
+		return &pb.SetMemberRoleReply{}, err
+	}
+
+	_, err = albumCollection.UpdateOne(ctx, bson.D{{"_id", album.AlbumID}}, bson.D{{"$set", bson.D{{key, req.GetRole()}}}})
+	if err != nil {
+		log.Printf("Error accured: SetMemberRole\n%v", err)
+		return &pb.SetMemberRoleReply{}, status.Error(codes.Internal, err.Error())
 	}
 
-	return &pb.DelMemberRelpy{}, status.Error(codes.Internal, string(result))
+	return &pb.SetMemberRoleReply{}, nil
 }
 
 func (srv *albumService) ArchiveAlbum(ctx context.Context, req *pb.ArchiveAlbumRequest) (*pb.ArchiveAlbumReply, error) {
@@ -308,65 +405,61 @@ func (srv *albumService) ArchiveAlbum(ctx context.Context, req *pb.ArchiveAlbumR
 	return &pb.ArchiveAlbumReply{}, nil
 }
 
-// TODO: update member's albumlist
 // TODO: implement transaction
+// Failed update is 500 and user without album list is 404, other users are still updated
 func addAlbumToAlbumList(userID []string, albumID uoid.UOID, isPublic bool) int {
 	ctx := context.Background()
+	code := 200
+
 	for _, user := range userID {
-		albumList := &model.AlbumList{}
-		err := albumListCollection.FindOne(ctx, bson.D{{"_id", user}}).Decode(albumList)
+		result, err := albumListCollection.UpdateOne(ctx, bson.D{{"_id", user}}, bson.D{{"$addToSet", bson.D{{"albums", albumID}}}})
 		if err != nil {
-			log.Println(err)
+			log.Printf("Update fail %v\n", err)
+			code = 500
 			continue
 		}
 
-		if len(albumList.Albums) < 1 {
-			albumList.Albums = append(albumList.Albums, albumID)
-		} else {
-			// check if already exists
-			for _, album := range albumList.Albums {
-				if album == albumID {
-					continue
-				}
-				albumList.Albums = append(albumList.Albums, albumID)
-			}
-		}
-
-		log.Println(albumList.Albums)
-
-		_, err = albumListCollection.ReplaceOne(ctx, bson.D{{"_id", user}}, albumList)
-		if err != nil {
-			log.Printf("Replace fail %v\n", err)
-			continue
+		if result.MatchedCount == 0 {
+			log.Printf("Update fail: no album list of %v\n", user)
+			code = 404
 		}
 	}
 
-	return 200
+	return code
 }
 
-// TODO: update member's albumlist
 func deleteAlbumFromAlbumList(userID []string, albumID uoid.UOID) int {
 	ctx := context.Background()
 
 	for _, user := range userID {
-		albumList := &model.AlbumList{}
-
-		err := albumListCollection.FindOne(ctx, bson.D{{"_id", user}}).Decode(albumList)
+		_, err := albumListCollection.UpdateOne(ctx, bson.D{{"_id", user}}, bson.D{{"$pull", bson.D{{"albums", albumID}}}})
 		if err != nil {
+			log.Printf("Update fail %v\n", err)
 			continue
 		}
+	}
 
-		for idx, album := range albumList.Albums {
-			if album == albumID {
-				albumList.Albums = append(albumList.Albums[:idx], albumList.Albums[idx+1:]...)
-			}
-		}
+	return 200
+}
 
-		_, err = albumListCollection.UpdateOne(ctx, bson.D{{"_id", user}}, albumList)
-		if err != nil {
-			continue
-		}
+func albumToNode(album *model.Album) *pb.AlbumNode {
+	l := pb.GPSData(album.TravelPath.Location)
+	pictures := []string{}
+
+	for _, pic := range album.Pictures {
+		pictures = append(pictures, pic.String())
 	}
 
-	return 200
+	return &pb.AlbumNode{
+		AlbumID:      album.AlbumID.String(),
+		AlbumName:    album.AlbumName,
+		Owner:        album.Owner,
+		BeginTime:    album.BeginTime.Unix(),
+		EndTime:      album.EndTime.Unix(),
+		TravelPath:   &pb.Path{Country: album.TravelPath.Country, City: album.TravelPath.City, Location: &l},
+		PublishRange: album.PublishRange,
+		Members:      album.Members,
+		Pictures:     pictures,
+		Archived:     album.Archived,
+	}
 }
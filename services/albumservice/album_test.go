@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	pb "github.com/farerpath/albumservice/proto"
+	"github.com/farerpath/server/model/consts"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// useTestDB points collections to new database of FP_TEST_DB_ADDRESS,
+// test is skipped without it. Returned func drops the database
+func useTestDB(t *testing.T) func() {
+	addr := os.Getenv("FP_TEST_DB_ADDRESS")
+	if len(addr) < 1 {
+		t.Skip("FP_TEST_DB_ADDRESS not set")
+	}
+
+	ctx := context.Background()
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	db := client.Database("farerpath_test_" + string(uoid.New()))
+
+	albumCollection = db.Collection(ALBUMDB)
+	albumListCollection = db.Collection(ALBUMLISTDB)
+	pictureCollection = db.Collection(PICTUREDB)
+	niceShotCollection = db.Collection(NICESHOTDB)
+	invitationCollection = db.Collection(INVITATIONDB)
+	shareLinkCollection = db.Collection(SHARELINKDB)
+
+	return func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	}
+}
+
+func TestNewAlbumStoresEmptyFields(t *testing.T) {
+	raw, err := bson.Marshal(newAlbum(&pb.MakeAlbumRequest{AlbumName: "Trip", Owner: testOwner}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]bsontype.Type{"members": bsontype.Array, "roles": bsontype.EmbeddedDocument, "pictures": bsontype.Array}
+
+	for field, want := range fields {
+		if got := bson.Raw(raw).Lookup(field).Type; got != want {
+			t.Errorf("album %v stored as %v, want %v", field, got, want)
+		}
+	}
+}
+
+func TestMakeAlbumAndJoin(t *testing.T) {
+	defer useTestDB(t)()
+
+	ctx := context.Background()
+	srv := &albumService{}
+
+	for _, user := range []string{testOwner, testMember} {
+		if _, err := srv.MakeAlbumList(ctx, &pb.MakeAlbumListRequest{ReqUserID: user}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := srv.MakeAlbum(ctx, &pb.MakeAlbumRequest{AlbumName: "Trip", Owner: testOwner, PublishRange: consts.MEMBER}); err != nil {
+		t.Fatal(err)
+	}
+
+	album := &model.Album{}
+	if err := albumCollection.FindOne(ctx, bson.D{{"owner", testOwner}}).Decode(album); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := joinAlbum(ctx, album, testMember, consts.ROLE_VIEWER); err != nil {
+		t.Fatalf("joinAlbum failed: %v", err)
+	}
+
+	_, err := srv.SetMemberRole(ctx, &pb.SetMemberRoleRequest{
+		ReqUserID: testOwner,
+		AlbumID:   album.AlbumID.String(),
+		MemberID:  testMember,
+		Role:      consts.ROLE_CONTRIBUTOR,
+	})
+	if err != nil {
+		t.Fatalf("SetMemberRole failed: %v", err)
+	}
+
+	_, err = srv.MakePicture(ctx, &pb.MakePictureRequest{PictureID: "picture", PictureName: "picture.jpg", Owner: testMember, PublishRange: consts.MEMBER})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = srv.AddPicture(ctx, &pb.AddPictureRequest{ReqUserID: testMember, PictureID: "picture", AlbumID: album.AlbumID.String()})
+	if err != nil {
+		t.Fatalf("AddPicture failed: %v", err)
+	}
+
+	album, err = findAlbum(ctx, album.AlbumID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(album.Members) != 1 || album.Members[0] != testMember {
+		t.Errorf("album members = %v", album.Members)
+	}
+	if album.Roles[testMember] != consts.ROLE_CONTRIBUTOR {
+		t.Errorf("member role = %v, want %v", album.Roles[testMember], consts.ROLE_CONTRIBUTOR)
+	}
+	if len(album.Pictures) != 1 || album.Pictures[0] != "picture" {
+		t.Errorf("album pictures = %v", album.Pictures)
+	}
+
+	picture, err := findPicture(ctx, "picture")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(picture.Albums) != 1 || picture.Albums[0] != album.AlbumID.String() {
+		t.Errorf("picture albums = %v", picture.Albums)
+	}
+
+	for _, user := range []string{testOwner, testMember} {
+		list := &model.AlbumList{}
+		if err := albumListCollection.FindOne(ctx, bson.D{{"_id", user}}).Decode(list); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list.Albums) != 1 || list.Albums[0] != album.AlbumID {
+			t.Errorf("album list of %v = %v", user, list.Albums)
+		}
+	}
+
+	// Member range picture is visible through album membership
+	ok, err := newViewer(testOwner).canSeePicture(ctx, picture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("owner of album cannot see member range picture of album")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/farerpath/albumservice/proto"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"log"
+)
+
+const maxCommentLength = 1000
+
+// AddComment func
+// Add comment or reply to picture
+// Reply when comment.parentID is set
+func (srv *albumService) AddComment(ctx context.Context, req *pb.AddCommentRequest) (*pb.AddCommentReply, error) {
+	value := req.GetComment().GetValue()
+	if len(value) < 1 || len(value) > maxCommentLength {
+		return &pb.AddCommentReply{}, status.Error(codes.InvalidArgument, "comment length invalid")
+	}
+
+	picture, err := findPicture(ctx, req.GetPictureID())
+	if err != nil {
+		return &pb.AddCommentReply{}, err
+	}
+
+	if err := checkPictureVisible(ctx, picture, req.GetReqUserID()); err != nil {
+		return &pb.AddCommentReply{}, err
+	}
+
+	parentID := uoid.FromString(req.GetComment().GetParentID())
+	if parentID != "" && findComment(picture.Comments, parentID) == nil {
+		return &pb.AddCommentReply{}, status.Error(codes.NotFound, "parent comment not found")
+	}
+
+	comment := model.Comment{
+		CommentID: uoid.New(),
+		ParentID:  parentID,
+		Owner:     req.GetReqUserID(),
+		UserName:  req.GetComment().GetUserName(),
+		Value:     value,
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		// Empty array, edits are pushed to it
+		EditHistory: []model.CommentEdit{},
+	}
+
+	_, err = pictureCollection.UpdateOne(ctx, bson.D{{"_id", picture.PictureID}}, bson.D{{"$push", bson.D{{"comments", comment}}}})
+	if err != nil {
+		log.Printf("Error accured: AddComment\n%v", err)
+		return &pb.AddCommentReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.AddCommentReply{Comment: commentToPb(comment)}, nil
+}
+
+// EditComment func
+// Only comment owner can edit, previous value is kept in edit history
+func (srv *albumService) EditComment(ctx context.Context, req *pb.EditCommentRequest) (*pb.EditCommentReply, error) {
+	if len(req.GetValue()) < 1 || len(req.GetValue()) > maxCommentLength {
+		return &pb.EditCommentReply{}, status.Error(codes.InvalidArgument, "comment length invalid")
+	}
+
+	picture, err := findPicture(ctx, req.GetPictureID())
+	if err != nil {
+		return &pb.EditCommentReply{}, err
+	}
+
+	comment := findComment(picture.Comments, uoid.FromString(req.GetCommentID()))
+	if comment == nil {
+		return &pb.EditCommentReply{}, status.Error(codes.NotFound, "comment not found")
+	}
+
+	if comment.Owner != req.GetReqUserID() {
+		return &pb.EditCommentReply{}, status.Error(codes.PermissionDenied, "")
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	previous := model.CommentEdit{Value: comment.Value, Time: comment.Time}
+	if comment.EditedTime != "" {
+		previous.Time = comment.EditedTime
+	}
+
+	_, err = pictureCollection.UpdateOne(ctx,
+		bson.D{{"_id", picture.PictureID}, {"comments._id", comment.CommentID}},
+		bson.D{
+			{"$set", bson.D{{"comments.$.value", req.GetValue()}, {"comments.$.editedTime", now}}},
+			{"$push", bson.D{{"comments.$.editHistory", previous}}},
+		})
+	if err != nil {
+		log.Printf("Error accured: EditComment\n%v", err)
+		return &pb.EditCommentReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	comment.EditHistory = append(comment.EditHistory, previous)
+	comment.Value = req.GetValue()
+	comment.EditedTime = now
+
+	return &pb.EditCommentReply{Comment: commentToPb(*comment)}, nil
+}
+
+// DelComment func
+// Comment owner or picture owner can delete, replies are removed with it
+func (srv *albumService) DelComment(ctx context.Context, req *pb.DelCommentRequest) (*pb.DelCommentReply, error) {
+	picture, err := findPicture(ctx, req.GetPictureID())
+	if err != nil {
+		return &pb.DelCommentReply{}, err
+	}
+
+	comment := findComment(picture.Comments, uoid.FromString(req.GetCommentID()))
+	if comment == nil {
+		return &pb.DelCommentReply{}, status.Error(codes.NotFound, "comment not found")
+	}
+
+	if comment.Owner != req.GetReqUserID() && picture.Owner != req.GetReqUserID() {
+		return &pb.DelCommentReply{}, status.Error(codes.PermissionDenied, "")
+	}
+
+	thread := commentThread(picture.Comments, comment.CommentID)
+
+	_, err = pictureCollection.UpdateOne(ctx, bson.D{{"_id", picture.PictureID}}, bson.D{{"$pull", bson.D{{"comments", bson.D{{"_id", bson.D{{"$in", thread}}}}}}}})
+	if err != nil {
+		log.Printf("Error accured: DelComment\n%v", err)
+		return &pb.DelCommentReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.DelCommentReply{}, nil
+}
+
+func (srv *albumService) GetComments(ctx context.Context, req *pb.GetCommentsRequest) (*pb.GetCommentsReply, error) {
+	picture, err := findPicture(ctx, req.GetPictureID())
+	if err != nil {
+		return &pb.GetCommentsReply{}, err
+	}
+
+	if err := checkPictureVisible(ctx, picture, req.GetReqUserID()); err != nil {
+		return &pb.GetCommentsReply{}, err
+	}
+
+	result := &pb.GetCommentsReply{}
+
+	for _, comment := range picture.Comments {
+		result.Comments = append(result.Comments, commentToPb(comment))
+	}
+
+	return result, nil
+}
+
+func findPicture(ctx context.Context, pictureID string) (*model.Picture, error) {
+	picture := &model.Picture{}
+
+	err := pictureCollection.FindOne(ctx, bson.D{{"_id", pictureID}}).Decode(picture)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, status.Error(codes.NotFound, "picture not found")
+		}
+		log.Printf("Error accured: findPicture\n%v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return picture, nil
+}
+
+func findComment(comments []model.Comment, commentID uoid.UOID) *model.Comment {
+	for idx := range comments {
+		if comments[idx].CommentID == commentID {
+			return &comments[idx]
+		}
+	}
+
+	return nil
+}
+
+// commentThread returns commentID and IDs of every reply below it
+func commentThread(comments []model.Comment, commentID uoid.UOID) []uoid.UOID {
+	thread := []uoid.UOID{commentID}
+
+	for i := 0; i < len(thread); i++ {
+		for _, comment := range comments {
+			if comment.ParentID == thread[i] {
+				thread = append(thread, comment.CommentID)
+			}
+		}
+	}
+
+	return thread
+}
+
+func commentToPb(comment model.Comment) *pb.Comment {
+	history := []*pb.CommentEdit{}
+
+	for _, edit := range comment.EditHistory {
+		history = append(history, &pb.CommentEdit{Value: edit.Value, Time: edit.Time})
+	}
+
+	return &pb.Comment{
+		Owner:       comment.Owner,
+		UserName:    comment.UserName,
+		Value:       comment.Value,
+		Time:        comment.Time,
+		CommentID:   comment.CommentID.String(),
+		ParentID:    comment.ParentID.String(),
+		EditedTime:  comment.EditedTime,
+		EditHistory: history,
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"net"
@@ -20,18 +21,27 @@ const port = ":17080"
 
 var (
 	DBADDR = "mongodb://maindb-service:27017"
+
+	// SHARESECRET : key to sign share link tokens, FP_SHARE_SECRET is required
+	SHARESECRET = ""
 )
 
 const (
-	ALBUMLISTDB = "albumlists"
-	ALBUMDB     = "albums"
-	PICTUREDB   = "pictures"
+	ALBUMLISTDB  = "albumlists"
+	ALBUMDB      = "albums"
+	PICTUREDB    = "pictures"
+	NICESHOTDB   = "niceshots"
+	INVITATIONDB = "invitations"
+	SHARELINKDB  = "sharelinks"
 )
 
 var (
-	albumCollection     *mongo.Collection
-	albumListCollection *mongo.Collection
-	pictureCollection   *mongo.Collection
+	albumCollection      *mongo.Collection
+	albumListCollection  *mongo.Collection
+	pictureCollection    *mongo.Collection
+	niceShotCollection   *mongo.Collection
+	invitationCollection *mongo.Collection
+	shareLinkCollection  *mongo.Collection
 )
 
 func init() {
@@ -39,9 +49,18 @@ func init() {
 		log.Printf("DB address received: %v\n", addr)
 		DBADDR = addr
 	}
+
+	if secret := os.Getenv("FP_SHARE_SECRET"); len(secret) > 1 {
+		SHARESECRET = secret
+	}
 }
 
 func main() {
+	// Share tokens could be forged with secret known from source
+	if len(SHARESECRET) < 1 {
+		log.Fatalln("FP_SHARE_SECRET not set, share links cannot be signed")
+	}
+
 	client, err := mongo.NewClient(options.Client().ApplyURI(DBADDR))
 	if err != nil {
 		log.Fatalf("DB Connection failed:\n%v", err)
@@ -55,6 +74,11 @@ func main() {
 	albumCollection = client.Database("farerpath").Collection(ALBUMDB)
 	albumListCollection = client.Database("farerpath").Collection(ALBUMLISTDB)
 	pictureCollection = client.Database("farerpath").Collection(PICTUREDB)
+	niceShotCollection = client.Database("farerpath").Collection(NICESHOTDB)
+	invitationCollection = client.Database("farerpath").Collection(INVITATIONDB)
+	shareLinkCollection = client.Database("farerpath").Collection(SHARELINKDB)
+
+	migrate(context.Background())
 
 	listener, err := net.Listen("tcp", port)
 	if err != nil {
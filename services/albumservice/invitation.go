@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/farerpath/albumservice/proto"
+	"github.com/farerpath/server/model/consts"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"log"
+)
+
+const (
+	defaultInvitationDuration = time.Hour * 24 * 7
+	maxInvitationDuration     = time.Hour * 24 * 30
+)
+
+// InviteMember func
+// Invite user by user ID or email
+func (srv *albumService) InviteMember(ctx context.Context, req *pb.InviteMemberRequest) (*pb.InviteMemberReply, error) {
+	if len(req.GetInviteeID()) < 1 && len(req.GetInviteeEmail()) < 1 {
+		return &pb.InviteMemberReply{}, status.Error(codes.InvalidArgument, "invitee not filled")
+	}
+
+	album, err := findAlbum(ctx, req.GetAlbumID())
+	if err != nil {
+		return &pb.InviteMemberReply{}, err
+	}
+
+	invitation, err := createInvitation(ctx, album, req.GetReqUserID(), req.GetInviteeID(), req.GetInviteeEmail(), req.GetRole(), time.Duration(req.GetExpiresIn())*time.Second)
+	if err != nil {
+		return &pb.InviteMemberReply{}, err
+	}
+
+	return &pb.InviteMemberReply{Invitation: invitationToPb(invitation)}, nil
+}
+
+// GetInvitations func
+// Pending invitations sent to requester's user ID or email
+func (srv *albumService) GetInvitations(ctx context.Context, req *pb.GetInvitationsRequest) (*pb.GetInvitationsReply, error) {
+	invitee := bson.A{bson.D{{"inviteeID", req.GetReqUserID()}}}
+	if len(req.GetReqEmail()) > 0 {
+		invitee = append(invitee, bson.D{{"inviteeEmail", req.GetReqEmail()}})
+	}
+
+	return findInvitations(ctx, bson.D{
+		{"$or", invitee},
+		{"status", consts.INVITATION_PENDING},
+		{"expireTime", bson.D{{"$gt", time.Now().UTC()}}},
+	})
+}
+
+// GetAlbumInvitations func
+// Pending invitations of album, for members who can manage members
+func (srv *albumService) GetAlbumInvitations(ctx context.Context, req *pb.GetAlbumInvitationsRequest) (*pb.GetInvitationsReply, error) {
+	album, err := findAlbum(ctx, req.GetAlbumID())
+	if err != nil {
+		return &pb.GetInvitationsReply{}, err
+	}
+
+	if err := checkAlbumPermission(album, req.GetReqUserID(), actionManageMember); err != nil {
+		return &pb.GetInvitationsReply{}, err
+	}
+
+	return findInvitations(ctx, bson.D{
+		{"albumID", album.AlbumID},
+		{"status", consts.INVITATION_PENDING},
+		{"expireTime", bson.D{{"$gt", time.Now().UTC()}}},
+	})
+}
+
+// AnswerInvitation func
+// Accept or decline invitation, accepting adds requester to album members
+func (srv *albumService) AnswerInvitation(ctx context.Context, req *pb.AnswerInvitationRequest) (*pb.AnswerInvitationReply, error) {
+	invitation, err := findInvitation(ctx, req.GetInvitationID())
+	if err != nil {
+		return &pb.AnswerInvitationReply{}, err
+	}
+
+	isInvitee := invitation.InviteeID == req.GetReqUserID() ||
+		(len(invitation.InviteeEmail) > 0 && invitation.InviteeEmail == req.GetReqEmail())
+	if !isInvitee {
+		return &pb.AnswerInvitationReply{}, status.Error(codes.NotFound, "invitation not found")
+	}
+
+	if invitation.Status != consts.INVITATION_PENDING || !invitation.ExpireTime.After(time.Now()) {
+		return &pb.AnswerInvitationReply{}, status.Error(codes.FailedPrecondition, "invitation is not pending")
+	}
+
+	answer := uint32(consts.INVITATION_DECLINED)
+
+	if req.GetAccept() {
+		answer = consts.INVITATION_ACCEPTED
+
+		album, err := findAlbum(ctx, invitation.AlbumID.String())
+		if err != nil {
+			return &pb.AnswerInvitationReply{}, err
+		}
+
+		// User joins before invitation is used up, so failed accept can be answered again.
+		// Joining again is harmless
+		err = joinAlbum(ctx, album, req.GetReqUserID(), invitation.Role)
+		if err != nil {
+			return &pb.AnswerInvitationReply{}, err
+		}
+	}
+
+	err = closeInvitation(ctx, invitation, answer, req.GetReqUserID())
+	if err != nil {
+		return &pb.AnswerInvitationReply{}, err
+	}
+
+	return &pb.AnswerInvitationReply{}, nil
+}
+
+// RevokeInvitation func
+// Inviter or members who can manage members can revoke pending invitation
+func (srv *albumService) RevokeInvitation(ctx context.Context, req *pb.RevokeInvitationRequest) (*pb.RevokeInvitationReply, error) {
+	invitation, err := findInvitation(ctx, req.GetInvitationID())
+	if err != nil {
+		return &pb.RevokeInvitationReply{}, err
+	}
+
+	if invitation.Inviter != req.GetReqUserID() {
+		album, err := findAlbum(ctx, invitation.AlbumID.String())
+		if err != nil {
+			return &pb.RevokeInvitationReply{}, err
+		}
+
+		if err := checkAlbumPermission(album, req.GetReqUserID(), actionManageMember); err != nil {
+			return &pb.RevokeInvitationReply{}, err
+		}
+	}
+
+	err = closeInvitation(ctx, invitation, consts.INVITATION_REVOKED, invitation.InviteeID)
+	if err != nil {
+		return &pb.RevokeInvitationReply{}, err
+	}
+
+	return &pb.RevokeInvitationReply{}, nil
+}
+
+// createInvitation checks inviter's permission and stores pending invitation.
+// role ROLE_NONE invites as contributor, expiresIn 0 uses default duration
+func createInvitation(ctx context.Context, album *model.Album, inviter, inviteeID, inviteeEmail string, role uint32, expiresIn time.Duration) (*model.Invitation, error) {
+	if role == consts.ROLE_NONE {
+		role = consts.ROLE_CONTRIBUTOR
+	}
+
+	if err := checkRoleChange(album, inviter, inviteeID, role); err != nil {
+		return nil, err
+	}
+
+	if len(inviteeID) > 0 {
+		if _, err := roleKey(inviteeID); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(inviteeID) > 0 && memberRole(album, inviteeID) != consts.ROLE_NONE {
+		return nil, status.Error(codes.AlreadyExists, "already a member")
+	}
+
+	if expiresIn <= 0 {
+		expiresIn = defaultInvitationDuration
+	} else if expiresIn > maxInvitationDuration {
+		expiresIn = maxInvitationDuration
+	}
+
+	invitee := bson.D{{"inviteeID", inviteeID}}
+	if len(inviteeEmail) > 0 {
+		invitee = bson.D{{"inviteeEmail", inviteeEmail}}
+	}
+
+	n, err := invitationCollection.CountDocuments(ctx, append(invitee,
+		bson.E{"albumID", album.AlbumID},
+		bson.E{"status", consts.INVITATION_PENDING},
+		bson.E{"expireTime", bson.D{{"$gt", time.Now().UTC()}}},
+	))
+	if err != nil {
+		log.Printf("Error accured: createInvitation\n%v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if n != 0 {
+		return nil, status.Error(codes.AlreadyExists, "already invited")
+	}
+
+	now := time.Now().UTC()
+	invitation := &model.Invitation{
+		InvitationID: uoid.New(),
+		AlbumID:      album.AlbumID,
+		AlbumName:    album.AlbumName,
+		Inviter:      inviter,
+		InviteeID:    inviteeID,
+		InviteeEmail: inviteeEmail,
+		Role:         role,
+		Status:       consts.INVITATION_PENDING,
+		CreatedTime:  now,
+		ExpireTime:   now.Add(expiresIn),
+	}
+
+	_, err = invitationCollection.InsertOne(ctx, invitation)
+	if err != nil {
+		log.Printf("Error accured: createInvitation\n%v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return invitation, nil
+}
+
+// closeInvitation moves pending invitation to final status.
+// Fails with FailedPrecondition when it was answered, revoked or expired meanwhile
+func closeInvitation(ctx context.Context, invitation *model.Invitation, result uint32, inviteeID string) error {
+	updated, err := invitationCollection.UpdateOne(ctx,
+		bson.D{
+			{"_id", invitation.InvitationID},
+			{"status", consts.INVITATION_PENDING},
+			{"expireTime", bson.D{{"$gt", time.Now().UTC()}}},
+		},
+		bson.D{{"$set", bson.D{{"status", result}, {"inviteeID", inviteeID}}}})
+	if err != nil {
+		log.Printf("Error accured: closeInvitation\n%v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if updated.ModifiedCount == 0 {
+		return status.Error(codes.FailedPrecondition, "invitation is not pending")
+	}
+
+	return nil
+}
+
+// joinAlbum adds user to album members and album to user's album list
+func joinAlbum(ctx context.Context, album *model.Album, userID string, role uint32) error {
+	key, err := roleKey(userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = albumCollection.UpdateOne(ctx, bson.D{{"_id", album.AlbumID}}, bson.D{
+		{"$addToSet", bson.D{{"members", userID}}},
+		{"$set", bson.D{{key, role}}},
+	})
+	if err != nil {
+		log.Printf("Error accured: joinAlbum\n%v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if result := addAlbumToAlbumList([]string{userID}, album.AlbumID, album.PublishRange == consts.PUBLIC); result != 200 {
+		return status.Error(codes.Internal, "failed to update album list")
+	}
+
+	return nil
+}
+
+func findInvitation(ctx context.Context, invitationID string) (*model.Invitation, error) {
+	invitation := &model.Invitation{}
+
+	err := invitationCollection.FindOne(ctx, bson.D{{"_id", invitationID}}).Decode(invitation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, status.Error(codes.NotFound, "invitation not found")
+		}
+		log.Printf("Error accured: findInvitation\n%v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return invitation, nil
+}
+
+func findInvitations(ctx context.Context, filter bson.D) (*pb.GetInvitationsReply, error) {
+	cur, err := invitationCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{"createdTime", -1}}))
+	if err != nil {
+		log.Printf("Error accured: findInvitations\n%v", err)
+		return &pb.GetInvitationsReply{}, status.Error(codes.Internal, err.Error())
+	}
+	defer cur.Close(ctx)
+
+	result := &pb.GetInvitationsReply{}
+
+	for cur.Next(ctx) {
+		invitation := &model.Invitation{}
+		if err := cur.Decode(invitation); err != nil {
+			log.Printf("Error accured: findInvitations\n%v", err)
+			continue
+		}
+
+		result.Invitations = append(result.Invitations, invitationToPb(invitation))
+	}
+
+	return result, nil
+}
+
+func invitationToPb(invitation *model.Invitation) *pb.Invitation {
+	return &pb.Invitation{
+		InvitationID: invitation.InvitationID.String(),
+		AlbumID:      invitation.AlbumID.String(),
+		AlbumName:    invitation.AlbumName,
+		Inviter:      invitation.Inviter,
+		InviteeID:    invitation.InviteeID,
+		InviteeEmail: invitation.InviteeEmail,
+		Role:         invitation.Role,
+		Status:       invitation.Status,
+		CreatedTime:  invitation.CreatedTime.Unix(),
+		ExpireTime:   invitation.ExpireTime.Unix(),
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"log"
+)
+
+// migrate func
+// Run idempotent schema migrations on start up.
+// Failures are logged only, service keeps running with old documents
+func migrate(ctx context.Context) {
+	// countNiceShot was stored under misspelled "countNiceSot"
+	result, err := pictureCollection.UpdateMany(ctx,
+		bson.D{{"countNiceSot", bson.D{{"$exists", true}}}},
+		bson.D{{"$rename", bson.D{{"countNiceSot", "countNiceShot"}}}})
+	if err != nil {
+		log.Printf("Migration failed: countNiceSot\n%v", err)
+	} else if result.ModifiedCount > 0 {
+		log.Printf("Migration: renamed countNiceSot of %v pictures\n", result.ModifiedCount)
+	}
+
+	// Counter may be left behind by failed write between reaction and counter
+	if fixed, err := countNiceShots(ctx); err != nil {
+		log.Printf("Migration failed: countNiceShot\n%v", err)
+	} else if fixed > 0 {
+		log.Printf("Migration: counted niceshots of %v pictures\n", fixed)
+	}
+
+	// Nil slices were stored as null, $push and $addToSet fail on null
+	setEmptyWhenNull(ctx, pictureCollection, "comments", bson.A{})
+	setEmptyWhenNull(ctx, pictureCollection, "albums", bson.A{})
+	setEmptyWhenNull(ctx, albumCollection, "members", bson.A{})
+	setEmptyWhenNull(ctx, albumCollection, "roles", bson.D{})
+	setEmptyWhenNull(ctx, albumCollection, "pictures", bson.A{})
+	setEmptyWhenNull(ctx, albumListCollection, "albums", bson.A{})
+
+	result, err = pictureCollection.UpdateMany(ctx,
+		bson.D{{"comments", bson.D{{"$elemMatch", bson.D{{"editHistory", nil}}}}}},
+		bson.D{{"$set", bson.D{{"comments.$[c].editHistory", bson.A{}}}}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.D{{"c.editHistory", nil}}}}))
+	if err != nil {
+		log.Printf("Migration failed: null editHistory\n%v", err)
+	} else if result.ModifiedCount > 0 {
+		log.Printf("Migration: set editHistory of comments of %v pictures\n", result.ModifiedCount)
+	}
+
+	_, err = niceShotCollection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{"pictureID", 1}}})
+	if err != nil {
+		log.Printf("Migration failed: niceshots index\n%v", err)
+	}
+}
+
+// setEmptyWhenNull sets null or missing field of documents to empty
+func setEmptyWhenNull(ctx context.Context, collection *mongo.Collection, field string, empty interface{}) {
+	result, err := collection.UpdateMany(ctx, bson.D{{field, nil}}, bson.D{{"$set", bson.D{{field, empty}}}})
+	if err != nil {
+		log.Printf("Migration failed: null %v of %v\n%v", field, collection.Name(), err)
+	} else if result.ModifiedCount > 0 {
+		log.Printf("Migration: set %v of %v %v\n", field, result.ModifiedCount, collection.Name())
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/farerpath/albumservice/proto"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"log"
+)
+
+// duplicate key error code of MongoDB
+const errDuplicateKey = 11000
+
+// AddNiceShot func
+// Record user's reaction to picture and increase counter.
+// Counter is changed only when reaction is added, so it is atomic with concurrent reactions
+func (srv *albumService) AddNiceShot(ctx context.Context, req *pb.AddNiceShotRequest) (*pb.AddNiceShotReply, error) {
+	picture, err := findPicture(ctx, req.GetPictureID())
+	if err != nil {
+		return &pb.AddNiceShotReply{}, err
+	}
+
+	if err := checkPictureVisible(ctx, picture, req.GetReqUserID()); err != nil {
+		return &pb.AddNiceShotReply{}, err
+	}
+
+	niceShot := &model.NiceShot{
+		NiceShotID: niceShotID(picture.PictureID.String(), req.GetReqUserID()),
+		PictureID:  picture.PictureID,
+		UserID:     req.GetReqUserID(),
+		Time:       time.Now().UTC(),
+	}
+
+	_, err = niceShotCollection.InsertOne(ctx, niceShot)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return &pb.AddNiceShotReply{CountNiceShots: int32(picture.CountNiceShot)}, status.Error(codes.AlreadyExists, "")
+		}
+		log.Printf("Error accured: AddNiceShot\n%v", err)
+		return &pb.AddNiceShotReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	count, err := incNiceShot(ctx, picture.PictureID, 1)
+	if err != nil {
+		log.Printf("Error accured: AddNiceShot\n%v", err)
+		return &pb.AddNiceShotReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.AddNiceShotReply{CountNiceShots: count}, nil
+}
+
+// SubNiceShot func
+// Remove user's reaction from picture and decrease counter
+func (srv *albumService) SubNiceShot(ctx context.Context, req *pb.SubNiceShotRequest) (*pb.SubNiceShotReply, error) {
+	picture, err := findPicture(ctx, req.GetPictureID())
+	if err != nil {
+		return &pb.SubNiceShotReply{}, err
+	}
+
+	result, err := niceShotCollection.DeleteOne(ctx, bson.D{{"_id", niceShotID(picture.PictureID.String(), req.GetReqUserID())}})
+	if err != nil {
+		log.Printf("Error accured: SubNiceShot\n%v", err)
+		return &pb.SubNiceShotReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.DeletedCount == 0 {
+		return &pb.SubNiceShotReply{CountNiceShots: int32(picture.CountNiceShot)}, status.Error(codes.NotFound, "")
+	}
+
+	count, err := incNiceShot(ctx, picture.PictureID, -1)
+	if err != nil {
+		log.Printf("Error accured: SubNiceShot\n%v", err)
+		return &pb.SubNiceShotReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.SubNiceShotReply{CountNiceShots: count}, nil
+}
+
+// GetNiceShots func
+// List users who reacted to picture
+func (srv *albumService) GetNiceShots(ctx context.Context, req *pb.GetNiceShotsRequest) (*pb.GetNiceShotsReply, error) {
+	picture, err := findPicture(ctx, req.GetPictureID())
+	if err != nil {
+		return &pb.GetNiceShotsReply{}, err
+	}
+
+	if err := checkPictureVisible(ctx, picture, req.GetReqUserID()); err != nil {
+		return &pb.GetNiceShotsReply{}, err
+	}
+
+	cur, err := niceShotCollection.Find(ctx, bson.D{{"pictureID", picture.PictureID}}, options.Find().SetSort(bson.D{{"time", 1}}))
+	if err != nil {
+		log.Printf("Error accured: GetNiceShots\n%v", err)
+		return &pb.GetNiceShotsReply{}, status.Error(codes.Internal, err.Error())
+	}
+	defer cur.Close(ctx)
+
+	result := &pb.GetNiceShotsReply{}
+
+	for cur.Next(ctx) {
+		niceShot := model.NiceShot{}
+		if err := cur.Decode(&niceShot); err != nil {
+			log.Printf("Error accured: GetNiceShots\n%v", err)
+			continue
+		}
+
+		result.NiceShots = append(result.NiceShots, &pb.NiceShot{UserID: niceShot.UserID, Time: niceShot.Time.Unix()})
+	}
+
+	if err := cur.Err(); err != nil {
+		log.Printf("Error accured: GetNiceShots\n%v", err)
+		return &pb.GetNiceShotsReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	result.CountNiceShots = int32(len(result.NiceShots))
+
+	return result, nil
+}
+
+// incNiceShot changes counter of picture by delta and returns it.
+// Counter does not go below zero, it is counted again by migration
+func incNiceShot(ctx context.Context, pictureID uoid.UOID, delta int32) (int32, error) {
+	filter := bson.D{{"_id", pictureID}}
+	if delta < 0 {
+		filter = append(filter, bson.E{"countNiceShot", bson.D{{"$gte", -delta}}})
+	}
+
+	picture := &model.Picture{}
+
+	err := pictureCollection.FindOneAndUpdate(ctx, filter, bson.D{{"$inc", bson.D{{"countNiceShot", delta}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(picture)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(picture.CountNiceShot), nil
+}
+
+// countNiceShots sets counter of pictures whose counter differs from their reactions
+func countNiceShots(ctx context.Context) (int, error) {
+	cur, err := niceShotCollection.Aggregate(ctx, mongo.Pipeline{
+		{{"$group", bson.D{{"_id", "$pictureID"}, {"count", bson.D{{"$sum", 1}}}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	counts := map[uoid.UOID]uint32{}
+
+	for cur.Next(ctx) {
+		group := struct {
+			PictureID uoid.UOID `bson:"_id"`
+			Count     uint32    `bson:"count"`
+		}{}
+		if err := cur.Decode(&group); err != nil {
+			cur.Close(ctx)
+			return 0, err
+		}
+
+		counts[group.PictureID] = group.Count
+	}
+	cur.Close(ctx)
+
+	cur, err = pictureCollection.Find(ctx, bson.D{}, options.Find().SetProjection(bson.D{{"countNiceShot", 1}}))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	fixed := 0
+
+	for cur.Next(ctx) {
+		picture := &model.Picture{}
+		if err := cur.Decode(picture); err != nil {
+			return fixed, err
+		}
+
+		count := counts[picture.PictureID]
+		if count == picture.CountNiceShot {
+			continue
+		}
+
+		_, err := pictureCollection.UpdateOne(ctx, bson.D{{"_id", picture.PictureID}}, bson.D{{"$set", bson.D{{"countNiceShot", count}}}})
+		if err != nil {
+			return fixed, err
+		}
+		fixed++
+	}
+
+	return fixed, cur.Err()
+}
+
+func niceShotID(pictureID, userID string) string {
+	return pictureID + "/" + userID
+}
+
+func isDuplicateKey(err error) bool {
+	if we, ok := err.(mongo.WriteException); ok {
+		for _, e := range we.WriteErrors {
+			if e.Code == errDuplicateKey {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/farerpath/server/model/consts"
+	"github.com/farerpath/server/model/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"log"
+)
+
+// Album actions checked by checkAlbumPermission
+const (
+	actionAddPicture = iota
+	actionDelPicture
+	actionDelOthersPicture
+	actionRename
+	actionEnd
+	actionPublish
+	actionManageMember
+)
+
+// minimum role required for each album action
+var actionRoles = map[int]uint32{
+	actionAddPicture:       consts.ROLE_CONTRIBUTOR,
+	actionDelPicture:       consts.ROLE_CONTRIBUTOR,
+	actionDelOthersPicture: consts.ROLE_EDITOR,
+	actionRename:           consts.ROLE_EDITOR,
+	actionEnd:              consts.ROLE_EDITOR,
+	actionPublish:          consts.ROLE_OWNER,
+	actionManageMember:     consts.ROLE_EDITOR,
+}
+
+// roleKey returns update path of role of userID in album roles.
+// User ID with '.' or '$' would write to other path, so it is refused
+func roleKey(userID string) (string, error) {
+	if len(userID) < 1 || strings.ContainsAny(userID, ".$") {
+		return "", status.Error(codes.InvalidArgument, "invalid user ID")
+	}
+
+	return "roles." + userID, nil
+}
+
+// memberRole returns role of userID in album.
+// consts.ROLE_NONE when user is not a member
+func memberRole(album *model.Album, userID string) uint32 {
+	if album.Owner == userID {
+		return consts.ROLE_OWNER
+	}
+
+	for _, member := range album.Members {
+		if member != userID {
+			continue
+		}
+
+		if role, exists := album.Roles[userID]; exists && role != consts.ROLE_NONE {
+			return role
+		}
+		// members added before roles existed
+		return consts.ROLE_CONTRIBUTOR
+	}
+
+	return consts.ROLE_NONE
+}
+
+// checkAlbumPermission returns PermissionDenied status error
+// when userID's role is lower than the action requires
+func checkAlbumPermission(album *model.Album, userID string, action int) error {
+	required, exists := actionRoles[action]
+	if !exists {
+		return status.Error(codes.Internal, "unknown album action")
+	}
+
+	if memberRole(album, userID) < required {
+		return status.Error(codes.PermissionDenied, "")
+	}
+
+	return nil
+}
+
+// checkRoleChange returns PermissionDenied status error
+// when userID may not give role to member.
+// Nobody can grant a role above their own or change someone at or above it, except the owner
+func checkRoleChange(album *model.Album, userID, memberID string, role uint32) error {
+	if err := checkAlbumPermission(album, userID, actionManageMember); err != nil {
+		return err
+	}
+
+	if role < consts.ROLE_VIEWER || role >= consts.ROLE_OWNER {
+		return status.Error(codes.InvalidArgument, "role invalid")
+	}
+
+	if memberID == album.Owner {
+		return status.Error(codes.PermissionDenied, "owner role cannot be changed")
+	}
+
+	actor := memberRole(album, userID)
+	if actor == consts.ROLE_OWNER {
+		return nil
+	}
+
+	if role > actor || memberRole(album, memberID) >= actor {
+		return status.Error(codes.PermissionDenied, "")
+	}
+
+	return nil
+}
+
+func findAlbum(ctx context.Context, albumID string) (*model.Album, error) {
+	album := &model.Album{}
+
+	err := albumCollection.FindOne(ctx, bson.D{{"_id", albumID}}).Decode(album)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, status.Error(codes.NotFound, "album not found")
+		}
+		log.Printf("Error accured: findAlbum\n%v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return album, nil
+}
@@ -21,19 +21,31 @@ import (
 // Add picture to default album
 func (srv *albumService) MakePicture(ctx context.Context, req *pb.MakePictureRequest) (*pb.MakePictureReply, error) {
 	path := model.Path{
-		City:     req.GetPath().GetCity(),
-		Country:  req.GetPath().GetCountry(),
-		Location: model.GPSData(*req.GetPath().GetLocation()),
+		City:    req.GetPath().GetCity(),
+		Country: req.GetPath().GetCountry(),
+		Location: model.GPSData{
+			Latitude:  req.GetPath().GetLocation().GetLatitude(),
+			Longitude: req.GetPath().GetLocation().GetLongitude(),
+			Altitude:  req.GetPath().GetLocation().GetAltitude(),
+		},
+	}
+
+	pictureID := uoid.FromString(req.GetPictureID())
+	if len(pictureID) < 1 {
+		pictureID = uoid.New()
 	}
 
 	picture := &model.Picture{
-		PictureID:     uoid.New(),
+		PictureID:     pictureID,
 		PictureName:   req.GetPictureName(),
 		Owner:         req.GetOwner(),
 		TimeMetadata:  time.Unix(req.GetTimeMetadata(), 0),
 		PublishRange:  req.GetPublishRange(),
 		CountNiceShot: req.GetCountNiceShot(),
 		Path:          path,
+		Metadata:      metadataFromPb(req.GetMetadata()),
+		Albums:        []string{},
+		Comments:      []model.Comment{},
 	}
 
 	_, err := pictureCollection.InsertOne(ctx, picture)
@@ -47,43 +59,32 @@ func (srv *albumService) MakePicture(ctx context.Context, req *pb.MakePictureReq
 
 // AddPicture func
 // Add picture to specific album
+// Contributors can add only their own pictures
 func (srv *albumService) AddPicture(ctx context.Context, req *pb.AddPictureRequest) (*pb.AddPictureReply, error) {
-	album := &model.Album{}
-	err := albumCollection.FindOne(ctx, bson.D{{"albumID", req.GetAlbumID()}}).Decode(album)
+	album, err := findAlbum(ctx, req.GetAlbumID())
 	if err != nil {
-		return &pb.AddPictureReply{}, status.Error(codes.Internal, err.Error())
+		return &pb.AddPictureReply{}, err
 	}
 
-	found := false
-	for _, member := range album.Members {
-		if member == req.GetReqUserID() {
-			found = true
-			break
-		}
+	if err := checkAlbumPermission(album, req.GetReqUserID(), actionAddPicture); err != nil {
+		return &pb.AddPictureReply{}, err
 	}
 
-	if req.GetReqUserID() == album.Owner {
-		found = true
+	picture, err := findPicture(ctx, req.GetPictureID())
+	if err != nil {
+		return &pb.AddPictureReply{}, err
 	}
 
-	if !found {
-		return &pb.AddPictureReply{}, status.Error(codes.Unauthenticated, "")
+	if picture.Owner != req.GetReqUserID() {
+		return &pb.AddPictureReply{}, status.Error(codes.PermissionDenied, "")
 	}
 
-	picture := &model.Picture{}
-	err = pictureCollection.FindOne(ctx, bson.D{{"pictureID", req.GetPictureID()}, {"owner", req.GetReqUserID()}}).Decode(picture)
+	_, err = albumCollection.UpdateOne(ctx, bson.D{{"_id", album.AlbumID}}, bson.D{{"$addToSet", bson.D{{"pictures", picture.PictureID}}}})
 	if err != nil {
 		return &pb.AddPictureReply{}, status.Error(codes.Internal, err.Error())
 	}
 
-	for _, pic := range album.Pictures {
-		if pic.String() == req.GetPictureID() {
-			album.Pictures = append(album.Pictures, pic)
-			break
-		}
-	}
-
-	_, err = albumCollection.UpdateOne(ctx, bson.D{{"albumID", req.GetAlbumID()}}, album)
+	_, err = pictureCollection.UpdateOne(ctx, bson.D{{"_id", picture.PictureID}}, bson.D{{"$addToSet", bson.D{{"albums", album.AlbumID}}}})
 	if err != nil {
 		return &pb.AddPictureReply{}, status.Error(codes.Internal, err.Error())
 	}
@@ -91,21 +92,43 @@ func (srv *albumService) AddPicture(ctx context.Context, req *pb.AddPictureReque
 	return &pb.AddPictureReply{}, nil
 }
 
+// GetPicture func
+// Picture is NotFound when reqUser cannot see it.
+// With albumID, album must be visible and contain the picture
 func (srv *albumService) GetPicture(ctx context.Context, req *pb.GetPictureRequest) (*pb.GetPictureReply, error) {
-	album := &model.Album{}
+	v := newViewer(req.GetReqUserID())
 
-	err := albumCollection.FindOne(ctx, bson.D{{"owner", req.GetReqUserID()}, {"albumID", req.GetAlbumID()}}).Decode(album)
+	picture, err := findPicture(ctx, req.GetPictureID())
 	if err != nil {
-		log.Printf("Error accured: GetPicture\n%v", err)
-		return &pb.GetPictureReply{}, status.Error(codes.Internal, err.Error())
+		return &pb.GetPictureReply{}, err
 	}
 
-	picture := &model.Picture{}
+	if len(req.GetAlbumID()) > 0 {
+		album, err := findAlbum(ctx, req.GetAlbumID())
+		if err != nil {
+			return &pb.GetPictureReply{}, err
+		}
 
-	err = pictureCollection.FindOne(ctx, bson.D{{"pictureID", req.GetPictureID()}, {"owner", req.GetReqUserID()}}).Decode(picture)
+		found := false
+		for _, pic := range album.Pictures {
+			if pic == picture.PictureID {
+				found = true
+				break
+			}
+		}
+
+		if !found || !v.canSeeAlbum(album) {
+			return &pb.GetPictureReply{}, status.Error(codes.NotFound, "picture not found from album")
+		}
+	}
+
+	ok, err := v.canSeePicture(ctx, picture)
 	if err != nil {
-		log.Printf("Error accured: GetPicture\n%v", err)
-		return &pb.GetPictureReply{}, status.Error(codes.Internal, err.Error())
+		return &pb.GetPictureReply{}, err
+	}
+
+	if !ok {
+		return &pb.GetPictureReply{}, status.Error(codes.NotFound, "picture not found")
 	}
 
 	l := pb.GPSData(picture.Path.Location)
@@ -124,50 +147,38 @@ func (srv *albumService) GetPicture(ctx context.Context, req *pb.GetPictureReque
 		CountNiceShot: picture.CountNiceShot,
 		Archived:      picture.Archived,
 		Path:          path,
+		Metadata:      metadataToPb(picture.Metadata),
 	}
 
-	for _, pic := range album.Pictures {
-		if pic.String() == req.GetPictureID() {
-			return result, nil
-		}
-	}
-
-	return &pb.GetPictureReply{}, status.Error(codes.NotFound, "picture not found from album")
+	return result, nil
 }
 
 // DelPicture func
 // Remove Picture from album
+// Contributors can remove their own pictures, editors can remove any picture
 func (srv *albumService) DelPicture(ctx context.Context, req *pb.DelPictureRequest) (*pb.DelPictureReply, error) {
-	n, err := pictureCollection.CountDocuments(ctx, bson.D{{"pictureID", req.GetPictureID()}, {"owner", req.GetReqUserID()}})
-	if n == 0 {
-		return &pb.DelPictureReply{}, status.Error(codes.NotFound, "")
-	}
+	album, err := findAlbum(ctx, req.GetAlbumID())
 	if err != nil {
-		return &pb.DelPictureReply{}, status.Error(codes.Internal, err.Error())
+		return &pb.DelPictureReply{}, err
 	}
 
-	album := &model.Album{}
-	err = albumCollection.FindOne(ctx, bson.D{{"pictureID", req.GetPictureID()}, {"owner", req.GetReqUserID()}}).Decode(album)
+	picture, err := findPicture(ctx, req.GetPictureID())
 	if err != nil {
-		return &pb.DelPictureReply{}, status.Error(codes.Internal, err.Error())
+		return &pb.DelPictureReply{}, err
 	}
 
-	found := false
-	for _, member := range album.Members {
-		if member == req.GetReqUserID() {
-			found = true
-			break
-		}
+	action := actionDelPicture
+	if picture.Owner != req.GetReqUserID() {
+		action = actionDelOthersPicture
 	}
 
-	if !found {
-		return &pb.DelPictureReply{}, status.Error(codes.Unauthenticated, "")
+	if err := checkAlbumPermission(album, req.GetReqUserID(), action); err != nil {
+		return &pb.DelPictureReply{}, err
 	}
 
-	found = false
-	for idx, pic := range album.Pictures {
-		if pic.String() == req.GetPictureID() {
-			album.Pictures = append(album.Pictures[:idx], album.Pictures[idx+1:]...)
+	found := false
+	for _, pic := range album.Pictures {
+		if pic == picture.PictureID {
 			found = true
 			break
 		}
@@ -177,7 +188,12 @@ func (srv *albumService) DelPicture(ctx context.Context, req *pb.DelPictureReque
 		return &pb.DelPictureReply{}, status.Error(codes.NotFound, "")
 	}
 
-	_, err = albumCollection.UpdateOne(ctx, bson.D{{"albumID", req.GetAlbumID()}}, album)
+	_, err = albumCollection.UpdateOne(ctx, bson.D{{"_id", album.AlbumID}}, bson.D{{"$pull", bson.D{{"pictures", picture.PictureID}}}})
+	if err != nil {
+		return &pb.DelPictureReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	_, err = pictureCollection.UpdateOne(ctx, bson.D{{"_id", picture.PictureID}}, bson.D{{"$pull", bson.D{{"albums", album.AlbumID}}}})
 	if err != nil {
 		return &pb.DelPictureReply{}, status.Error(codes.Internal, err.Error())
 	}
@@ -211,58 +227,27 @@ func (srv *albumService) DestroyPicture(ctx context.Context, req *pb.DestroyPict
 	return &pb.DestroyPictureReply{}, nil
 }
 
+// GetPictureList func
+// Pictures of dstUser that reqUser can see, archived pictures only to owner
 func (srv *albumService) GetPictureList(ctx context.Context, req *pb.GetPictureListRequest) (*pb.GetPictureListReply, error) {
-	pictures := []model.Picture{}
-	cur, err := pictureCollection.Find(ctx, bson.D{{"owner", req.GetReqUserID()}, {"archived", req.GetArchived()}})
-	if err != nil {
-		return &pb.GetPictureListReply{}, status.Error(codes.Internal, err.Error())
+	dstUserID := req.GetDstUserID()
+	if len(dstUserID) < 1 {
+		dstUserID = req.GetReqUserID()
 	}
-	defer cur.Close(ctx)
 
-	for cur.Next(ctx) {
-		picture := model.Picture{}
-		cur.Decode(picture)
-		pictures = append(pictures, picture)
+	if req.GetArchived() && dstUserID != req.GetReqUserID() {
+		return &pb.GetPictureListReply{}, status.Error(codes.PermissionDenied, "")
+	}
+
+	pictures, err := newViewer(req.GetReqUserID()).findVisiblePictures(ctx, bson.D{{"owner", dstUserID}, {"archived", req.GetArchived()}})
+	if err != nil {
+		return &pb.GetPictureListReply{}, err
 	}
 
 	resp := &pb.GetPictureListReply{}
 
 	for _, picture := range pictures {
-		path := &pb.Path{
-			City:    picture.Path.City,
-			Country: picture.Path.Country,
-			Location: &pb.GPSData{
-				Latitude:  picture.Path.Location.Latitude,
-				Longitude: picture.Path.Location.Longitude,
-				Altitude:  picture.Path.Location.Altitude,
-			},
-		}
-
-		comments := []*pb.Comment{}
-
-		for _, comment := range picture.Comments {
-			comments = append(comments, &pb.Comment{
-				Owner:     comment.Owner,
-				UserName:  comment.UserName,
-				Value:     comment.Value,
-				Time:      comment.Time,
-				CommentID: comment.CommentID.String(),
-			})
-		}
-
-		tmp := &pb.Picture{
-			PictureName:   picture.PictureName,
-			PictureID:     picture.PictureID.String(),
-			Owner:         picture.Owner,
-			TimeMetadata:  picture.TimeMetadata.Unix(),
-			PublishRange:  uint32(picture.PublishRange),
-			CountNiceShot: uint32(picture.CountNiceShot),
-			Archived:      picture.Archived,
-			Path:          path,
-			Comments:      comments,
-		}
-
-		resp.PictureList = append(resp.PictureList, tmp)
+		resp.PictureList = append(resp.PictureList, pictureToPb(picture))
 	}
 
 	return resp, nil
@@ -296,18 +281,71 @@ func (srv *albumService) RecoverPicture(ctx context.Context, req *pb.RecoverPict
 	return &pb.RecoverPictureReply{}, nil
 }
 
-func (srv *albumService) AddComment(ctx context.Context, req *pb.AddCommentRequest) (*pb.AddCommentReply, error) {
-	return &pb.AddCommentReply{}, nil
-}
+func pictureToPb(picture model.Picture) *pb.Picture {
+	path := &pb.Path{
+		City:    picture.Path.City,
+		Country: picture.Path.Country,
+		Location: &pb.GPSData{
+			Latitude:  picture.Path.Location.Latitude,
+			Longitude: picture.Path.Location.Longitude,
+			Altitude:  picture.Path.Location.Altitude,
+		},
+	}
 
-func (srv *albumService) DelComment(ctx context.Context, req *pb.DelCommentRequest) (*pb.DelCommentReply, error) {
-	return &pb.DelCommentReply{}, nil
+	comments := []*pb.Comment{}
+
+	for _, comment := range picture.Comments {
+		comments = append(comments, commentToPb(comment))
+	}
+
+	return &pb.Picture{
+		PictureName:   picture.PictureName,
+		PictureID:     picture.PictureID.String(),
+		Owner:         picture.Owner,
+		TimeMetadata:  picture.TimeMetadata.Unix(),
+		PublishRange:  uint32(picture.PublishRange),
+		CountNiceShot: uint32(picture.CountNiceShot),
+		Archived:      picture.Archived,
+		Path:          path,
+		Comments:      comments,
+		Metadata:      metadataToPb(picture.Metadata),
+	}
 }
 
-func (srv *albumService) AddNiceShot(ctx context.Context, req *pb.AddNiceShotRequest) (*pb.AddNiceShotReply, error) {
-	return &pb.AddNiceShotReply{}, nil
+func metadataToPb(metadata model.PictureMetadata) *pb.PictureMetadata {
+	return &pb.PictureMetadata{
+		CaptureTime:           metadata.CaptureTime,
+		Make:                  metadata.Make,
+		Model:                 metadata.Model,
+		LensMake:              metadata.LensMake,
+		LensModel:             metadata.LensModel,
+		FocalLength:           metadata.FocalLength,
+		EquivalentFocalLength: metadata.EquivalentFocalLength,
+		Aperture:              metadata.Aperture,
+		ExposureTime:          metadata.ExposureTime,
+		IsoSpeed:              metadata.ISO,
+		Orientation:           metadata.Orientation,
+		Altitude:              metadata.Altitude,
+		Width:                 metadata.Width,
+		Height:                metadata.Height,
+	}
 }
 
-func (srv *albumService) SubNiceShot(ctx context.Context, req *pb.SubNiceShotRequest) (*pb.SubNiceShotReply, error) {
-	return &pb.SubNiceShotReply{}, nil
+func metadataFromPb(metadata *pb.PictureMetadata) model.PictureMetadata {
+	return model.PictureMetadata{
+		CaptureTime:           metadata.GetCaptureTime(),
+		Make:                  metadata.GetMake(),
+		Model:                 metadata.GetModel(),
+		LensMake:              metadata.GetLensMake(),
+		LensModel:             metadata.GetLensModel(),
+		FocalLength:           metadata.GetFocalLength(),
+		EquivalentFocalLength: metadata.GetEquivalentFocalLength(),
+		Aperture:              metadata.GetAperture(),
+		ExposureTime:          metadata.GetExposureTime(),
+		ISO:                   metadata.GetIsoSpeed(),
+		Orientation:           metadata.GetOrientation(),
+		Altitude:              metadata.GetAltitude(),
+		Width:                 metadata.GetWidth(),
+		Height:                metadata.GetHeight(),
+	}
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	pb "github.com/farerpath/albumservice/proto"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"log"
+)
+
+// MakeShareLink func
+// Owner makes link to album or picture, returns signed token.
+// expiresIn 0 never expires, maxViews 0 is unlimited
+func (srv *albumService) MakeShareLink(ctx context.Context, req *pb.MakeShareLinkRequest) (*pb.MakeShareLinkReply, error) {
+	link := &model.ShareLink{
+		ShareID:     uoid.New(),
+		Owner:       req.GetReqUserID(),
+		MaxViews:    req.GetMaxViews(),
+		CreatedTime: time.Now().UTC(),
+	}
+
+	if len(req.GetAlbumID()) > 0 {
+		album, err := findAlbum(ctx, req.GetAlbumID())
+		if err != nil {
+			return &pb.MakeShareLinkReply{}, err
+		}
+
+		if err := checkAlbumPermission(album, req.GetReqUserID(), actionPublish); err != nil {
+			return &pb.MakeShareLinkReply{}, err
+		}
+
+		link.AlbumID = album.AlbumID
+	} else if len(req.GetPictureID()) > 0 {
+		picture, err := findPicture(ctx, req.GetPictureID())
+		if err != nil {
+			return &pb.MakeShareLinkReply{}, err
+		}
+
+		if picture.Owner != req.GetReqUserID() {
+			return &pb.MakeShareLinkReply{}, status.Error(codes.PermissionDenied, "")
+		}
+
+		link.PictureID = picture.PictureID
+	} else {
+		return &pb.MakeShareLinkReply{}, status.Error(codes.InvalidArgument, "album or picture not filled")
+	}
+
+	if req.GetExpiresIn() > 0 {
+		link.ExpireTime = link.CreatedTime.Add(time.Duration(req.GetExpiresIn()) * time.Second)
+	}
+
+	if len(req.GetPassword()) > 0 {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.GetPassword()), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("password hashing failed\n %v", err)
+			return &pb.MakeShareLinkReply{}, status.Error(codes.Internal, err.Error())
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	_, err := shareLinkCollection.InsertOne(ctx, link)
+	if err != nil {
+		log.Printf("Error accured: MakeShareLink\n%v", err)
+		return &pb.MakeShareLinkReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.MakeShareLinkReply{Token: signShareID(link.ShareID), ShareLink: shareLinkToPb(link)}, nil
+}
+
+func (srv *albumService) GetShareLinks(ctx context.Context, req *pb.GetShareLinksRequest) (*pb.GetShareLinksReply, error) {
+	cur, err := shareLinkCollection.Find(ctx, bson.D{{"owner", req.GetReqUserID()}}, options.Find().SetSort(bson.D{{"createdTime", -1}}))
+	if err != nil {
+		log.Printf("Error accured: GetShareLinks\n%v", err)
+		return &pb.GetShareLinksReply{}, status.Error(codes.Internal, err.Error())
+	}
+	defer cur.Close(ctx)
+
+	result := &pb.GetShareLinksReply{}
+
+	for cur.Next(ctx) {
+		link := &model.ShareLink{}
+		if err := cur.Decode(link); err != nil {
+			log.Printf("Error accured: GetShareLinks\n%v", err)
+			continue
+		}
+
+		result.ShareLinks = append(result.ShareLinks, shareLinkToPb(link))
+	}
+
+	return result, nil
+}
+
+func (srv *albumService) RevokeShareLink(ctx context.Context, req *pb.RevokeShareLinkRequest) (*pb.RevokeShareLinkReply, error) {
+	result, err := shareLinkCollection.UpdateOne(ctx, bson.D{{"_id", req.GetShareID()}, {"owner", req.GetReqUserID()}}, bson.D{{"$set", bson.D{{"revoked", true}}}})
+	if err != nil {
+		log.Printf("Error accured: RevokeShareLink\n%v", err)
+		return &pb.RevokeShareLinkReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.MatchedCount == 0 {
+		return &pb.RevokeShareLinkReply{}, status.Error(codes.NotFound, "")
+	}
+
+	return &pb.RevokeShareLinkReply{}, nil
+}
+
+// ResolveShareLink func
+// Anonymous access through share link.
+// Without pictureID it returns album or picture the link points to, and counts a view when countView is set.
+// With pictureID it returns that picture when it is in scope of the link
+func (srv *albumService) ResolveShareLink(ctx context.Context, req *pb.ResolveShareLinkRequest) (*pb.ResolveShareLinkReply, error) {
+	shareID, ok := verifyShareToken(req.GetToken())
+	if !ok {
+		return &pb.ResolveShareLinkReply{}, status.Error(codes.NotFound, "")
+	}
+
+	link := &model.ShareLink{}
+	err := shareLinkCollection.FindOne(ctx, bson.D{{"_id", shareID}}).Decode(link)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &pb.ResolveShareLinkReply{}, status.Error(codes.NotFound, "")
+		}
+		log.Printf("Error accured: ResolveShareLink\n%v", err)
+		return &pb.ResolveShareLinkReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if link.Revoked {
+		return &pb.ResolveShareLinkReply{}, status.Error(codes.NotFound, "")
+	}
+
+	if !link.ExpireTime.IsZero() && time.Now().UTC().After(link.ExpireTime) {
+		return &pb.ResolveShareLinkReply{}, status.Error(codes.FailedPrecondition, "share link expired")
+	}
+
+	// Used up link serves neither album nor pictures
+	if link.MaxViews > 0 && link.Views >= link.MaxViews {
+		return &pb.ResolveShareLinkReply{}, status.Error(codes.FailedPrecondition, "share link view limit reached")
+	}
+
+	if len(link.PasswordHash) > 0 {
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(req.GetPassword())) != nil {
+			return &pb.ResolveShareLinkReply{}, status.Error(codes.Unauthenticated, "")
+		}
+	}
+
+	// Only opening the link counts as a view, pictures loaded for that view do not
+	if req.GetCountView() {
+		if err := countShareView(ctx, link); err != nil {
+			return &pb.ResolveShareLinkReply{}, err
+		}
+	}
+
+	result := &pb.ResolveShareLinkReply{ShareLink: shareLinkToPb(link)}
+
+	if len(link.PictureID) > 0 {
+		if len(req.GetPictureID()) > 0 && req.GetPictureID() != link.PictureID.String() {
+			return &pb.ResolveShareLinkReply{}, status.Error(codes.NotFound, "")
+		}
+
+		picture, err := findPicture(ctx, link.PictureID.String())
+		if err != nil {
+			return &pb.ResolveShareLinkReply{}, err
+		}
+
+		result.Pictures = append(result.Pictures, sharedPictureToPb(*picture))
+		return result, nil
+	}
+
+	album, err := findAlbum(ctx, link.AlbumID.String())
+	if err != nil {
+		return &pb.ResolveShareLinkReply{}, err
+	}
+
+	result.Album = albumToNode(album)
+	result.Album.Members = nil
+
+	pictureIDs := album.Pictures
+	if len(req.GetPictureID()) > 0 {
+		pictureIDs = nil
+		for _, pic := range album.Pictures {
+			if pic.String() == req.GetPictureID() {
+				pictureIDs = append(pictureIDs, pic)
+			}
+		}
+
+		if len(pictureIDs) == 0 {
+			return &pb.ResolveShareLinkReply{}, status.Error(codes.NotFound, "")
+		}
+	}
+
+	if len(pictureIDs) == 0 {
+		return result, nil
+	}
+
+	pictures, err := albumLinkViewer(album.AlbumID).findVisiblePictures(ctx, bson.D{{"_id", bson.D{{"$in", pictureIDs}}}, {"archived", false}})
+	if err != nil {
+		return &pb.ResolveShareLinkReply{}, err
+	}
+
+	for _, picture := range pictures {
+		result.Pictures = append(result.Pictures, sharedPictureToPb(picture))
+	}
+
+	if len(req.GetPictureID()) > 0 && len(result.Pictures) == 0 {
+		return &pb.ResolveShareLinkReply{}, status.Error(codes.NotFound, "")
+	}
+
+	return result, nil
+}
+
+// albumLinkViewer is anonymous viewer of album link.
+// It sees pictures of album as member of that album only:
+// PUBLIC pictures and MEMBER pictures, never PRIVATE ones of the owner
+func albumLinkViewer(albumID uoid.UOID) *viewer {
+	return &viewer{albums: map[uoid.UOID]bool{albumID: true}}
+}
+
+// countShareView increases view count unless limit is reached
+func countShareView(ctx context.Context, link *model.ShareLink) error {
+	filter := bson.D{{"_id", link.ShareID}}
+	if link.MaxViews > 0 {
+		filter = append(filter, bson.E{"views", bson.D{{"$lt", link.MaxViews}}})
+	}
+
+	result, err := shareLinkCollection.UpdateOne(ctx, filter, bson.D{{"$inc", bson.D{{"views", 1}}}})
+	if err != nil {
+		log.Printf("Error accured: countShareView\n%v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if result.ModifiedCount == 0 {
+		return status.Error(codes.FailedPrecondition, "share link view limit reached")
+	}
+
+	link.Views++
+	return nil
+}
+
+// signShareID returns token "shareID.signature"
+func signShareID(shareID uoid.UOID) string {
+	return shareID.String() + "." + shareSignature(shareID.String())
+}
+
+// verifyShareToken returns share ID of token when signature matches
+func verifyShareToken(token string) (string, bool) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(shareSignature(parts[0]))) {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+func shareSignature(shareID string) string {
+	mac := hmac.New(sha256.New, []byte(SHARESECRET))
+	mac.Write([]byte(shareID))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sharedPictureToPb hides comments from anonymous viewers
+func sharedPictureToPb(picture model.Picture) *pb.Picture {
+	result := pictureToPb(picture)
+	result.Comments = nil
+
+	return result
+}
+
+func shareLinkToPb(link *model.ShareLink) *pb.ShareLink {
+	expireTime := int64(0)
+	if !link.ExpireTime.IsZero() {
+		expireTime = link.ExpireTime.Unix()
+	}
+
+	return &pb.ShareLink{
+		ShareID:     link.ShareID.String(),
+		Owner:       link.Owner,
+		AlbumID:     link.AlbumID.String(),
+		PictureID:   link.PictureID.String(),
+		HasPassword: len(link.PasswordHash) > 0,
+		ExpireTime:  expireTime,
+		MaxViews:    link.MaxViews,
+		Views:       link.Views,
+		Revoked:     link.Revoked,
+		CreatedTime: link.CreatedTime.Unix(),
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+
+	pb "github.com/farerpath/albumservice/proto"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"log"
+)
+
+// userData is data of deleted account
+type userData struct {
+	userID string
+	email  string
+	// pictures are IDs of pictures user owns
+	pictures []uoid.UOID
+}
+
+// DelUserData func
+// Deletes albums and pictures of user whose account is deleted,
+// user leaves albums of others and comments of user are anonymised.
+// Each part can run again, so deletion continues after failure
+func (srv *albumService) DelUserData(ctx context.Context, req *pb.DelUserDataRequest) (*pb.DelUserDataReply, error) {
+	if len(req.GetUserID()) < 1 {
+		return &pb.DelUserDataReply{}, status.Error(codes.InvalidArgument, "user ID missing")
+	}
+
+	d := &userData{userID: req.GetUserID(), email: req.GetEmail()}
+
+	// Pictures are deleted last, so they are found again after failure
+	if err := d.findPictures(ctx); err != nil {
+		log.Printf("Error accured: DelUserData(FindPictures)\n%v", err)
+		return &pb.DelUserDataReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	parts := []struct {
+		name string
+		del  func(context.Context) error
+	}{
+		{"Albums", d.delAlbums},
+		{"Memberships", d.leaveAlbums},
+		{"NiceShots", d.delNiceShots},
+		{"Comments", d.anonymiseComments},
+		{"Invitations", d.delInvitations},
+		{"ShareLinks", d.delShareLinks},
+		{"Pictures", d.delPictures},
+	}
+
+	for _, part := range parts {
+		if err := part.del(ctx); err != nil {
+			log.Printf("Error accured: DelUserData(%v)\n%v", part.name, err)
+			return &pb.DelUserDataReply{}, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	log.Printf("Deleted album data of %v, %v pictures\n", d.userID, len(d.pictures))
+
+	return &pb.DelUserDataReply{}, nil
+}
+
+func (d *userData) findPictures(ctx context.Context) error {
+	cur, err := pictureCollection.Find(ctx, bson.D{{"owner", d.userID}}, options.Find().SetProjection(bson.D{{"_id", 1}}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	d.pictures = []uoid.UOID{}
+
+	for cur.Next(ctx) {
+		picture := model.Picture{}
+		if err := cur.Decode(&picture); err != nil {
+			return err
+		}
+		d.pictures = append(d.pictures, picture.PictureID)
+	}
+
+	return cur.Err()
+}
+
+// delAlbums deletes albums user owns, with their invitations and share links.
+// Album is taken out of album lists of members and pictures of others
+func (d *userData) delAlbums(ctx context.Context) error {
+	cur, err := albumCollection.Find(ctx, bson.D{{"owner", d.userID}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		album := model.Album{}
+		if err := cur.Decode(&album); err != nil {
+			return err
+		}
+
+		id := album.AlbumID
+
+		if _, err := albumListCollection.UpdateMany(ctx, bson.D{{"albums", id}}, bson.D{{"$pull", bson.D{{"albums", id}}}}); err != nil {
+			return err
+		}
+
+		if _, err := pictureCollection.UpdateMany(ctx, bson.D{{"albums", id}}, bson.D{{"$pull", bson.D{{"albums", id}}}}); err != nil {
+			return err
+		}
+
+		if _, err := invitationCollection.DeleteMany(ctx, bson.D{{"albumID", id}}); err != nil {
+			return err
+		}
+
+		if _, err := shareLinkCollection.DeleteMany(ctx, bson.D{{"albumID", id}}); err != nil {
+			return err
+		}
+
+		if _, err := albumCollection.DeleteOne(ctx, bson.D{{"_id", id}}); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+// leaveAlbums removes user and user's pictures from albums of others
+func (d *userData) leaveAlbums(ctx context.Context) error {
+	update := bson.D{{"$pull", bson.D{{"members", d.userID}}}}
+	// User ID which cannot be role key never got role
+	if key, err := roleKey(d.userID); err == nil {
+		update = append(update, bson.E{"$unset", bson.D{{key, ""}}})
+	}
+
+	_, err := albumCollection.UpdateMany(ctx, bson.D{{"members", d.userID}}, update)
+	if err != nil {
+		return err
+	}
+
+	if len(d.pictures) < 1 {
+		return nil
+	}
+
+	_, err = albumCollection.UpdateMany(ctx,
+		bson.D{{"pictures", bson.D{{"$in", d.pictures}}}},
+		bson.D{{"$pullAll", bson.D{{"pictures", d.pictures}}}})
+
+	return err
+}
+
+// delNiceShots takes back reactions of user, counter is decreased for each deleted reaction
+func (d *userData) delNiceShots(ctx context.Context) error {
+	for {
+		niceShot := &model.NiceShot{}
+
+		err := niceShotCollection.FindOneAndDelete(ctx, bson.D{{"userID", d.userID}}).Decode(niceShot)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := incNiceShot(ctx, niceShot.PictureID, -1); err != nil {
+			return err
+		}
+	}
+}
+
+// anonymiseComments clears comments of user on pictures of others,
+// comments stay so replies keep their thread
+func (d *userData) anonymiseComments(ctx context.Context) error {
+	_, err := pictureCollection.UpdateMany(ctx,
+		bson.D{{"comments.owner", d.userID}},
+		bson.D{{"$set", bson.D{
+			{"comments.$[c].owner", ""},
+			{"comments.$[c].userName", ""},
+			{"comments.$[c].value", ""},
+			{"comments.$[c].editHistory", bson.A{}},
+		}}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.D{{"c.owner", d.userID}}}}),
+	)
+
+	return err
+}
+
+// delInvitations deletes invitations user sent or got
+func (d *userData) delInvitations(ctx context.Context) error {
+	filter := bson.A{
+		bson.D{{"inviter", d.userID}},
+		bson.D{{"inviteeID", d.userID}},
+	}
+	if len(d.email) > 0 {
+		filter = append(filter, bson.D{{"inviteeEmail", d.email}})
+	}
+
+	_, err := invitationCollection.DeleteMany(ctx, bson.D{{"$or", filter}})
+	return err
+}
+
+func (d *userData) delShareLinks(ctx context.Context) error {
+	_, err := shareLinkCollection.DeleteMany(ctx, bson.D{{"owner", d.userID}})
+	return err
+}
+
+// delPictures deletes pictures of user with reactions and share links to them,
+// and album list of user
+func (d *userData) delPictures(ctx context.Context) error {
+	if len(d.pictures) > 0 {
+		if _, err := niceShotCollection.DeleteMany(ctx, bson.D{{"pictureID", bson.D{{"$in", d.pictures}}}}); err != nil {
+			return err
+		}
+
+		if _, err := shareLinkCollection.DeleteMany(ctx, bson.D{{"pictureID", bson.D{{"$in", d.pictures}}}}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := pictureCollection.DeleteMany(ctx, bson.D{{"owner", d.userID}}); err != nil {
+		return err
+	}
+
+	_, err := albumListCollection.DeleteOne(ctx, bson.D{{"_id", d.userID}})
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/farerpath/server/model/consts"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Publish range decides who can read albums and pictures
+//  PUBLIC  - everyone
+//  MEMBER  - owner and album members. Picture is visible to members of any album it is in
+//  PRIVATE - owner only
+// Archived pictures are visible to owner only
+
+// visible reports whether publishRange lets owner or member read
+func visible(publishRange uint32, isOwner, isMember bool) bool {
+	switch {
+	case isOwner:
+		return true
+	case publishRange == consts.PUBLIC:
+		return true
+	case publishRange == consts.MEMBER:
+		return isMember
+	}
+
+	return false
+}
+
+// viewer is requester of read RPCs.
+// Empty userID is anonymous viewer
+type viewer struct {
+	userID string
+
+	// albums userID owns or is member of, loaded on first MEMBER picture
+	albums map[uoid.UOID]bool
+}
+
+func newViewer(userID string) *viewer {
+	return &viewer{userID: userID}
+}
+
+func (v *viewer) canSeeAlbum(album *model.Album) bool {
+	return visible(album.PublishRange, album.Owner == v.userID, memberRole(album, v.userID) != consts.ROLE_NONE)
+}
+
+func (v *viewer) canSeePicture(ctx context.Context, picture *model.Picture) (bool, error) {
+	isOwner := picture.Owner == v.userID
+
+	if picture.Archived && !isOwner {
+		return false, nil
+	}
+
+	if isOwner || picture.PublishRange != consts.MEMBER {
+		return visible(picture.PublishRange, isOwner, false), nil
+	}
+
+	if err := v.loadAlbums(ctx); err != nil {
+		return false, err
+	}
+
+	for _, albumID := range picture.Albums {
+		if v.albums[uoid.FromString(albumID)] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (v *viewer) loadAlbums(ctx context.Context) error {
+	if v.albums != nil {
+		return nil
+	}
+
+	v.albums = make(map[uoid.UOID]bool)
+
+	if len(v.userID) < 1 {
+		return nil
+	}
+
+	cur, err := albumCollection.Find(ctx, bson.D{{"$or", bson.A{
+		bson.D{{"owner", v.userID}},
+		bson.D{{"members", v.userID}},
+	}}}, options.Find().SetProjection(bson.D{{"_id", 1}}))
+	if err != nil {
+		v.albums = nil
+		log.Printf("Error accured: loadAlbums\n%v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		album := model.Album{}
+		if err := cur.Decode(&album); err != nil {
+			v.albums = nil
+			log.Printf("Error accured: loadAlbums\n%v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+		v.albums[album.AlbumID] = true
+	}
+
+	return nil
+}
+
+// findVisiblePictures returns pictures matching filter that viewer can see
+func (v *viewer) findVisiblePictures(ctx context.Context, filter bson.D) ([]model.Picture, error) {
+	pictures := []model.Picture{}
+
+	cur, err := pictureCollection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("Error accured: findVisiblePictures\n%v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		picture := model.Picture{}
+		if err := cur.Decode(&picture); err != nil {
+			log.Printf("Error accured: findVisiblePictures\n%v", err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		ok, err := v.canSeePicture(ctx, &picture)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			pictures = append(pictures, picture)
+		}
+	}
+
+	return pictures, nil
+}
+
+// albumPictureIDs returns IDs of album's pictures that viewer can see
+func (v *viewer) albumPictureIDs(ctx context.Context, album *model.Album) ([]string, error) {
+	pictureIDs := []string{}
+
+	if len(album.Pictures) < 1 {
+		return pictureIDs, nil
+	}
+
+	pictures, err := v.findVisiblePictures(ctx, bson.D{{"_id", bson.D{{"$in", album.Pictures}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, picture := range pictures {
+		pictureIDs = append(pictureIDs, picture.PictureID.String())
+	}
+
+	return pictureIDs, nil
+}
+
+// checkPictureVisible returns PermissionDenied status error
+// when userID cannot see the picture
+func checkPictureVisible(ctx context.Context, picture *model.Picture, userID string) error {
+	ok, err := newViewer(userID).canSeePicture(ctx, picture)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return status.Error(codes.PermissionDenied, "")
+	}
+
+	return nil
+}
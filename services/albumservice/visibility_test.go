@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/farerpath/server/model/consts"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+)
+
+const (
+	testOwner    = "owner"
+	testMember   = "member"
+	testStranger = "stranger"
+	testAlbumID  = uoid.UOID("album")
+)
+
+var publishRanges = []struct {
+	name  string
+	value uint32
+}{
+	{"PUBLIC", consts.PUBLIC},
+	{"MEMBER", consts.MEMBER},
+	{"PRIVATE", consts.PRIVATE},
+}
+
+// visibleTo is expected visibility by publish range, for owner, member and stranger
+var visibleTo = map[uint32]map[string]bool{
+	consts.PUBLIC:  {testOwner: true, testMember: true, testStranger: true},
+	consts.MEMBER:  {testOwner: true, testMember: true, testStranger: false},
+	consts.PRIVATE: {testOwner: true, testMember: false, testStranger: false},
+}
+
+var viewers = []string{testOwner, testMember, testStranger}
+
+func TestVisible(t *testing.T) {
+	for _, pr := range publishRanges {
+		for _, user := range viewers {
+			got := visible(pr.value, user == testOwner, user == testMember)
+			if want := visibleTo[pr.value][user]; got != want {
+				t.Errorf("visible(%v) for %v = %v, want %v", pr.name, user, got, want)
+			}
+		}
+	}
+}
+
+func TestCanSeeAlbum(t *testing.T) {
+	for _, pr := range publishRanges {
+		album := &model.Album{
+			AlbumID:      testAlbumID,
+			Owner:        testOwner,
+			Members:      []string{testMember},
+			Roles:        map[string]uint32{testMember: consts.ROLE_VIEWER},
+			PublishRange: pr.value,
+		}
+
+		for _, user := range viewers {
+			got := newViewer(user).canSeeAlbum(album)
+			if want := visibleTo[pr.value][user]; got != want {
+				t.Errorf("canSeeAlbum(%v) for %v = %v, want %v", pr.name, user, got, want)
+			}
+		}
+	}
+}
+
+func TestCanSeePicture(t *testing.T) {
+	for _, pr := range publishRanges {
+		for _, archived := range []bool{false, true} {
+			picture := &model.Picture{
+				PictureID:    "picture",
+				Owner:        testOwner,
+				PublishRange: pr.value,
+				Archived:     archived,
+				Albums:       []string{string(testAlbumID)},
+			}
+
+			for _, user := range viewers {
+				// Albums are set, so MEMBER pictures are checked without database
+				v := newViewer(user)
+				v.albums = map[uoid.UOID]bool{}
+				if user != testStranger {
+					v.albums[testAlbumID] = true
+				}
+
+				got, err := v.canSeePicture(context.Background(), picture)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				want := visibleTo[pr.value][user]
+				if archived {
+					want = user == testOwner
+				}
+
+				if got != want {
+					t.Errorf("canSeePicture(%v, archived %v) for %v = %v, want %v", pr.name, archived, user, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestAlbumLinkViewer(t *testing.T) {
+	want := map[uint32]bool{consts.PUBLIC: true, consts.MEMBER: true, consts.PRIVATE: false}
+
+	for _, pr := range publishRanges {
+		picture := &model.Picture{PictureID: "picture", Owner: testOwner, PublishRange: pr.value, Albums: []string{string(testAlbumID)}}
+
+		got, err := albumLinkViewer(testAlbumID).canSeePicture(context.Background(), picture)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want[pr.value] {
+			t.Errorf("album link canSeePicture(%v) = %v, want %v", pr.name, got, want[pr.value])
+		}
+
+		other := &model.Picture{PictureID: "other", Owner: testOwner, PublishRange: pr.value, Albums: []string{"other"}}
+
+		got, err = albumLinkViewer(testAlbumID).canSeePicture(context.Background(), other)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != (pr.value == consts.PUBLIC) {
+			t.Errorf("album link canSeePicture(%v) of other album = %v", pr.name, got)
+		}
+	}
+}
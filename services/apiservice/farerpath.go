@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+
+	"github.com/farerpath/server/model/consts"
 
 	"github.com/farerpath/server/services/apiservice/server"
 
@@ -12,6 +16,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"github.com/urfave/negroni"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"google.golang.org/grpc"
 )
 
@@ -21,11 +27,47 @@ const (
 	SESSIONSERVICE = "sessionservice-service:17080"
 )
 
+// Reverse geocoding of uploaded pictures
+var (
+	// GEOCODER is local or remote
+	GEOCODER = "local"
+	// GEONAMES_FILE replaces bundled cities of local geocoder
+	GEONAMES_FILE = ""
+	// GEOCODING_KEY is API key of remote geocoder
+	GEOCODING_KEY = consts.GOOGLE_REVGEOCODING_KEY
+)
+
+// DBADDR : MongoDB Address, data exports are kept in it
+var DBADDR = "mongodb://maindb-service:27017"
+
+func init() {
+	if geocoder := os.Getenv("FP_GEOCODER"); len(geocoder) > 0 {
+		GEOCODER = geocoder
+	}
+
+	if file := os.Getenv("FP_GEONAMES_FILE"); len(file) > 0 {
+		log.Printf("GeoNames file received: %v\n", file)
+		GEONAMES_FILE = file
+	}
+
+	if key := os.Getenv("FP_GEOCODING_KEY"); len(key) > 0 {
+		GEOCODING_KEY = key
+	}
+
+	if addr := os.Getenv("FP_DB_ADDRESS"); len(addr) > 1 {
+		log.Printf("DB address received: %v\n", addr)
+		DBADDR = addr
+	}
+}
+
 // App function
 // Main handler group
 func App() http.Handler {
 	r := mux.NewRouter()
 
+	// Public keys of auth tokens, JSON Web Key Set
+	r.HandleFunc("/.well-known/jwks.json", route.JWKS).Methods(http.MethodGet)
+
 	// API Version 1
 	apiv1 := r.PathPrefix("/v1").Subrouter()
 
@@ -40,21 +82,91 @@ func App() http.Handler {
 	apiv1.HandleFunc("/Logout", route.Logout)
 	apiv1.HandleFunc("/Register", route.Register)
 
+	// Second step of login(POST) with TOTP code, when Login replied mfaRequired
+	apiv1.HandleFunc("/Login/TOTP", route.LoginTOTP).Methods(http.MethodPost)
+
+	// Login with identity provider: google, apple or generic OpenID Connect
+	// GET - Response: login page of provider, POST callback - Login with code provider sent back
+	apiv1.HandleFunc("/OAuth/{provider}", route.OIDCLogin).Methods(http.MethodGet)
+	apiv1.HandleFunc("/OAuth/{provider}/callback", route.OIDCCallback).Methods(http.MethodPost)
+
+	// New auth and refresh tokens(POST) for refresh token, which is used up
+	apiv1.HandleFunc("/Token/Refresh", route.RefreshToken).Methods(http.MethodPost)
+
+	// Verify email(POST) with token mailed to user
+	apiv1.HandleFunc("/Verification", route.VerifyEmail).Methods(http.MethodPost)
+
+	// Mail password reset link(POST), Set new password(POST) with token of link
+	apiv1.HandleFunc("/Password/Reset", route.RequestPasswordReset).Methods(http.MethodPost)
+	apiv1.HandleFunc("/Password/Reset/Confirm", route.ResetPassword).Methods(http.MethodPost)
+
 	// User information
 	// GET - Response: Account model in json string
 	apiv1.HandleFunc("/User/{userId}", route.UserHandler).Methods(http.MethodGet, http.MethodPatch)
 	apiv1.HandleFunc("/User/{userId}/password", route.UserPasswordHandler).Methods(http.MethodPatch)
 
+	// Mail verification link(POST) again, public pictures and share links need verified email
+	apiv1.HandleFunc("/User/{userId}/verification", route.UserVerificationHandler).Methods(http.MethodPost)
+
+	// Two-factor login with TOTP
+	// POST - Enroll, DELETE - Turn off, POST confirm - Enable with first code
+	apiv1.HandleFunc("/User/{userId}/totp", route.UserTOTPHandler).Methods(http.MethodPost, http.MethodDelete)
+	apiv1.HandleFunc("/User/{userId}/totp/confirm", route.UserTOTPConfirmHandler).Methods(http.MethodPost)
+
+	// Identity providers of user
+	// GET - Response: linked providers, POST - Link provider, DELETE - Unlink provider
+	apiv1.HandleFunc("/User/{userId}/identities", route.UserIdentityHandler).Methods(http.MethodGet)
+	apiv1.HandleFunc("/User/{userId}/identities/{provider}", route.UserIdentityItemHandler).Methods(http.MethodPost, http.MethodDelete)
+
+	// Account deletion after grace period
+	// POST - Request deletion with password, DELETE - Cancel deletion before it starts
+	apiv1.HandleFunc("/User/{userId}/deletion", route.UserDeletionHandler).Methods(http.MethodPost, http.MethodDelete)
+
+	// Export of user's data as ZIP
+	// POST - Start export, GET - Status of export with download link when ready
+	apiv1.HandleFunc("/User/{userId}/exports", route.UserExportHandler).Methods(http.MethodPost)
+	apiv1.HandleFunc("/User/{userId}/exports/{exportId}", route.UserExportItemHandler).Methods(http.MethodGet)
+
+	// Location privacy(PATCH) of user's pictures: exact, city or none
+	apiv1.HandleFunc("/User/{userId}/privacy", route.UserPrivacyHandler).Methods(http.MethodPatch)
+
+	// Login sessions of user, tokens are not shown
+	// GET - Response: sessions, DELETE - Log out all other sessions
+	apiv1.HandleFunc("/User/{userId}/sessions", route.UserSessionHandler).Methods(http.MethodGet, http.MethodDelete)
+
+	// Log out(DELETE) session by its ID
+	apiv1.HandleFunc("/User/{userId}/sessions/{sessionId}", route.UserSessionItemHandler).Methods(http.MethodDelete)
+
+	// Album invitations sent to user
+	// GET - Response: pending invitations
+	apiv1.HandleFunc("/User/{userId}/invitations", route.UserInvitationHandler).Methods(http.MethodGet)
+	apiv1.HandleFunc("/User/{userId}/invitations/{invitationId}/{action:accept|decline}", route.UserInvitationAnswerHandler).Methods(http.MethodPost)
+
 	// Picture
 	// GET - Response: Get Picture list of userId's
 	// POST - Upload Picture
 	apiv1.HandleFunc("/Pictures/{userId}", route.PictureListHandler).Methods(http.MethodPost, http.MethodGet)
 
 	// Download(GET), Delete(DELETE)...
+	// GET ?size=small|medium|large - JPEG rendition instead of original
 	apiv1.HandleFunc("/Pictures/{userId}/{pictureId}", route.PictureHandler).Methods(http.MethodGet, http.MethodDelete)
 
 	apiv1.HandleFunc("/Pictures/{userId}/file", route.PictureFileHandler)
 
+	// Capture details(GET) of picture: time, camera, lens, exposure, dimensions
+	apiv1.HandleFunc("/Pictures/{userId}/{pictureId}/metadata", route.PictureMetadataHandler).Methods(http.MethodGet)
+
+	// Comments of picture
+	// GET - Response: comment list, POST - Add comment or reply
+	apiv1.HandleFunc("/Pictures/{userId}/{pictureId}/comments", route.PictureCommentHandler).Methods(http.MethodGet, http.MethodPost)
+
+	// Edit(PATCH), Delete(DELETE) comment
+	apiv1.HandleFunc("/Pictures/{userId}/{pictureId}/comments/{commentId}", route.PictureCommentItemHandler).Methods(http.MethodPatch, http.MethodDelete)
+
+	// Nice shots of picture
+	// GET - Response: users reacted, POST - Add nice shot, DELETE - Cancel nice shot
+	apiv1.HandleFunc("/Pictures/{userId}/{pictureId}/niceshots", route.PictureNiceShotHandler).Methods(http.MethodGet, http.MethodPost, http.MethodDelete)
+
 	// GET - Response: Album lists of userId
 	// POST - Response: Make album
 	apiv1.HandleFunc("/Album/{userId}", route.AlbumHandler).Methods(http.MethodGet, http.MethodPost)
@@ -63,13 +175,49 @@ func App() http.Handler {
 	// Delete(DELETE), Update(PATCH), Get(GET)
 	apiv1.HandleFunc("/Album/{userId}/{albumId}", route.UserAlbumHandler).Methods(http.MethodDelete, http.MethodPatch, http.MethodGet)
 
+	// Invitations of album
+	// GET - Response: pending invitations, POST - Invite user, DELETE - Revoke invitation
+	// Must be registered before /Album/{userId}/{albumId}/{pictureId}
+	apiv1.HandleFunc("/Album/{userId}/{albumId}/invitations", route.AlbumInvitationHandler).Methods(http.MethodGet, http.MethodPost)
+	apiv1.HandleFunc("/Album/{userId}/{albumId}/invitations/{invitationId}", route.AlbumInvitationItemHandler).Methods(http.MethodDelete)
+
 	// Add, Delete picture to album
+	// Download(GET) picture of album, ?size= same as /Pictures/{userId}/{pictureId}
 	apiv1.HandleFunc("/Album/{userId}/{albumId}/{pictureId}", route.AlbumPictureHandler)
 
+	// Member of album
+	// Change role(PATCH), Remove(DELETE)
+	apiv1.HandleFunc("/Album/{userId}/{albumId}/members/{memberId}", route.AlbumMemberHandler).Methods(http.MethodPatch, http.MethodDelete)
+
+	// Share links of user
+	// GET - Response: share links, POST - Make share link of album or picture
+	apiv1.HandleFunc("/Share", route.ShareHandler).Methods(http.MethodGet, http.MethodPost)
+
+	// Revoke(DELETE) share link
+	apiv1.HandleFunc("/Share/{shareId}", route.ShareItemHandler).Methods(http.MethodDelete)
+
+	// Shared album or picture, no login required
+	// GET - Response: shared album and pictures
+	apiv1.HandleFunc("/s/{token}", route.SharedHandler).Methods(http.MethodGet)
+
+	// Download(GET) picture of share link, ?size= same as /Pictures/{userId}/{pictureId}
+	apiv1.HandleFunc("/s/{token}/{pictureId}", route.SharedPictureHandler).Methods(http.MethodGet)
+
+	// Download(GET) ZIP of export, link of export status is the credential
+	apiv1.HandleFunc("/Exports/{token}", route.ExportDownloadHandler).Methods(http.MethodGet)
+
+	// Resumable picture uploads, tus 1.0
+	// OPTIONS - tus capabilities, POST - Create upload
+	apiv1.HandleFunc("/Uploads", route.PictureUploadHandler).Methods(http.MethodOptions, http.MethodPost)
+
+	// HEAD - Upload offset, PATCH - Append chunk, DELETE - Cancel upload
+	apiv1.HandleFunc("/Uploads/{uploadId}", route.PictureUploadItemHandler).Methods(http.MethodHead, http.MethodPatch, http.MethodDelete)
+
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "HEAD"},
-		AllowedHeaders:   []string{"X-Farerpath-Token", "Content-Type"},
+		AllowedHeaders:   []string{"X-Farerpath-Token", "X-Farerpath-Share-Password", "Content-Type", "Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset"},
+		ExposedHeaders:   []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "X-Farerpath-Picture-Id"},
 		AllowCredentials: true,
 	})
 
@@ -82,6 +230,10 @@ func App() http.Handler {
 
 func main() {
 	initGrpcConn()
+	initGeocoder()
+
+	initDB()
+
 	fmt.Println("Server starts at 0.0.0.0:80")
 
 	http.ListenAndServe(":80", App())
@@ -106,3 +258,33 @@ func initGrpcConn() {
 	server.InitGrpcConn(conn1, conn2, conn3)
 	route.InitGrpcConn(conn3)
 }
+
+func initDB() {
+	client, err := mongo.NewClient(options.Client().ApplyURI(DBADDR))
+	if err != nil {
+		log.Fatalf("DB Connection failed:\n%v", err)
+	}
+
+	if err := client.Connect(context.Background()); err != nil {
+		log.Fatalf("DB Connection failed:\n%v", err)
+	}
+
+	log.Println("DB Connection succeed")
+
+	server.InitExports(client.Database("farerpath"))
+}
+
+func initGeocoder() {
+	switch GEOCODER {
+	case "local":
+		geocoder, err := server.NewLocalGeocoder(GEONAMES_FILE)
+		if err != nil {
+			log.Fatalf("Failed to load cities %v", err)
+		}
+		server.SetReverseGeocoder(geocoder)
+	case "remote":
+		server.SetReverseGeocoder(server.NewRemoteGeocoder(GEOCODING_KEY))
+	default:
+		log.Fatalf("Unknown geocoder %v", GEOCODER)
+	}
+}
@@ -1,11 +1,9 @@
 package route
 
 import (
-	"bytes"
 	"log"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/farerpath/server/services/apiservice/server"
 
@@ -17,21 +15,50 @@ import (
 	sessionService "github.com/farerpath/sessionservice/proto"
 
 	"github.com/mssola/user_agent"
-	"golang.org/x/net/context"
 
 	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/common/verifier"
 	"github.com/gorilla/mux"
 	"google.golang.org/grpc"
 )
 
 var sessionClient sessionService.SessionClient
 
+var tokenVerifier *verifier.Verifier
+
+// maxUploadMemory of multipart upload, larger files are spooled to disk
+const maxUploadMemory = 4 << 20
+
 func Ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Pong"))
 }
 
 func InitGrpcConn(conn *grpc.ClientConn) {
 	sessionClient = sessionService.NewSessionClient(conn)
+	tokenVerifier = verifier.New(sessionClient)
+}
+
+// verifyToken checks auth token in process with keys of session service
+func verifyToken(token string) (*sessionService.VerifyResponse, error) {
+	userID, err := tokenVerifier.Verify(token)
+	if err == verifier.ErrInvalidToken {
+		return &sessionService.VerifyResponse{Valid: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionService.VerifyResponse{Valid: true, UserID: userID}, nil
+}
+
+// JWKS handler
+// Response: public keys of auth tokens as JSON Web Key Set
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	result := server.GetJWKS()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
@@ -46,7 +73,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("X-Farerpath-Token")
 
 	if len(token) > 10 {
-		resp, err := sessionClient.VerifyToken(context.Background(), &sessionService.Token{Token: token})
+		resp, err := verifyToken(token)
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -80,7 +107,12 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := server.Login(loginUserId, loginPassword, loginDeviceType, util.GetReqIP(r.Header.Get("X-Forwarded-For")))
+	var retryAfter string
+
+	result := server.Login(loginUserId, loginPassword, loginDeviceType, util.GetReqIP(r), &retryAfter)
+	if len(retryAfter) > 0 {
+		w.Header().Set("Retry-After", retryAfter)
+	}
 
 	w.WriteHeader(result.StatusCode)
 	w.Write(result.Value)
@@ -89,7 +121,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 func Logout(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("X-Farerpath-Token")
 
-	verify, err := sessionClient.VerifyToken(context.Background(), &sessionService.Token{Token: token})
+	verify, err := verifyToken(token)
 	if err != nil {
 		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
 		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
@@ -107,6 +139,30 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 	w.Write(result.Value)
 }
 
+// RefreshToken handler
+// Body: {"refreshToken": "..."}
+// Response: new authToken and refreshToken
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, err := util.UnmarshalBody(&r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	refreshToken, ok := body["refreshToken"].(string)
+	if !ok || len(refreshToken) < 10 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	result := server.RefreshToken(refreshToken, util.GetReqIP(r))
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
 func Register(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -123,13 +179,12 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	w.Write(result.Value)
 }
 
-// TODO: Complete
 func UserHandler(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("X-Farerpath-Token")
 	vars := mux.Vars(r)
 	result := &model.ReturnValue{}
 
-	verify, err := sessionClient.VerifyToken(context.Background(), &sessionService.Token{Token: token})
+	verify, err := verifyToken(token)
 	if err != nil {
 		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
 		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
@@ -146,23 +201,47 @@ func UserHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		isCountryPublic, err := strconv.ParseBool(r.FormValue("isCountryPublic"))
 		if err != nil {
-			w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
 		isBirthdayPublic, err := strconv.ParseBool(r.FormValue("isBirthdayPublic"))
 		if err != nil {
-			w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
 		isProfilePublic, err := strconv.ParseBool(r.FormValue("isProfilePublic"))
 		if err != nil {
-			w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		result = server.UpdateUserProfile(verify.UserID, vars["userId"], r.FormValue("userName"), r.FormValue("email"), r.FormValue("profilePhotoPath"), r.FormValue("country"), r.FormValue("birthday"), isCountryPublic, isBirthdayPublic, isProfilePublic)
+		// Session duration in hours, kept when not given
+		sessionDuration := uint64(0)
+		if len(r.FormValue("sessionDuration")) > 0 {
+			sessionDuration, err = strconv.ParseUint(r.FormValue("sessionDuration"), 10, 32)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		profile := &server.Profile{
+			UserName:         r.FormValue("userName"),
+			Email:            r.FormValue("email"),
+			FirstName:        r.FormValue("firstName"),
+			LastName:         r.FormValue("lastName"),
+			Country:          r.FormValue("country"),
+			Birthday:         r.FormValue("birthday"),
+			ProfilePhotoPath: r.FormValue("profilePhotoPath"),
+			SessionDuration:  uint32(sessionDuration),
+			IsCountryPublic:  isCountryPublic,
+			IsBirthdayPublic: isBirthdayPublic,
+			IsProfilePublic:  isProfilePublic,
+		}
+
+		result = server.UpdateUserProfile(verify.UserID, vars["userId"], profile)
 	}
 
 	w.WriteHeader(result.StatusCode)
@@ -170,12 +249,11 @@ func UserHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// TODO: Complete
 func UserPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("X-Farerpath-Token")
 	vars := mux.Vars(r)
 
-	verify, err := sessionClient.VerifyToken(context.Background(), &sessionService.Token{Token: token})
+	verify, err := verifyToken(token)
 	if err != nil {
 		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
 		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
@@ -187,131 +265,92 @@ func UserPasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := server.UpdateUserPassword(verify.UserID, vars["userId"], r.FormValue("oldPassword"), r.FormValue("newPassword"))
+	result := server.UpdateUserPassword(verify.UserID, vars["userId"], r.FormValue("oldPassword"), r.FormValue("newPassword"), token)
 
 	w.WriteHeader(result.StatusCode)
 	w.Write(result.Value)
 }
 
-func AlbumHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	result := &model.ReturnValue{}
-
-	token := r.Header.Get("X-Farerpath-Token")
-	userId := vars["userId"]
+// Second step of login(POST) for users with TOTP
+// mfaTicket - given by Login, code - TOTP code or recovery code
+func LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
 
-	verify, err := sessionClient.VerifyToken(context.Background(), &sessionService.Token{Token: token})
+	body, err := util.UnmarshalBody(&r.Body)
 	if err != nil {
-		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
-		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if !verify.Valid {
-		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+	mfaTicket, ok := body["mfaTicket"].(string)
+	code, _ := body["code"].(string)
+	if !ok || len(mfaTicket) < 1 || len(code) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if r.Method == http.MethodGet {
-		// Get Album list
-		result = server.GetAlbumList(verify.UserID, userId)
-	} else if r.Method == http.MethodPost {
-		// Make Album
-		body, err := util.UnmarshalBody(&r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		i := func() int {
-			if _, exists := body["albumName"]; !exists {
-				return -1
-			}
-			if _, exists := body["beginTime"]; !exists {
-				return -1
-			}
-			if _, exists := body["members"]; !exists {
-				return -1
-			}
-			return 0
-		}()
-
-		if i != 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Arguments are not filled"))
-			return 
-		}
+	var retryAfter string
 
-		result = server.MakeAlbum(verify.UserID, userId, body["albumName"].(string), body["beginTime"].(string), body["members"].(string))
+	result := server.LoginTOTP(mfaTicket, code, &retryAfter)
+	if len(retryAfter) > 0 {
+		w.Header().Set("Retry-After", retryAfter)
 	}
 
 	w.WriteHeader(result.StatusCode)
 	w.Write(result.Value)
 }
 
-func UserAlbumHandler(w http.ResponseWriter, r *http.Request) {
-	var result *model.ReturnValue
+// Login page(GET) of identity provider
+// Response: authorizationURL and state
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	token := r.Header.Get("X-Farerpath-Token")
-	userID := vars["userId"]
-	albumID := vars["albumId"]
+	result := server.StartOIDC(vars["provider"], "")
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
 
-	verify, err := sessionClient.VerifyToken(context.Background(), &sessionService.Token{Token: token})
+// Login(POST) with code identity provider sent back with state
+// Response: same as Login, or linked when linking was started
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+
+	body, err := util.UnmarshalBody(&r.Body)
 	if err != nil {
-		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
-		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if !verify.Valid {
-		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+	state, _ := body["state"].(string)
+	code, _ := body["code"].(string)
+	if len(state) < 1 || len(code) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if r.Method == http.MethodGet {
-		result = server.GetAlbum(verify.UserID, userID, albumID)
-	} else if r.Method == http.MethodDelete {
-		if verify.UserID != userID {
-			w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
-			return
-		}
-
-		result = server.DeleteAlbum(userID, albumID)
-	} else if r.Method == http.MethodPatch {
-		if verify.UserID != userID {
-			w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
-			return
-		}
-
-		body, err := util.UnmarshalBody(&r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
+	loginDeviceType := 0
+	if value, ok := body["loginDeviceType"].(string); ok {
+		if loginDeviceType, err = strconv.Atoi(value); err != nil {
+			loginDeviceType = 0
 		}
-
-		result = server.UpdateAlbum(userID, albumID, body["newAlbumName"].(string), body["newBeginTime"].(string), body["newMembers"].(string))
-	} else {
-		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
-		return
 	}
 
+	result := server.FinishOIDC(vars["provider"], state, code, loginDeviceType, util.GetReqIP(r))
+
 	w.WriteHeader(result.StatusCode)
 	w.Write(result.Value)
 }
 
-func AlbumPictureHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var result *model.ReturnValue
-
+// Identity providers(GET) user logs in with
+func UserIdentityHandler(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("X-Farerpath-Token")
-	userID := vars["userId"]
-	albumID := vars["albumId"]
-	pictureID := vars["pictureId"]
+	vars := mux.Vars(r)
 
-	verify, err := sessionClient.VerifyToken(context.Background(), &sessionService.Token{Token: token})
+	verify, err := verifyToken(token)
 	if err != nil {
-		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
 		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
 		return
 	}
@@ -321,35 +360,53 @@ func AlbumPictureHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if verify.UserID != userID {
+	result := server.GetIdentities(verify.UserID, vars["userId"])
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Identity provider of user
+// POST - Link, Response: login page of provider, finished by OIDCCallback
+// DELETE - Unlink
+func UserIdentityItemHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Farerpath-Token")
+	vars := mux.Vars(r)
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid || verify.UserID != vars["userId"] {
 		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
 		return
 	}
 
+	result := &model.ReturnValue{}
+
 	if r.Method == http.MethodPost {
-		result = server.AddPictureToAlbum(userID, pictureID, albumID)
-	} else if r.Method == http.MethodDelete {
-		result = server.RemovePictureFromAlbum(userID, pictureID, albumID)
-	} else if r.Method == http.MethodGet {
-		var fileName string
-		result = server.DownloadPicture(userID, albumID, pictureID, &fileName)
-		http.ServeContent(w, r, fileName, time.Now(), bytes.NewReader(result.Value))
-		return
+		result = server.StartOIDC(vars["provider"], verify.UserID)
+	} else {
+		result = server.UnlinkIdentity(verify.UserID, vars["userId"], vars["provider"])
 	}
+
 	w.WriteHeader(result.StatusCode)
 	w.Write(result.Value)
 }
 
-func PictureListHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	result := &model.ReturnValue{}
-
+// TOTP of user
+// POST - Enroll, Response: secret and otpauth URI
+// DELETE - Turn off, password and code required
+func UserTOTPHandler(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("X-Farerpath-Token")
-	userId := vars["userId"]
+	vars := mux.Vars(r)
 
-	verify, err := sessionClient.VerifyToken(context.Background(), &sessionService.Token{Token: token})
+	verify, err := verifyToken(token)
 	if err != nil {
-		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
 		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
 		return
 	}
@@ -359,48 +416,50 @@ func PictureListHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if verify.UserID != userId {
-		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
-		return
-	}
+	result := &model.ReturnValue{}
 
 	if r.Method == http.MethodPost {
-		file, fileHeader, err := r.FormFile("file")
-		if err != nil {
-			w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
-			return
-		}
-		publishRange, err := strconv.Atoi(r.FormValue("publishRange"))
-		if err != nil {
-			w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
-			return
-		}
-
-		result = server.UploadPicture(userId, r.FormValue("pictureName"), uint(publishRange), &file, fileHeader)
-
-	} else if r.Method == http.MethodGet {
-		if r.FormValue("archived") == "true" {
-			result = server.GetArchivedPictureList(userId)
-		} else {
-			result = server.GetPictureList(userId)
-		}
+		result = server.EnrollTOTP(verify.UserID, vars["userId"])
+	} else {
+		result = server.DisableTOTP(verify.UserID, vars["userId"], r.FormValue("password"), r.FormValue("code"))
 	}
 
 	w.WriteHeader(result.StatusCode)
 	w.Write(result.Value)
 }
 
-func PictureHandler(w http.ResponseWriter, r *http.Request) {
+// Enable TOTP(POST) with first code of authenticator app
+// Response: recovery codes, shown only once
+func UserTOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Farerpath-Token")
 	vars := mux.Vars(r)
-	result := &model.ReturnValue{}
 
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.ConfirmTOTP(verify.UserID, vars["userId"], r.FormValue("code"))
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Mail verification link(POST) to email of user again
+func UserVerificationHandler(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("X-Farerpath-Token")
-	userId := vars["userId"]
-	pictureId := vars["pictureId"]
+	vars := mux.Vars(r)
 
-	verify, err := sessionClient.VerifyToken(context.Background(), &sessionService.Token{Token: token})
+	verify, err := verifyToken(token)
 	if err != nil {
-		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
 		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
 		return
 	}
@@ -410,23 +469,1037 @@ func PictureHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Method == http.MethodGet {
-		var fileName string
-		result = server.DownloadPicture(userId, userId, pictureId, &fileName)
-		http.ServeContent(w, r, fileName, time.Now(), bytes.NewReader(result.Value))
+	result := server.RequestEmailVerification(verify.UserID, vars["userId"])
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Verify email(POST) with token of verification link, no login required
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, err := util.UnmarshalBody(&r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		return
-	} else if r.Method == http.MethodDelete {
-		result = server.DeletePicture(userId, pictureId)
+	}
 
-	} else {
-		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+	token, ok := body["token"].(string)
+	if !ok || len(token) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	result := server.VerifyEmail(token)
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Mail password reset link(POST) to email, no login required
+func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, err := util.UnmarshalBody(&r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	email, _ := body["email"].(string)
+
+	result := server.RequestPasswordReset(email)
+
 	w.WriteHeader(result.StatusCode)
 	w.Write(result.Value)
 }
 
+// Set new password(POST) with token of reset link, no login required
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, err := util.UnmarshalBody(&r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	token, ok := body["token"].(string)
+	newPassword, _ := body["newPassword"].(string)
+	if !ok || len(token) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	result := server.ResetPassword(token, newPassword)
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Request deletion(POST) of account with password, Cancel deletion(DELETE)
+// Response: time deletion starts
+func UserDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Farerpath-Token")
+	vars := mux.Vars(r)
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := &model.ReturnValue{}
+
+	if r.Method == http.MethodPost {
+		result = server.RequestAccountDeletion(verify.UserID, vars["userId"], r.FormValue("password"))
+	} else {
+		result = server.CancelAccountDeletion(verify.UserID, vars["userId"])
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Start export(POST) of user's data
+// Response: export with status to poll
+func UserExportHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Farerpath-Token")
+	vars := mux.Vars(r)
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.StartExport(verify.UserID, vars["userId"])
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Status(GET) of export
+// Response: export, with download link when it is ready
+func UserExportItemHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Farerpath-Token")
+	vars := mux.Vars(r)
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.GetExport(verify.UserID, vars["userId"], vars["exportId"])
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Download ZIP of export, token of link is the credential
+func ExportDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	server.ServeExport(w, r, vars["token"])
+}
+
+// Location privacy(PATCH) of user's pictures for other viewers
+// locationPrivacy - exact, city or none
+func UserPrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Farerpath-Token")
+	vars := mux.Vars(r)
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.UpdateLocationPrivacy(verify.UserID, vars["userId"], r.FormValue("locationPrivacy"))
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+func AlbumHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	result := &model.ReturnValue{}
+
+	token := r.Header.Get("X-Farerpath-Token")
+	userId := vars["userId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		// Get Album list
+		result = server.GetAlbumList(verify.UserID, userId)
+	} else if r.Method == http.MethodPost {
+		// Make Album
+		body, err := util.UnmarshalBody(&r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		i := func() int {
+			if _, exists := body["albumName"]; !exists {
+				return -1
+			}
+			if _, exists := body["beginTime"]; !exists {
+				return -1
+			}
+			if _, exists := body["members"]; !exists {
+				return -1
+			}
+			return 0
+		}()
+
+		if i != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Arguments are not filled"))
+			return 
+		}
+
+		result = server.MakeAlbum(verify.UserID, userId, body["albumName"].(string), body["beginTime"].(string), body["members"].(string))
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+func UserAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	var result *model.ReturnValue
+	vars := mux.Vars(r)
+
+	token := r.Header.Get("X-Farerpath-Token")
+	userID := vars["userId"]
+	albumID := vars["albumId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		result = server.GetAlbum(verify.UserID, userID, albumID)
+	} else if r.Method == http.MethodDelete {
+		if verify.UserID != userID {
+			w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+			return
+		}
+
+		result = server.DeleteAlbum(userID, albumID)
+	} else if r.Method == http.MethodPatch {
+		// Permission depends on member role, checked by album service
+		body, err := util.UnmarshalBody(&r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		result = server.UpdateAlbum(verify.UserID, albumID, body["newAlbumName"].(string), body["newBeginTime"].(string), body["newMembers"].(string))
+	} else {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+func AlbumPictureHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var result *model.ReturnValue
+
+	token := r.Header.Get("X-Farerpath-Token")
+	albumID := vars["albumId"]
+	pictureID := vars["pictureId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	// Add and remove depend on member role, checked by album service
+	if r.Method == http.MethodPost {
+		result = server.AddPictureToAlbum(verify.UserID, pictureID, albumID)
+	} else if r.Method == http.MethodDelete {
+		result = server.RemovePictureFromAlbum(verify.UserID, pictureID, albumID)
+	} else if r.Method == http.MethodGet {
+		// Visibility is checked by album service
+		var file server.PictureFile
+		result = server.DownloadPicture(verify.UserID, albumID, pictureID, r.FormValue("size"), &file)
+		if !util.IsSucced(int32(result.StatusCode)) {
+			w.WriteHeader(result.StatusCode)
+			return
+		}
+
+		server.ServePictureFile(w, r, &file)
+		return
+	}
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Member of album
+// PATCH - change role, DELETE - remove member or leave album
+func AlbumMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	result := &model.ReturnValue{}
+
+	token := r.Header.Get("X-Farerpath-Token")
+	albumID := vars["albumId"]
+	memberID := vars["memberId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		body, err := util.UnmarshalBody(&r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		role, ok := body["role"].(string)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Arguments are not filled"))
+			return
+		}
+
+		result = server.SetMemberRole(verify.UserID, albumID, memberID, role)
+	} else if r.Method == http.MethodDelete {
+		result = server.RemoveMember(verify.UserID, albumID, memberID)
+	} else {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Invitations of album
+// GET - pending invitations, POST - invite user ID or email
+func AlbumInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	result := &model.ReturnValue{}
+
+	token := r.Header.Get("X-Farerpath-Token")
+	albumID := vars["albumId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		result = server.GetAlbumInvitations(verify.UserID, albumID)
+	} else if r.Method == http.MethodPost {
+		body, err := util.UnmarshalBody(&r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		invitee, ok := body["invitee"].(string)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Arguments are not filled"))
+			return
+		}
+		role, _ := body["role"].(string)
+		expiresIn, _ := body["expiresIn"].(float64)
+
+		result = server.InviteMember(verify.UserID, albumID, invitee, role, int64(expiresIn))
+	} else {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Revoke(DELETE) invitation of album
+func AlbumInvitationItemHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.RevokeInvitation(verify.UserID, vars["invitationId"])
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Pending invitations of user
+func UserInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid || verify.UserID != vars["userId"] {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.GetInvitations(verify.UserID)
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Sessions of user
+// GET - Response: sessions, DELETE - Revoke all sessions but current
+func UserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid || verify.UserID != vars["userId"] {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := &model.ReturnValue{}
+
+	if r.Method == http.MethodGet {
+		result = server.GetSessions(verify.UserID, token)
+	} else {
+		result = server.RevokeOtherSessions(verify.UserID, token)
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Revoke(DELETE) session of user by its ID
+func UserSessionItemHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid || verify.UserID != vars["userId"] {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.RevokeSession(verify.UserID, vars["sessionId"])
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Accept or decline invitation, by {action} of path
+func UserInvitationAnswerHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid || verify.UserID != vars["userId"] {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.AnswerInvitation(verify.UserID, vars["invitationId"], vars["action"] == "accept")
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+func PictureListHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	result := &model.ReturnValue{}
+
+	token := r.Header.Get("X-Farerpath-Token")
+	userId := vars["userId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if verify.UserID != userId {
+			w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+			return
+		}
+
+		if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		file, fileHeader, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+			return
+		}
+		defer file.Close()
+		publishRange, err := strconv.Atoi(r.FormValue("publishRange"))
+		if err != nil {
+			w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+			return
+		}
+
+		result = server.UploadPicture(userId, r.FormValue("pictureName"), uint(publishRange), &file, fileHeader)
+
+	} else if r.Method == http.MethodGet {
+		if r.FormValue("archived") == "true" {
+			if verify.UserID != userId {
+				w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+				return
+			}
+
+			result = server.GetArchivedPictureList(userId)
+		} else {
+			result = server.GetPictureList(verify.UserID, userId)
+		}
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+func PictureHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	result := &model.ReturnValue{}
+
+	token := r.Header.Get("X-Farerpath-Token")
+	userId := vars["userId"]
+	pictureId := vars["pictureId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to login. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		var file server.PictureFile
+		result = server.DownloadPicture(verify.UserID, "", pictureId, r.FormValue("size"), &file)
+		if !util.IsSucced(int32(result.StatusCode)) {
+			w.WriteHeader(result.StatusCode)
+			return
+		}
+
+		server.ServePictureFile(w, r, &file)
+		return
+	} else if r.Method == http.MethodDelete {
+		result = server.DeletePicture(userId, pictureId)
+
+	} else {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Capture details(GET) of picture, read from EXIF on upload
+func PictureMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.GetPictureMetadata(verify.UserID, vars["userId"], vars["pictureId"])
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Comments of picture
+// GET - list comments, POST - add comment or reply (parentID)
+func PictureCommentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	result := &model.ReturnValue{}
+
+	token := r.Header.Get("X-Farerpath-Token")
+	pictureId := vars["pictureId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		result = server.GetComments(verify.UserID, pictureId)
+	} else if r.Method == http.MethodPost {
+		body, err := util.UnmarshalBody(&r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		value, ok := body["value"].(string)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Arguments are not filled"))
+			return
+		}
+		parentId, _ := body["parentID"].(string)
+
+		result = server.AddComment(verify.UserID, pictureId, parentId, value)
+	} else {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Single comment of picture
+// PATCH - edit comment, DELETE - delete comment with its replies
+func PictureCommentItemHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	result := &model.ReturnValue{}
+
+	token := r.Header.Get("X-Farerpath-Token")
+	pictureId := vars["pictureId"]
+	commentId := vars["commentId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		body, err := util.UnmarshalBody(&r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		value, ok := body["value"].(string)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Arguments are not filled"))
+			return
+		}
+
+		result = server.EditComment(verify.UserID, pictureId, commentId, value)
+	} else if r.Method == http.MethodDelete {
+		result = server.DeleteComment(verify.UserID, pictureId, commentId)
+	} else {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Nice shots of picture
+// GET - list users reacted, POST - add nice shot, DELETE - cancel nice shot
+func PictureNiceShotHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	result := &model.ReturnValue{}
+
+	token := r.Header.Get("X-Farerpath-Token")
+	pictureId := vars["pictureId"]
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		result = server.GetNiceShots(verify.UserID, pictureId)
+	} else if r.Method == http.MethodPost {
+		result = server.AddNiceShot(verify.UserID, pictureId)
+	} else if r.Method == http.MethodDelete {
+		result = server.SubNiceShot(verify.UserID, pictureId)
+	} else {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Share links of user
+// GET - list share links, POST - make share link of album or picture
+func ShareHandler(w http.ResponseWriter, r *http.Request) {
+	result := &model.ReturnValue{}
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		result = server.GetShareLinks(verify.UserID)
+	} else if r.Method == http.MethodPost {
+		body, err := util.UnmarshalBody(&r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		albumID, _ := body["albumID"].(string)
+		pictureID, _ := body["pictureID"].(string)
+		password, _ := body["password"].(string)
+		expiresIn, _ := body["expiresIn"].(float64)
+		maxViews, _ := body["maxViews"].(float64)
+
+		if expiresIn < 0 || maxViews < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		result = server.MakeShareLink(verify.UserID, albumID, pictureID, password, int64(expiresIn), uint32(maxViews))
+	} else {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Revoke(DELETE) share link
+func ShareItemHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	result := server.RevokeShareLink(verify.UserID, vars["shareId"])
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Shared album or picture, no login required
+// Password of protected link is sent in X-Farerpath-Share-Password header
+func SharedHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	result := server.ResolveShareLink(vars["token"], r.Header.Get("X-Farerpath-Share-Password"))
+
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.Value)
+}
+
+// Download picture of share link, no login required
+func SharedPictureHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var file server.PictureFile
+	result := server.DownloadSharedPicture(vars["token"], r.Header.Get("X-Farerpath-Share-Password"), vars["pictureId"], r.FormValue("size"), &file)
+	if !util.IsSucced(int32(result.StatusCode)) {
+		w.WriteHeader(result.StatusCode)
+		return
+	}
+
+	server.ServePictureFile(w, r, &file)
+}
+
+// tus resumable upload protocol
+const tusVersion = "1.0.0"
+
+// tusHeaders sets headers of every tus response,
+// false when client speaks other tus version
+func tusHeaders(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+// Resumable picture uploads
+// OPTIONS - tus capabilities, POST - Create upload with Upload-Length and Upload-Metadata
+func PictureUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !tusHeaders(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(server.MaxUploadLength, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var upload server.Upload
+	result := server.CreateUpload(verify.UserID, length, r.Header.Get("Upload-Metadata"), &upload)
+	if util.IsSucced(int32(result.StatusCode)) {
+		w.Header().Set("Location", r.URL.Path+"/"+upload.UploadID)
+	}
+
+	w.WriteHeader(result.StatusCode)
+}
+
+// Resumable picture upload
+// HEAD - Upload-Offset, PATCH - Append chunk at Upload-Offset, DELETE - Cancel upload
+// Last PATCH makes the picture, its ID is in X-Farerpath-Picture-Id header
+func PictureUploadItemHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if !tusHeaders(w, r) {
+		return
+	}
+
+	token := r.Header.Get("X-Farerpath-Token")
+
+	verify, err := verifyToken(token)
+	if err != nil {
+		fmt.Printf("Unable to verify session. GRPC Service returned error.\n%v", err)
+		w.WriteHeader(errors.STATUS_INTERNAL_ERROR)
+		return
+	}
+
+	if !verify.Valid {
+		w.WriteHeader(errors.STATUS_NOT_AUTHORIZED)
+		return
+	}
+
+	var upload server.Upload
+	result := &model.ReturnValue{}
+
+	if r.Method == http.MethodHead {
+		result = server.GetUpload(verify.UserID, vars["uploadId"], &upload)
+		// Offset must not be cached between retries
+		w.Header().Set("Cache-Control", "no-store")
+	} else if r.Method == http.MethodPatch {
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		result = server.WriteUpload(verify.UserID, vars["uploadId"], offset, r.Body, &upload)
+		if len(upload.PictureID) > 0 {
+			w.Header().Set("X-Farerpath-Picture-Id", upload.PictureID)
+		}
+	} else if r.Method == http.MethodDelete {
+		result = server.DeleteUpload(verify.UserID, vars["uploadId"])
+	} else {
+		w.WriteHeader(errors.STATUS_METHOD_NOT_ALLOWED)
+		return
+	}
+
+	if util.IsSucced(int32(result.StatusCode)) && r.Method != http.MethodDelete {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	}
+
+	w.WriteHeader(result.StatusCode)
+}
+
 // Dummy yet
 func PictureFileHandler(w http.ResponseWriter, r *http.Request) {
 	// result := &model.ReturnValue{}
@@ -6,11 +6,14 @@ import (
 	"regexp"
 
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	albumService "github.com/farerpath/albumservice/proto"
 	authService "github.com/farerpath/authservice/proto"
+	sessionService "github.com/farerpath/sessionservice/proto"
 
 	"github.com/farerpath/server/model/errors"
 	"github.com/farerpath/server/model/model"
@@ -18,7 +21,9 @@ import (
 	"github.com/farerpath/server/common/util"
 )
 
-func Login(loginUserId string, loginPassword string, loginDeviceType int, loginIP string) (returnValue *model.ReturnValue) {
+// Login func
+// retryAfter is set to seconds to wait when too many logins failed
+func Login(loginUserId string, loginPassword string, loginDeviceType int, loginIP string, retryAfter *string) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
 	loginRequest := authService.LoginRequest{
@@ -28,13 +33,18 @@ func Login(loginUserId string, loginPassword string, loginDeviceType int, loginI
 		LoginIP:         loginIP,
 	}
 
-	loginResp, err := authClient.Login(context.Background(), &loginRequest)
+	var trailer metadata.MD
+
+	loginResp, err := authClient.Login(context.Background(), &loginRequest, grpc.Trailer(&trailer))
 	if err != nil {
 		st, _ := status.FromError(err)
 		if st.Code() == codes.NotFound {
 			returnValue.StatusCode = http.StatusNotFound
 		} else if st.Code() == codes.Unauthenticated {
 			returnValue.StatusCode = http.StatusUnauthorized
+		} else if st.Code() == codes.ResourceExhausted {
+			returnValue.StatusCode = http.StatusTooManyRequests
+			*retryAfter = retryAfterOf(trailer)
 		} else {
 			log.Printf("Unable to login. GRPC Service returned error.\n%v", err)
 			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
@@ -43,6 +53,15 @@ func Login(loginUserId string, loginPassword string, loginDeviceType int, loginI
 		return
 	}
 
+	if loginResp.GetMfaRequired() {
+		returnValue.Value = util.MakeReturnValueToJson(&model.LoginReturnValue{
+			UserID:      loginResp.GetUserID(),
+			MfaRequired: true,
+			MfaTicket:   loginResp.GetMfaTicket(),
+		})
+		return
+	}
+
 	loginReturnValue := &model.LoginReturnValue{
 		UserName:     loginResp.GetUserName(),
 		UserID:       loginResp.GetUserID(),
@@ -55,6 +74,15 @@ func Login(loginUserId string, loginPassword string, loginDeviceType int, loginI
 	return
 }
 
+// retryAfterOf gives seconds to wait auth service sent with ResourceExhausted
+func retryAfterOf(trailer metadata.MD) string {
+	if values := trailer.Get("retry-after"); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
 func TokenLogin(token, userID string) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
@@ -73,6 +101,29 @@ func TokenLogin(token, userID string) (returnValue *model.ReturnValue) {
 	return
 }
 
+// RefreshToken func
+// Gives new auth and refresh tokens for refresh token.
+// Refresh token cannot be used again, using it again logs session out
+func RefreshToken(refreshToken, ip string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := sessionClient.ExtendSession(context.Background(), &sessionService.ExtendRequest{RefreshToken: refreshToken, ExtendIP: ip})
+	if err != nil {
+		st, _ := status.FromError(err)
+		if st.Code() == codes.Unauthenticated {
+			returnValue.StatusCode = http.StatusUnauthorized
+		} else {
+			log.Printf("Unable to refresh token. GRPC Service returned error.\n%v", err)
+			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
+		}
+
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"authToken": resp.GetAuthToken(), "refreshToken": resp.GetRefreshToken()})
+	return
+}
+
 func Logout(token string) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
@@ -7,8 +7,10 @@ import (
 	"time"
 
 	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/consts"
 	"github.com/farerpath/server/model/errors"
 	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
 
 	"golang.org/x/net/context"
 
@@ -40,42 +42,12 @@ func GetAlbumList(sessionUserId, userId string) (returnValue *model.ReturnValue)
 	return
 }
 
+// GetAlbum func
+// Visibility of album and its pictures is decided by album service
 func GetAlbum(sessionUserId, userId, albumId string) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
-	if sessionUserId != userId {
-		resp, err := albumClient.GetPublicAlbum(context.Background(), &albumService.GetPublicAlbumRequest{DstUserID: userId, AlbumID: albumId})
-		if err != nil {
-			st, _ := status.FromError(err)
-			if st.Code() == codes.NotFound {
-				returnValue.StatusCode = http.StatusNotFound
-				return
-			}
-			returnValue.StatusCode = http.StatusInternalServerError
-			return
-		}
-
-		path := model.Path{
-			Country:  resp.GetTravelPath().GetCountry(),
-			City:     resp.GetTravelPath().GetCity(),
-			Location: model.GPSData(*resp.GetTravelPath().GetLocation()),
-		}
-
-		album := &model.Album{
-			AlbumName:    resp.GetAlbumName(),
-			Owner:        resp.GetOwner(),
-			BeginTime:    time.Unix(resp.BeginTime, 0),
-			EndTime:      time.Unix(resp.GetEndTime(), 0),
-			TravelPath:   path,
-			PublishRange: resp.GetPublishRange(),
-			Members:      resp.GetMembers(),
-			//Pictures:     resp.GetPictures().Hex(), //TODO: fix
-		}
-
-		returnValue.Value = util.MakeReturnValueToJson(album)
-		return
-	}
-	resp, err := albumClient.GetAlbum(context.Background(), &albumService.GetAlbumRequest{ReqUserID: userId, AlbumID: albumId})
+	resp, err := albumClient.GetAlbum(context.Background(), &albumService.GetAlbumRequest{ReqUserID: sessionUserId, AlbumID: albumId})
 	if err != nil {
 		st, _ := status.FromError(err)
 		if st.Code() == codes.NotFound {
@@ -86,14 +58,25 @@ func GetAlbum(sessionUserId, userId, albumId string) (returnValue *model.ReturnV
 		return
 	}
 
+	if resp.GetOwner() != userId {
+		returnValue.StatusCode = http.StatusNotFound
+		return
+	}
+
 	path := model.Path{
 		Country:  resp.GetTravelPath().GetCountry(),
 		City:     resp.GetTravelPath().GetCity(),
 		Location: model.GPSData(*resp.GetTravelPath().GetLocation()),
 	}
 
+	pictures := []uoid.UOID{}
+
+	for _, picture := range resp.GetPictures() {
+		pictures = append(pictures, uoid.FromString(picture))
+	}
+
 	album := &model.Album{
-		//AlbumID:      resp.GetAlbumId(), //TODO:fix
+		AlbumID:      uoid.FromString(resp.GetAlbumID()),
 		AlbumName:    resp.GetAlbumName(),
 		Owner:        resp.GetOwner(),
 		BeginTime:    time.Unix(resp.GetBeginTime(), 0),
@@ -101,7 +84,8 @@ func GetAlbum(sessionUserId, userId, albumId string) (returnValue *model.ReturnV
 		TravelPath:   path,
 		PublishRange: resp.GetPublishRange(),
 		Members:      resp.GetMembers(),
-		//Pictures:     resp.GetPictures(),// TODO:fix
+		Roles:        resp.GetRoles(),
+		Pictures:     pictures,
 	}
 
 	returnValue.Value = util.MakeReturnValueToJson(album)
@@ -146,18 +130,15 @@ func MakeAlbum(sessionUserId, userId, albumName, beginTime, members string) (ret
 	return
 }
 
-func UpdateAlbum(userId, albumId string, newAlbumName, newBeginTime, newMembers string) (returnValue *model.ReturnValue) {
+// UpdateAlbum func
+// sessionUserId may be owner or member of album, album service checks the role
+func UpdateAlbum(sessionUserId, albumId string, newAlbumName, newBeginTime, newMembers string) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
 	if len(newAlbumName) != 0 {
-		_, err := albumClient.RenameAlbum(context.Background(), &albumService.RenameAlbumRequest{UserID: userId, AlbumID: albumId, AlbumName: newAlbumName})
+		_, err := albumClient.RenameAlbum(context.Background(), &albumService.RenameAlbumRequest{UserID: sessionUserId, AlbumID: albumId, AlbumName: newAlbumName})
 		if err != nil {
-			st, _ := status.FromError(err)
-			if st.Code() == codes.NotFound {
-				returnValue.StatusCode = http.StatusNotFound
-				return
-			}
-			returnValue.StatusCode = http.StatusInternalServerError
+			returnValue.StatusCode = grpcErrorToStatus(err)
 			return
 		}
 	}
@@ -165,14 +146,9 @@ func UpdateAlbum(userId, albumId string, newAlbumName, newBeginTime, newMembers
 	members := strings.Split(util.DelSpaces(newMembers), ",")
 
 	if len(newMembers) != 0 {
-		_, err := albumClient.AddMember(context.Background(), &albumService.AddMemberRequest{ReqUserID: userId, AlbumID: albumId, MemberID: members})
+		_, err := albumClient.AddMember(context.Background(), &albumService.AddMemberRequest{ReqUserID: sessionUserId, AlbumID: albumId, MemberID: members})
 		if err != nil {
-			st, _ := status.FromError(err)
-			if st.Code() == codes.NotFound {
-				returnValue.StatusCode = http.StatusNotFound
-				return
-			}
-			returnValue.StatusCode = http.StatusInternalServerError
+			returnValue.StatusCode = grpcErrorToStatus(err)
 			return
 		}
 	}
@@ -211,6 +187,9 @@ func AddPictureToAlbum(userId, pictureId, albumId string) (returnValue *model.Re
 		if st.Code() == codes.NotFound {
 			returnValue.StatusCode = http.StatusNotFound
 			return
+		} else if st.Code() == codes.PermissionDenied {
+			returnValue.StatusCode = http.StatusForbidden
+			return
 		}
 
 		log.Println(err)
@@ -231,6 +210,9 @@ func RemovePictureFromAlbum(userId, pictureId, albumId string) (returnValue *mod
 		if st.Code() == codes.NotFound {
 			returnValue.StatusCode = http.StatusNotFound
 			return
+		} else if st.Code() == codes.PermissionDenied {
+			returnValue.StatusCode = http.StatusForbidden
+			return
 		}
 
 		log.Println(err)
@@ -241,3 +223,40 @@ func RemovePictureFromAlbum(userId, pictureId, albumId string) (returnValue *mod
 
 	return
 }
+
+// Member roles accepted from client
+var memberRoles = map[string]uint32{
+	"viewer":      consts.ROLE_VIEWER,
+	"contributor": consts.ROLE_CONTRIBUTOR,
+	"editor":      consts.ROLE_EDITOR,
+}
+
+func SetMemberRole(sessionUserId, albumId, memberId, role string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	r, exists := memberRoles[role]
+	if !exists {
+		returnValue.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	_, err := albumClient.SetMemberRole(context.Background(), &albumService.SetMemberRoleRequest{ReqUserID: sessionUserId, AlbumID: albumId, MemberID: memberId, Role: r})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	return
+}
+
+func RemoveMember(sessionUserId, albumId, memberId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	_, err := albumClient.DelMember(context.Background(), &albumService.DelMemberRequest{ReqUserID: sessionUserId, AlbumID: albumId, MemberID: []string{memberId}})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,671 @@
+package server
+
+// bundledCities is name, country code, latitude and longitude of cities
+// tab separated, one city per line.
+// Cities are picked by hand from GeoNames (https://www.geonames.org),
+// licensed under CC BY 4.0. Full dataset is loaded with FP_GEONAMES_FILE
+const bundledCities = `Seoul	KR	37.566	126.978
+Busan	KR	35.180	129.075
+Incheon	KR	37.456	126.705
+Daegu	KR	35.871	128.601
+Daejeon	KR	36.350	127.385
+Gwangju	KR	35.160	126.852
+Ulsan	KR	35.538	129.311
+Suwon	KR	37.264	127.029
+Changwon	KR	35.228	128.681
+Seongnam	KR	37.420	127.127
+Goyang	KR	37.658	126.832
+Yongin	KR	37.241	127.178
+Bucheon	KR	37.503	126.766
+Cheongju	KR	36.642	127.489
+Ansan	KR	37.322	126.831
+Jeonju	KR	35.824	127.148
+Cheonan	KR	36.815	127.114
+Namyangju	KR	37.636	127.216
+Hwaseong	KR	37.200	126.831
+Gimhae	KR	35.229	128.889
+Pohang	KR	36.019	129.343
+Jeju	KR	33.500	126.531
+Seogwipo	KR	33.253	126.560
+Gyeongju	KR	35.856	129.225
+Gangneung	KR	37.752	128.876
+Sokcho	KR	38.207	128.592
+Chuncheon	KR	37.881	127.730
+Wonju	KR	37.342	127.920
+Yeosu	KR	34.760	127.662
+Suncheon	KR	34.951	127.487
+Mokpo	KR	34.812	126.392
+Andong	KR	36.568	128.730
+Tongyeong	KR	34.854	128.433
+Geoje	KR	34.880	128.621
+Sejong	KR	36.480	127.289
+Paju	KR	37.760	126.780
+Pyeongtaek	KR	36.992	127.113
+Gumi	KR	36.120	128.344
+Jinju	KR	35.180	128.108
+Gunsan	KR	35.968	126.737
+Iksan	KR	35.948	126.958
+Donghae	KR	37.525	129.114
+Gapyeong	KR	37.831	127.510
+Chungju	KR	36.991	127.926
+Pyongyang	KP	39.039	125.763
+Kaesong	KP	37.971	126.554
+Hamhung	KP	39.918	127.536
+Tokyo	JP	35.690	139.692
+Yokohama	JP	35.444	139.638
+Osaka	JP	34.694	135.502
+Nagoya	JP	35.181	136.906
+Sapporo	JP	43.062	141.354
+Fukuoka	JP	33.590	130.402
+Kobe	JP	34.690	135.196
+Kyoto	JP	35.012	135.768
+Kawasaki	JP	35.531	139.703
+Saitama	JP	35.861	139.645
+Hiroshima	JP	34.385	132.455
+Sendai	JP	38.268	140.870
+Chiba	JP	35.607	140.106
+Kitakyushu	JP	33.883	130.875
+Niigata	JP	37.916	139.036
+Hamamatsu	JP	34.711	137.726
+Kumamoto	JP	32.803	130.708
+Okayama	JP	34.655	133.919
+Shizuoka	JP	34.976	138.383
+Kagoshima	JP	31.597	130.557
+Nagasaki	JP	32.750	129.878
+Naha	JP	26.212	127.681
+Kanazawa	JP	36.561	136.656
+Nara	JP	34.685	135.805
+Hakodate	JP	41.769	140.729
+Matsuyama	JP	33.840	132.766
+Nikko	JP	36.720	139.698
+Kamakura	JP	35.319	139.547
+Hakone	JP	35.232	139.107
+Takayama	JP	36.146	137.252
+Matsumoto	JP	36.238	137.972
+Nagano	JP	36.649	138.195
+Aomori	JP	40.822	140.747
+Morioka	JP	39.702	141.154
+Akita	JP	39.720	140.103
+Toyama	JP	36.696	137.214
+Gifu	JP	35.423	136.761
+Wakayama	JP	34.226	135.168
+Takamatsu	JP	34.340	134.047
+Kochi	JP	33.559	133.531
+Oita	JP	33.238	131.613
+Miyazaki	JP	31.911	131.424
+Beppu	JP	33.284	131.491
+Ishigaki	JP	24.340	124.156
+Asahikawa	JP	43.771	142.365
+Kushiro	JP	42.985	144.381
+Otaru	JP	43.190	140.994
+Fujiyoshida	JP	35.487	138.808
+Beijing	CN	39.904	116.407
+Shanghai	CN	31.230	121.474
+Guangzhou	CN	23.129	113.264
+Shenzhen	CN	22.543	114.058
+Chongqing	CN	29.563	106.551
+Tianjin	CN	39.343	117.362
+Chengdu	CN	30.573	104.066
+Wuhan	CN	30.593	114.305
+Xi'an	CN	34.341	108.940
+Hangzhou	CN	30.274	120.155
+Nanjing	CN	32.060	118.797
+Shenyang	CN	41.806	123.432
+Harbin	CN	45.803	126.535
+Qingdao	CN	36.067	120.383
+Dalian	CN	38.914	121.615
+Xiamen	CN	24.480	118.089
+Kunming	CN	25.039	102.718
+Guilin	CN	25.274	110.290
+Lhasa	CN	29.652	91.172
+Urumqi	CN	43.826	87.617
+Suzhou	CN	31.299	120.585
+Zhengzhou	CN	34.747	113.625
+Changsha	CN	28.228	112.939
+Jinan	CN	36.651	117.120
+Fuzhou	CN	26.074	119.296
+Sanya	CN	18.253	109.512
+Haikou	CN	20.044	110.199
+Hohhot	CN	40.842	111.749
+Lanzhou	CN	36.061	103.834
+Yanji	CN	42.904	129.508
+Zhangjiajie	CN	29.117	110.479
+Nanning	CN	22.817	108.366
+Guiyang	CN	26.647	106.630
+Taiyuan	CN	37.871	112.549
+Shijiazhuang	CN	38.042	114.514
+Hefei	CN	31.820	117.227
+Nanchang	CN	28.682	115.858
+Changchun	CN	43.817	125.324
+Xining	CN	36.617	101.778
+Yinchuan	CN	38.487	106.231
+Hong Kong	HK	22.319	114.169
+Macau	MO	22.199	113.544
+Taipei	TW	25.033	121.565
+Kaohsiung	TW	22.627	120.301
+Taichung	TW	24.148	120.674
+Tainan	TW	22.999	120.227
+Hualien	TW	23.977	121.604
+Ulaanbaatar	MN	47.886	106.906
+Bangkok	TH	13.756	100.502
+Chiang Mai	TH	18.788	98.985
+Phuket	TH	7.880	98.392
+Pattaya	TH	12.924	100.883
+Krabi	TH	8.086	98.907
+Ko Samui	TH	9.512	100.014
+Ayutthaya	TH	14.353	100.569
+Chiang Rai	TH	19.910	99.840
+Hanoi	VN	21.028	105.834
+Ho Chi Minh City	VN	10.823	106.630
+Da Nang	VN	16.054	108.202
+Hoi An	VN	15.880	108.338
+Hue	VN	16.464	107.590
+Nha Trang	VN	12.238	109.197
+Ha Long	VN	20.951	107.080
+Da Lat	VN	11.940	108.458
+Duong Dong	VN	10.217	103.960
+Sa Pa	VN	22.336	103.844
+Manila	PH	14.599	120.984
+Quezon City	PH	14.676	121.044
+Cebu City	PH	10.316	123.885
+Davao	PH	7.190	125.455
+Puerto Princesa	PH	9.740	118.736
+Kuala Lumpur	MY	3.139	101.687
+George Town	MY	5.414	100.330
+Kota Kinabalu	MY	5.980	116.074
+Johor Bahru	MY	1.493	103.741
+Malacca	MY	2.189	102.250
+Kuching	MY	1.553	110.359
+Singapore	SG	1.352	103.820
+Jakarta	ID	-6.208	106.846
+Surabaya	ID	-7.257	112.752
+Bandung	ID	-6.917	107.619
+Denpasar	ID	-8.650	115.216
+Ubud	ID	-8.507	115.263
+Yogyakarta	ID	-7.796	110.369
+Medan	ID	3.595	98.672
+Makassar	ID	-5.148	119.432
+Phnom Penh	KH	11.556	104.928
+Siem Reap	KH	13.362	103.860
+Vientiane	LA	17.975	102.633
+Luang Prabang	LA	19.886	102.135
+Yangon	MM	16.871	96.199
+Mandalay	MM	21.959	96.089
+Naypyidaw	MM	19.763	96.078
+Nyaung-U	MM	21.194	94.914
+Bandar Seri Begawan	BN	4.903	114.940
+Dili	TL	-8.556	125.560
+New Delhi	IN	28.614	77.209
+Mumbai	IN	19.076	72.878
+Bengaluru	IN	12.972	77.595
+Kolkata	IN	22.573	88.364
+Chennai	IN	13.083	80.271
+Hyderabad	IN	17.385	78.487
+Ahmedabad	IN	23.023	72.571
+Pune	IN	18.520	73.857
+Jaipur	IN	26.912	75.787
+Agra	IN	27.177	78.008
+Varanasi	IN	25.318	82.974
+Panaji	IN	15.490	73.828
+Kochi	IN	9.931	76.267
+Udaipur	IN	24.585	73.713
+Amritsar	IN	31.634	74.872
+Lucknow	IN	26.847	80.947
+Srinagar	IN	34.084	74.797
+Leh	IN	34.152	77.577
+Darjeeling	IN	27.036	88.263
+Islamabad	PK	33.684	73.048
+Karachi	PK	24.861	67.010
+Lahore	PK	31.520	74.359
+Dhaka	BD	23.810	90.413
+Chittagong	BD	22.357	91.783
+Colombo	LK	6.927	79.861
+Kandy	LK	7.291	80.634
+Galle	LK	6.053	80.221
+Kathmandu	NP	27.717	85.324
+Pokhara	NP	28.210	83.986
+Thimphu	BT	27.472	89.639
+Male	MV	4.175	73.509
+Kabul	AF	34.555	69.207
+Almaty	KZ	43.222	76.851
+Astana	KZ	51.169	71.449
+Tashkent	UZ	41.299	69.240
+Samarkand	UZ	39.654	66.976
+Bukhara	UZ	39.768	64.421
+Bishkek	KG	42.875	74.570
+Dushanbe	TJ	38.560	68.787
+Ashgabat	TM	37.960	58.326
+Dubai	AE	25.205	55.271
+Abu Dhabi	AE	24.454	54.377
+Doha	QA	25.285	51.531
+Riyadh	SA	24.713	46.675
+Jeddah	SA	21.485	39.193
+Mecca	SA	21.389	39.858
+Jerusalem	IL	31.769	35.216
+Tel Aviv	IL	32.085	34.782
+Amman	JO	31.954	35.911
+Wadi Musa	JO	30.322	35.479
+Beirut	LB	33.894	35.502
+Damascus	SY	33.513	36.276
+Baghdad	IQ	33.315	44.366
+Tehran	IR	35.689	51.389
+Isfahan	IR	32.652	51.668
+Shiraz	IR	29.592	52.584
+Istanbul	TR	41.008	28.978
+Ankara	TR	39.933	32.860
+Izmir	TR	38.424	27.143
+Antalya	TR	36.897	30.713
+Goreme	TR	38.643	34.829
+Bodrum	TR	37.034	27.430
+Kuwait City	KW	29.376	47.977
+Manama	BH	26.229	50.586
+Muscat	OM	23.588	58.383
+Sanaa	YE	15.369	44.191
+Nicosia	CY	35.185	33.382
+Tbilisi	GE	41.715	44.827
+Yerevan	AM	40.179	44.499
+Baku	AZ	40.409	49.867
+London	GB	51.507	-0.128
+Manchester	GB	53.481	-2.243
+Birmingham	GB	52.486	-1.890
+Edinburgh	GB	55.953	-3.188
+Glasgow	GB	55.864	-4.252
+Liverpool	GB	53.408	-2.991
+Oxford	GB	51.752	-1.258
+Cambridge	GB	52.205	0.122
+Bath	GB	51.381	-2.359
+Cardiff	GB	51.481	-3.179
+Belfast	GB	54.597	-5.930
+Inverness	GB	57.478	-4.224
+York	GB	53.960	-1.087
+Dublin	IE	53.350	-6.260
+Cork	IE	51.899	-8.476
+Galway	IE	53.271	-9.057
+Paris	FR	48.857	2.352
+Marseille	FR	43.296	5.370
+Lyon	FR	45.764	4.836
+Nice	FR	43.710	7.262
+Toulouse	FR	43.605	1.444
+Bordeaux	FR	44.838	-0.579
+Strasbourg	FR	48.573	7.752
+Nantes	FR	47.218	-1.554
+Lille	FR	50.629	3.057
+Montpellier	FR	43.611	3.877
+Chamonix	FR	45.924	6.870
+Avignon	FR	43.949	4.806
+Cannes	FR	43.552	7.017
+Ajaccio	FR	41.919	8.739
+Le Mont-Saint-Michel	FR	48.636	-1.511
+Monaco	MC	43.738	7.424
+Berlin	DE	52.520	13.405
+Hamburg	DE	53.551	9.994
+Munich	DE	48.135	11.582
+Cologne	DE	50.938	6.960
+Frankfurt am Main	DE	50.110	8.682
+Stuttgart	DE	48.776	9.183
+Dusseldorf	DE	51.228	6.774
+Dresden	DE	51.050	13.738
+Leipzig	DE	51.340	12.375
+Heidelberg	DE	49.398	8.673
+Nuremberg	DE	49.452	11.077
+Bremen	DE	53.079	8.802
+Hanover	DE	52.376	9.732
+Fussen	DE	47.571	10.701
+Vienna	AT	48.208	16.374
+Salzburg	AT	47.810	13.055
+Innsbruck	AT	47.269	11.404
+Hallstatt	AT	47.562	13.649
+Graz	AT	47.071	15.440
+Zurich	CH	47.377	8.541
+Geneva	CH	46.204	6.143
+Bern	CH	46.948	7.447
+Basel	CH	47.560	7.589
+Lucerne	CH	47.050	8.309
+Interlaken	CH	46.686	7.863
+Zermatt	CH	46.020	7.749
+Lausanne	CH	46.520	6.633
+Vaduz	LI	47.141	9.521
+Rome	IT	41.903	12.496
+Milan	IT	45.464	9.190
+Naples	IT	40.852	14.268
+Turin	IT	45.070	7.687
+Florence	IT	43.770	11.256
+Venice	IT	45.441	12.316
+Bologna	IT	44.495	11.343
+Genoa	IT	44.406	8.934
+Palermo	IT	38.116	13.361
+Pisa	IT	43.723	10.402
+Verona	IT	45.438	10.992
+Siena	IT	43.319	11.331
+Amalfi	IT	40.634	14.603
+Bari	IT	41.117	16.872
+Catania	IT	37.502	15.087
+Cagliari	IT	39.224	9.122
+La Spezia	IT	44.102	9.824
+Vatican City	VA	41.902	12.453
+San Marino	SM	43.936	12.447
+Valletta	MT	35.899	14.514
+Madrid	ES	40.417	-3.704
+Barcelona	ES	41.385	2.173
+Valencia	ES	39.470	-0.376
+Seville	ES	37.389	-5.984
+Granada	ES	37.177	-3.599
+Malaga	ES	36.721	-4.421
+Bilbao	ES	43.263	-2.935
+Palma	ES	39.570	2.650
+San Sebastian	ES	43.318	-1.981
+Cordoba	ES	37.888	-4.779
+Toledo	ES	39.863	-4.027
+Santiago de Compostela	ES	42.878	-8.545
+Ibiza	ES	38.907	1.421
+Las Palmas de Gran Canaria	ES	28.124	-15.430
+Santa Cruz de Tenerife	ES	28.464	-16.252
+Lisbon	PT	38.722	-9.139
+Porto	PT	41.158	-8.629
+Faro	PT	37.019	-7.930
+Funchal	PT	32.651	-16.908
+Sintra	PT	38.803	-9.382
+Ponta Delgada	PT	37.741	-25.668
+Andorra la Vella	AD	42.506	1.522
+Amsterdam	NL	52.368	4.904
+Rotterdam	NL	51.924	4.478
+The Hague	NL	52.070	4.300
+Utrecht	NL	52.091	5.122
+Brussels	BE	50.850	4.352
+Antwerp	BE	51.219	4.402
+Bruges	BE	51.209	3.225
+Ghent	BE	51.054	3.717
+Luxembourg	LU	49.612	6.130
+Copenhagen	DK	55.676	12.568
+Aarhus	DK	56.163	10.204
+Stockholm	SE	59.329	18.069
+Gothenburg	SE	57.709	11.975
+Malmo	SE	55.605	13.004
+Kiruna	SE	67.856	20.225
+Oslo	NO	59.914	10.752
+Bergen	NO	60.391	5.322
+Tromso	NO	69.649	18.956
+Trondheim	NO	63.431	10.395
+Stavanger	NO	58.970	5.733
+Helsinki	FI	60.170	24.938
+Rovaniemi	FI	66.503	25.729
+Turku	FI	60.452	22.267
+Reykjavik	IS	64.147	-21.943
+Akureyri	IS	65.684	-18.088
+Warsaw	PL	52.230	21.012
+Krakow	PL	50.065	19.945
+Gdansk	PL	54.352	18.647
+Wroclaw	PL	51.108	17.039
+Poznan	PL	52.406	16.925
+Prague	CZ	50.076	14.438
+Brno	CZ	49.195	16.607
+Cesky Krumlov	CZ	48.811	14.315
+Bratislava	SK	48.149	17.107
+Budapest	HU	47.498	19.040
+Ljubljana	SI	46.057	14.506
+Bled	SI	46.369	14.114
+Zagreb	HR	45.815	15.982
+Split	HR	43.508	16.440
+Dubrovnik	HR	42.651	18.094
+Zadar	HR	44.119	15.231
+Sarajevo	BA	43.856	18.413
+Mostar	BA	43.343	17.808
+Belgrade	RS	44.787	20.457
+Podgorica	ME	42.431	19.259
+Kotor	ME	42.425	18.771
+Tirana	AL	41.328	19.819
+Skopje	MK	41.998	21.425
+Pristina	XK	42.663	21.166
+Sofia	BG	42.698	23.322
+Varna	BG	43.214	27.914
+Bucharest	RO	44.427	26.103
+Brasov	RO	45.658	25.601
+Cluj-Napoca	RO	46.771	23.624
+Chisinau	MD	47.011	28.863
+Kyiv	UA	50.450	30.524
+Lviv	UA	49.840	24.030
+Odesa	UA	46.482	30.723
+Minsk	BY	53.904	27.562
+Vilnius	LT	54.687	25.280
+Riga	LV	56.950	24.105
+Tallinn	EE	59.437	24.754
+Moscow	RU	55.756	37.617
+Saint Petersburg	RU	59.931	30.361
+Novosibirsk	RU	55.008	82.935
+Yekaterinburg	RU	56.839	60.606
+Kazan	RU	55.796	49.106
+Vladivostok	RU	43.116	131.886
+Irkutsk	RU	52.287	104.305
+Sochi	RU	43.603	39.734
+Murmansk	RU	68.970	33.075
+Kaliningrad	RU	54.710	20.452
+Khabarovsk	RU	48.480	135.072
+Yakutsk	RU	62.035	129.675
+Athens	GR	37.984	23.728
+Thessaloniki	GR	40.640	22.944
+Fira	GR	36.417	25.432
+Mykonos	GR	37.446	25.329
+Heraklion	GR	35.339	25.144
+Chania	GR	35.514	24.018
+Rhodes	GR	36.434	28.217
+Corfu	GR	39.624	19.922
+Cairo	EG	30.044	31.236
+Alexandria	EG	31.200	29.919
+Luxor	EG	25.687	32.640
+Aswan	EG	24.089	32.899
+Hurghada	EG	27.258	33.812
+Sharm el-Sheikh	EG	27.916	34.330
+Rabat	MA	34.020	-6.841
+Casablanca	MA	33.573	-7.590
+Marrakesh	MA	31.629	-7.981
+Fes	MA	34.033	-5.000
+Chefchaouen	MA	35.171	-5.270
+Tangier	MA	35.759	-5.834
+Tunis	TN	36.806	10.181
+Algiers	DZ	36.754	3.059
+Tripoli	LY	32.887	13.191
+Khartoum	SD	15.501	32.560
+Addis Ababa	ET	9.030	38.740
+Nairobi	KE	-1.292	36.822
+Mombasa	KE	-4.044	39.668
+Dar es Salaam	TZ	-6.792	39.208
+Arusha	TZ	-3.387	36.683
+Zanzibar	TZ	-6.165	39.202
+Dodoma	TZ	-6.163	35.752
+Kampala	UG	0.348	32.582
+Kigali	RW	-1.944	30.062
+Johannesburg	ZA	-26.204	28.047
+Cape Town	ZA	-33.925	18.424
+Durban	ZA	-29.858	31.022
+Pretoria	ZA	-25.747	28.229
+Gqeberha	ZA	-33.958	25.600
+Windhoek	NA	-22.560	17.066
+Gaborone	BW	-24.628	25.923
+Maun	BW	-19.983	23.417
+Harare	ZW	-17.825	31.034
+Victoria Falls	ZW	-17.932	25.831
+Lusaka	ZM	-15.388	28.323
+Livingstone	ZM	-17.842	25.855
+Maputo	MZ	-25.969	32.573
+Antananarivo	MG	-18.879	47.508
+Port Louis	MU	-20.161	57.499
+Victoria	SC	-4.620	55.455
+Lagos	NG	6.524	3.379
+Abuja	NG	9.076	7.399
+Accra	GH	5.604	-0.187
+Dakar	SN	14.716	-17.467
+Abidjan	CI	5.360	-4.008
+Yaounde	CM	3.848	11.502
+Kinshasa	CD	-4.441	15.266
+Luanda	AO	-8.839	13.289
+Bamako	ML	12.639	-8.003
+Niamey	NE	13.512	2.113
+N'Djamena	TD	12.134	15.056
+Mogadishu	SO	2.047	45.318
+Djibouti	DJ	11.589	43.145
+Asmara	ER	15.322	38.925
+Lilongwe	MW	-13.963	33.775
+Praia	CV	14.933	-23.513
+New York City	US	40.713	-74.006
+Los Angeles	US	34.052	-118.244
+Chicago	US	41.878	-87.630
+Houston	US	29.760	-95.370
+Phoenix	US	33.448	-112.074
+Philadelphia	US	39.953	-75.165
+San Antonio	US	29.424	-98.494
+San Diego	US	32.716	-117.161
+Dallas	US	32.777	-96.797
+San Jose	US	37.339	-121.895
+Austin	US	30.267	-97.743
+San Francisco	US	37.775	-122.419
+Seattle	US	47.606	-122.332
+Denver	US	39.739	-104.990
+Washington	US	38.907	-77.037
+Boston	US	42.360	-71.059
+Las Vegas	US	36.170	-115.140
+Miami	US	25.762	-80.192
+Atlanta	US	33.749	-84.388
+New Orleans	US	29.951	-90.072
+Portland	US	45.515	-122.679
+Honolulu	US	21.307	-157.858
+Anchorage	US	61.218	-149.900
+Orlando	US	28.538	-81.379
+Nashville	US	36.163	-86.781
+Salt Lake City	US	40.761	-111.891
+Detroit	US	42.331	-83.046
+Minneapolis	US	44.978	-93.265
+Hilo	US	19.707	-155.082
+Flagstaff	US	35.198	-111.651
+Moab	US	38.573	-109.550
+Jackson	US	43.480	-110.762
+Key West	US	24.555	-81.780
+Fairbanks	US	64.838	-147.716
+Juneau	US	58.302	-134.420
+Savannah	US	32.081	-81.091
+Charleston	US	32.776	-79.931
+Pittsburgh	US	40.441	-79.996
+Sacramento	US	38.582	-121.494
+Santa Fe	US	35.687	-105.938
+Toronto	CA	43.653	-79.383
+Montreal	CA	45.502	-73.567
+Vancouver	CA	49.283	-123.121
+Calgary	CA	51.045	-114.058
+Ottawa	CA	45.421	-75.697
+Edmonton	CA	53.546	-113.494
+Quebec	CA	46.813	-71.208
+Winnipeg	CA	49.895	-97.138
+Halifax	CA	44.649	-63.575
+Victoria	CA	48.428	-123.366
+Banff	CA	51.178	-115.571
+Whitehorse	CA	60.721	-135.057
+Yellowknife	CA	62.454	-114.372
+St. John's	CA	47.562	-52.713
+Mexico City	MX	19.433	-99.133
+Guadalajara	MX	20.660	-103.350
+Monterrey	MX	25.687	-100.316
+Cancun	MX	21.161	-86.851
+Tijuana	MX	32.515	-117.038
+Oaxaca	MX	17.073	-96.727
+Merida	MX	20.967	-89.592
+Puerto Vallarta	MX	20.653	-105.225
+Cabo San Lucas	MX	22.891	-109.916
+Playa del Carmen	MX	20.629	-87.073
+Tulum	MX	20.211	-87.466
+Guatemala City	GT	14.634	-90.507
+Antigua Guatemala	GT	14.557	-90.733
+Belmopan	BZ	17.251	-88.759
+San Salvador	SV	13.693	-89.218
+Tegucigalpa	HN	14.072	-87.192
+Managua	NI	12.114	-86.236
+San Jose	CR	9.928	-84.091
+Panama City	PA	8.983	-79.520
+Havana	CU	23.113	-82.366
+Kingston	JM	17.971	-76.793
+Santo Domingo	DO	18.486	-69.931
+Punta Cana	DO	18.582	-68.405
+San Juan	PR	18.466	-66.106
+Port-au-Prince	HT	18.594	-72.307
+Nassau	BS	25.048	-77.355
+Bridgetown	BB	13.098	-59.618
+Port of Spain	TT	10.660	-61.508
+Bogota	CO	4.711	-74.072
+Medellin	CO	6.244	-75.581
+Cartagena	CO	10.391	-75.479
+Cali	CO	3.452	-76.532
+Caracas	VE	10.481	-66.904
+Quito	EC	-0.181	-78.467
+Guayaquil	EC	-2.171	-79.922
+Puerto Ayora	EC	-0.743	-90.313
+Lima	PE	-12.046	-77.043
+Cusco	PE	-13.532	-71.967
+Arequipa	PE	-16.409	-71.537
+Aguas Calientes	PE	-13.155	-72.525
+La Paz	BO	-16.490	-68.119
+Sucre	BO	-19.020	-65.262
+Uyuni	BO	-20.460	-66.825
+Santiago	CL	-33.449	-70.669
+Valparaiso	CL	-33.047	-71.612
+Punta Arenas	CL	-53.163	-70.917
+San Pedro de Atacama	CL	-22.911	-68.200
+Puerto Natales	CL	-51.723	-72.506
+Hanga Roa	CL	-27.150	-109.433
+Buenos Aires	AR	-34.604	-58.382
+Cordoba	AR	-31.420	-64.189
+Mendoza	AR	-32.890	-68.845
+San Carlos de Bariloche	AR	-41.133	-71.310
+Ushuaia	AR	-54.801	-68.303
+El Calafate	AR	-50.338	-72.265
+Salta	AR	-24.782	-65.423
+Puerto Iguazu	AR	-25.598	-54.573
+Montevideo	UY	-34.901	-56.165
+Asuncion	PY	-25.264	-57.576
+Sao Paulo	BR	-23.551	-46.633
+Rio de Janeiro	BR	-22.907	-43.173
+Brasilia	BR	-15.794	-47.882
+Salvador	BR	-12.977	-38.501
+Fortaleza	BR	-3.732	-38.527
+Belo Horizonte	BR	-19.917	-43.935
+Manaus	BR	-3.119	-60.022
+Recife	BR	-8.048	-34.877
+Porto Alegre	BR	-30.035	-51.218
+Curitiba	BR	-25.429	-49.271
+Florianopolis	BR	-27.595	-48.548
+Foz do Iguacu	BR	-25.547	-54.588
+Belem	BR	-1.456	-48.490
+Georgetown	GY	6.801	-58.155
+Paramaribo	SR	5.852	-55.204
+Sydney	AU	-33.869	151.209
+Melbourne	AU	-37.814	144.963
+Brisbane	AU	-27.470	153.026
+Perth	AU	-31.950	115.860
+Adelaide	AU	-34.929	138.601
+Gold Coast	AU	-28.017	153.400
+Canberra	AU	-35.281	149.130
+Hobart	AU	-42.882	147.327
+Darwin	AU	-12.463	130.842
+Cairns	AU	-16.919	145.771
+Alice Springs	AU	-23.698	133.881
+Yulara	AU	-25.241	130.985
+Broome	AU	-17.961	122.236
+Byron Bay	AU	-28.647	153.602
+Auckland	NZ	-36.848	174.763
+Wellington	NZ	-41.287	174.776
+Christchurch	NZ	-43.532	172.637
+Queenstown	NZ	-45.031	168.663
+Rotorua	NZ	-38.137	176.251
+Dunedin	NZ	-45.879	170.503
+Suva	FJ	-18.142	178.442
+Nadi	FJ	-17.800	177.416
+Port Moresby	PG	-9.443	147.180
+Noumea	NC	-22.276	166.458
+Papeete	PF	-17.535	-149.569
+Apia	WS	-13.834	-171.761
+Nuku'alofa	TO	-21.139	-175.204
+Port Vila	VU	-17.734	168.322
+Hagatna	GU	13.476	144.748
+Tamuning	GU	13.488	144.781
+Saipan	MP	15.177	145.751
+Koror	PW	7.342	134.479
+Nuuk	GL	64.181	-51.694
+Torshavn	FO	62.009	-6.772
+`
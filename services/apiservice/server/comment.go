@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"golang.org/x/net/context"
+
+	albumService "github.com/farerpath/albumservice/proto"
+	authService "github.com/farerpath/authservice/proto"
+)
+
+func GetComments(sessionUserId, pictureId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.GetComments(context.Background(), &albumService.GetCommentsRequest{ReqUserID: sessionUserId, PictureID: pictureId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	comments := []model.Comment{}
+
+	for _, comment := range resp.GetComments() {
+		comments = append(comments, commentFromPb(comment))
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(comments)
+	return
+}
+
+func AddComment(sessionUserId, pictureId, parentId, value string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	userName := ""
+	user, err := authClient.GetUser(context.Background(), &authService.GetUserRequest{UserID: sessionUserId})
+	if err == nil {
+		userName = user.GetUserName()
+	}
+
+	resp, err := albumClient.AddComment(context.Background(), &albumService.AddCommentRequest{
+		ReqUserID: sessionUserId,
+		PictureID: pictureId,
+		Comment:   &albumService.Comment{UserName: userName, Value: value, ParentID: parentId},
+	})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.StatusCode = http.StatusCreated
+	returnValue.Value = util.MakeReturnValueToJson(commentFromPb(resp.GetComment()))
+	return
+}
+
+func EditComment(sessionUserId, pictureId, commentId, value string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.EditComment(context.Background(), &albumService.EditCommentRequest{ReqUserID: sessionUserId, PictureID: pictureId, CommentID: commentId, Value: value})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(commentFromPb(resp.GetComment()))
+	return
+}
+
+func DeleteComment(sessionUserId, pictureId, commentId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	_, err := albumClient.DelComment(context.Background(), &albumService.DelCommentRequest{ReqUserID: sessionUserId, PictureID: pictureId, CommentID: commentId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.StatusCode = http.StatusNoContent
+	return
+}
+
+func commentFromPb(comment *albumService.Comment) model.Comment {
+	history := []model.CommentEdit{}
+
+	for _, edit := range comment.GetEditHistory() {
+		history = append(history, model.CommentEdit{Value: edit.GetValue(), Time: edit.GetTime()})
+	}
+
+	return model.Comment{
+		Owner:       comment.GetOwner(),
+		UserName:    comment.GetUserName(),
+		Value:       comment.GetValue(),
+		Time:        comment.GetTime(),
+		CommentID:   uoid.FromString(comment.GetCommentID()),
+		ParentID:    uoid.FromString(comment.GetParentID()),
+		EditedTime:  comment.GetEditedTime(),
+		EditHistory: history,
+	}
+}
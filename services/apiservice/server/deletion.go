@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	authService "github.com/farerpath/authservice/proto"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/errors"
+	"github.com/farerpath/server/model/model"
+)
+
+// RequestAccountDeletion func
+// Schedules deletion of account with all albums and pictures,
+// user can cancel it until deleteAt
+func RequestAccountDeletion(sessionUserId, userId, password string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	resp, err := authClient.RequestAccountDeletion(context.Background(), &authService.RequestAccountDeletionRequest{UserID: userId, Password: password})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.Unauthenticated:
+			returnValue.StatusCode = errors.STATUS_FARERPATH_ERROR
+			returnValue.Value = []byte(errors.FP_PASSWORD_NOT_MATCH)
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.StatusCode = http.StatusAccepted
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"deleteAt": time.Unix(resp.GetDeleteAt(), 0).UTC()})
+	return
+}
+
+// CancelAccountDeletion func
+// Keeps account, deletion cannot be cancelled after it started
+func CancelAccountDeletion(sessionUserId, userId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	_, err := authClient.CancelAccountDeletion(context.Background(), &authService.CancelAccountDeletionRequest{UserID: userId})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.FailedPrecondition:
+			returnValue.StatusCode = http.StatusConflict
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.StatusCode = http.StatusNoContent
+	return
+}
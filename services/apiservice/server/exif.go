@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+
+	albumService "github.com/farerpath/albumservice/proto"
+)
+
+// Offsets of capture times, EXIF 2.31 tags unknown to goexif
+const (
+	offsetTime          exif.FieldName = "OffsetTime"
+	offsetTimeOriginal  exif.FieldName = "OffsetTimeOriginal"
+	offsetTimeDigitized exif.FieldName = "OffsetTimeDigitized"
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+func init() {
+	exif.RegisterParsers(offsetTimeParser{})
+}
+
+// offsetTimeParser loads offset tags from EXIF sub-IFD
+type offsetTimeParser struct{}
+
+// Parse never fails, pictures without offset tags are common
+func (offsetTimeParser) Parse(x *exif.Exif) error {
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil || tag.Count < 1 {
+		return nil
+	}
+
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil
+	}
+
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+
+	x.LoadTags(dir, map[uint16]exif.FieldName{
+		0x9010: offsetTime,
+		0x9011: offsetTimeOriginal,
+		0x9012: offsetTimeDigitized,
+	}, false)
+
+	return nil
+}
+
+// pictureExif is what upload reads from EXIF of picture
+type pictureExif struct {
+	// captured is zero when EXIF has no capture time
+	captured time.Time
+	location *albumService.GPSData
+	metadata *albumService.PictureMetadata
+}
+
+// readExif reads EXIF of file, missing or broken EXIF gives empty result
+func readExif(file io.Reader) *pictureExif {
+	result := &pictureExif{metadata: &albumService.PictureMetadata{}}
+
+	x, err := exif.Decode(file)
+	if x == nil || (err != nil && exif.IsCriticalError(err)) {
+		return result
+	}
+
+	metadata := result.metadata
+
+	metadata.Make = exifString(x, exif.Make)
+	metadata.Model = exifString(x, exif.Model)
+	metadata.LensMake = exifString(x, exif.LensMake)
+	metadata.LensModel = exifString(x, exif.LensModel)
+	metadata.FocalLength = exifFloat(x, exif.FocalLength)
+	metadata.EquivalentFocalLength = exifUint(x, exif.FocalLengthIn35mmFilm)
+	metadata.Aperture = exifFloat(x, exif.FNumber)
+	metadata.ExposureTime = exifExposureTime(x)
+	metadata.IsoSpeed = exifUint(x, exif.ISOSpeedRatings)
+	metadata.Orientation = exifUint(x, exif.Orientation)
+
+	metadata.Width = exifUint(x, exif.PixelXDimension)
+	metadata.Height = exifUint(x, exif.PixelYDimension)
+	if metadata.Width == 0 || metadata.Height == 0 {
+		metadata.Width = exifUint(x, exif.ImageWidth)
+		metadata.Height = exifUint(x, exif.ImageLength)
+	}
+
+	if altitude, ok := exifRat(x, exif.GPSAltitude, 0); ok {
+		// Ref 1 is below sea level
+		if exifUint(x, exif.GPSAltitudeRef) == 1 {
+			altitude = -altitude
+		}
+		metadata.Altitude = strconv.FormatFloat(altitude, 'f', -1, 64)
+	}
+
+	if lat, lng, err := x.LatLong(); err == nil {
+		result.location = &albumService.GPSData{
+			Latitude:  strconv.FormatFloat(lat, 'f', -1, 64),
+			Longitude: strconv.FormatFloat(lng, 'f', -1, 64),
+			Altitude:  metadata.Altitude,
+		}
+	}
+
+	result.captured, metadata.CaptureTime = exifCaptureTime(x)
+
+	return result
+}
+
+// exifCaptureTime gives capture time and its local representation.
+// Offset is taken from offset tag, or from difference to GPS time.
+// Without offset wall clock is taken as UTC and local time has no offset
+func exifCaptureTime(x *exif.Exif) (time.Time, string) {
+	times := []struct{ time, offset exif.FieldName }{
+		{exif.DateTimeOriginal, offsetTimeOriginal},
+		{exif.DateTimeDigitized, offsetTimeDigitized},
+		{exif.DateTime, offsetTime},
+	}
+
+	for _, field := range times {
+		wall, err := time.Parse(exifTimeLayout, exifString(x, field.time))
+		if err != nil {
+			continue
+		}
+
+		zone, ok := exifOffset(exifString(x, field.offset))
+		if !ok {
+			zone, ok = gpsOffset(x, wall)
+		}
+
+		if !ok {
+			return wall, wall.Format("2006-01-02T15:04:05")
+		}
+
+		captured := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, zone)
+		return captured, captured.Format(time.RFC3339)
+	}
+
+	return time.Time{}, ""
+}
+
+// exifOffset parses offset tag like "+09:00"
+func exifOffset(value string) (*time.Location, bool) {
+	offset, err := time.Parse("-07:00", value)
+	if err != nil {
+		return nil, false
+	}
+
+	_, seconds := offset.Zone()
+	return time.FixedZone("", seconds), true
+}
+
+// gpsOffset guesses offset of wall clock from GPS time in UTC,
+// rounded to quarter hour as cameras are rarely set to the second
+func gpsOffset(x *exif.Exif, wall time.Time) (*time.Location, bool) {
+	date, err := time.Parse("2006:01:02", exifString(x, exif.GPSDateStamp))
+	if err != nil {
+		return nil, false
+	}
+
+	var clock [3]float64
+	for i := range clock {
+		value, ok := exifRat(x, exif.GPSTimeStamp, i)
+		if !ok {
+			return nil, false
+		}
+		clock[i] = value
+	}
+
+	gps := date.Add(time.Duration(clock[0]*float64(time.Hour) + clock[1]*float64(time.Minute) + clock[2]*float64(time.Second)))
+
+	offset := wall.Sub(gps).Round(15 * time.Minute)
+	if offset < -14*time.Hour || offset > 14*time.Hour {
+		return nil, false
+	}
+
+	return time.FixedZone("", int(offset/time.Second)), true
+}
+
+// exifExposureTime formats exposure as photographers write it, "1/250" or "2"
+func exifExposureTime(x *exif.Exif) string {
+	exposure, ok := exifRat(x, exif.ExposureTime, 0)
+	if !ok || exposure <= 0 {
+		return ""
+	}
+
+	if exposure < 1 {
+		return fmt.Sprintf("1/%d", int64(math.Round(1/exposure)))
+	}
+
+	return strconv.FormatFloat(exposure, 'f', -1, 64)
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
+
+func exifUint(x *exif.Exif, name exif.FieldName) uint32 {
+	tag, err := x.Get(name)
+	if err != nil || tag.Count < 1 {
+		return 0
+	}
+
+	value, err := tag.Int64(0)
+	if err != nil || value < 0 || value > math.MaxUint32 {
+		return 0
+	}
+
+	return uint32(value)
+}
+
+func exifRat(x *exif.Exif, name exif.FieldName, i int) (float64, bool) {
+	tag, err := x.Get(name)
+	if err != nil || int(tag.Count) <= i {
+		return 0, false
+	}
+
+	num, den, err := tag.Rat2(i)
+	if err != nil || den == 0 {
+		return 0, false
+	}
+
+	return float64(num) / float64(den), true
+}
+
+func exifFloat(x *exif.Exif, name exif.FieldName) float64 {
+	value, _ := exifRat(x, name, 0)
+	return value
+}
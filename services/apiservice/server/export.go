@@ -0,0 +1,729 @@
+package server
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+
+	albumService "github.com/farerpath/albumservice/proto"
+	authService "github.com/farerpath/authservice/proto"
+	sessionService "github.com/farerpath/sessionservice/proto"
+
+	"github.com/farerpath/randstr"
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/errors"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+)
+
+// Personal data export
+// Export is built in background into ZIP kept in GridFS of DB, so any replica serves it.
+// manifest.json of ZIP has account, identities, sessions, albums and pictures,
+// original files of user's pictures are in folder of their album.
+// Ready export is downloaded with token of link until link expires,
+// export is removed after exportLifetime.
+// Export still running after exportTimeout is failed, its build was stopped or its replica died
+
+const (
+	exportLifetime     = 24 * time.Hour
+	exportLinkLifetime = time.Hour
+	exportCleanPeriod  = 10 * time.Minute
+	exportTimeout      = 30 * time.Minute
+	// exportCollectTimeout is for GRPC calls gathering manifest
+	exportCollectTimeout = 2 * time.Minute
+	// exportFileTimeout is for fetch of one original from file service
+	exportFileTimeout = 5 * time.Minute
+	// exportDBTimeout is for DB calls of requests
+	exportDBTimeout = 10 * time.Second
+)
+
+// Status of export
+const (
+	exportRunning = "running"
+	exportReady   = "ready"
+	exportFailed  = "failed"
+)
+
+// Export is state of data export of user, ZIP is GridFS file of same ID
+type Export struct {
+	ExportID     string    `json:"exportID" bson:"_id"`
+	Status       string    `json:"status" bson:"status"`
+	CreatedTime  time.Time `json:"createdTime" bson:"createdTime"`
+	FinishedTime time.Time `json:"finishedTime" bson:"finishedTime"`
+	// ExpireTime is when export is removed
+	ExpireTime time.Time `json:"expireTime" bson:"expireTime"`
+	Size       int64     `json:"size" bson:"size"`
+	// DownloadURL is set when export is ready, valid until LinkExpireTime
+	DownloadURL    string    `json:"downloadURL,omitempty" bson:"-"`
+	LinkExpireTime time.Time `json:"linkExpireTime" bson:"linkExpireTime"`
+
+	UserID string `json:"-" bson:"userID"`
+	Token  string `json:"-" bson:"token"`
+	// Deadline is when running export is taken as failed
+	Deadline time.Time `json:"-" bson:"deadline"`
+}
+
+// exportDB keeps exports collection and exportFiles bucket
+var exportDB *mongo.Database
+
+var exportHTTPClient = &http.Client{Timeout: exportFileTimeout}
+
+func exportCol() *mongo.Collection {
+	return exportDB.Collection("exports")
+}
+
+// exportBucket is made for each use, as deadlines are set on bucket
+func exportBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(exportDB, options.GridFSBucket().SetName("exportFiles"))
+}
+
+// exportManifest is manifest.json of export
+type exportManifest struct {
+	ExportedTime time.Time        `json:"exportedTime"`
+	Account      *model.Account   `json:"account"`
+	Identities   []model.Identity `json:"identities"`
+	Sessions     []*model.Session `json:"sessions"`
+	Albums       []exportAlbum    `json:"albums"`
+	Pictures     []exportPicture  `json:"pictures"`
+}
+
+type exportAlbum struct {
+	model.Album
+	// Folder has originals of user's pictures in album
+	Folder string `json:"folder"`
+}
+
+type exportPicture struct {
+	model.Picture
+	// File is path of original in ZIP, empty when file service has no file
+	File string `json:"file"`
+}
+
+// InitExports sets DB exports are kept in and creates their indexes,
+// index failures are logged only
+func InitExports(db *mongo.Database) {
+	exportDB = db
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportDBTimeout)
+	defer cancel()
+
+	// One running export of user, even when replicas start exports at once
+	_, err := exportCol().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"userID", 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{{"status", exportRunning}}),
+	})
+	if err != nil {
+		log.Printf("Migration failed: exports running index\n%v", err)
+	}
+
+	for _, key := range []string{"token", "expireTime"} {
+		_, err := exportCol().Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{key, 1}}})
+		if err != nil {
+			log.Printf("Migration failed: exports %v index\n%v", key, err)
+		}
+	}
+
+	go cleanExports()
+}
+
+// cleanExports removes expired exports, and ZIPs left without export
+func cleanExports() {
+	for range time.Tick(exportCleanPeriod) {
+		ctx, cancel := context.WithTimeout(context.Background(), exportCleanPeriod)
+
+		now := time.Now().UTC()
+
+		cur, err := exportCol().Find(ctx, bson.D{{"expireTime", bson.D{{"$lt", now}}}})
+		if err != nil {
+			log.Printf("Error accured: cleanExports(Find)\n%v", err)
+			cancel()
+			continue
+		}
+
+		for cur.Next(ctx) {
+			export := &Export{}
+			if err := cur.Decode(export); err != nil {
+				log.Printf("Error accured: cleanExports(Decode)\n%v", err)
+				continue
+			}
+
+			removeExport(ctx, export.ExportID)
+		}
+		cur.Close(ctx)
+
+		// ZIP of export deleted while it was built
+		if bucket, err := exportBucket(); err == nil {
+			cur, err := bucket.Find(bson.D{{"uploadDate", bson.D{{"$lt", now.Add(-exportLifetime - exportTimeout)}}}})
+			if err == nil {
+				for cur.Next(ctx) {
+					if id, ok := cur.Current.Lookup("_id").StringValueOK(); ok {
+						removeExport(ctx, id)
+					}
+				}
+				cur.Close(ctx)
+			}
+		}
+
+		cancel()
+	}
+}
+
+// removeExport deletes export and its ZIP
+func removeExport(ctx context.Context, exportId string) {
+	if bucket, err := exportBucket(); err == nil {
+		if err := bucket.Delete(exportId); err != nil && err != gridfs.ErrFileNotFound {
+			log.Printf("Error accured: removeExport(DeleteFile)\n%v", err)
+		}
+	}
+
+	if _, err := exportCol().DeleteOne(ctx, bson.D{{"_id", exportId}}); err != nil {
+		log.Printf("Error accured: removeExport(Delete)\n%v", err)
+	}
+}
+
+// StartExport func
+// Starts export of user's data, earlier exports of user are removed.
+// Only one export of user runs at once
+func StartExport(sessionUserId, userId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	if exportDB == nil {
+		returnValue.StatusCode = http.StatusServiceUnavailable
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportDBTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	running := &Export{}
+	err := exportCol().FindOne(ctx, bson.D{
+		{"userID", userId},
+		{"status", exportRunning},
+		{"deadline", bson.D{{"$gt", now}}},
+	}).Decode(running)
+	if err == nil {
+		returnValue.StatusCode = http.StatusConflict
+		returnValue.Value = util.MakeReturnValueToJson(running)
+		return
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Printf("Error accured: StartExport(FindRunning)\n%v", err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	cur, err := exportCol().Find(ctx, bson.D{{"userID", userId}})
+	if err != nil {
+		log.Printf("Error accured: StartExport(FindExports)\n%v", err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+	for cur.Next(ctx) {
+		old := &Export{}
+		if err := cur.Decode(old); err == nil {
+			removeExport(ctx, old.ExportID)
+		}
+	}
+	cur.Close(ctx)
+
+	export := &Export{
+		ExportID:    randstr.GenerateRandomString(16),
+		Status:      exportRunning,
+		CreatedTime: now,
+		ExpireTime:  now.Add(exportLifetime),
+		UserID:      userId,
+		Deadline:    now.Add(exportTimeout),
+	}
+
+	if _, err := exportCol().InsertOne(ctx, export); err != nil {
+		// Export started by other request in the meantime
+		if isDuplicateKey(err) {
+			returnValue.StatusCode = http.StatusConflict
+			return
+		}
+
+		log.Printf("Error accured: StartExport(Insert)\n%v", err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	go buildExport(export)
+
+	returnValue.StatusCode = http.StatusAccepted
+	returnValue.Value = util.MakeReturnValueToJson(export)
+	return
+}
+
+// GetExport func
+// Status of export, download link is given when export is ready
+// and made again after it expires
+func GetExport(sessionUserId, userId, exportId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	if exportDB == nil {
+		returnValue.StatusCode = http.StatusServiceUnavailable
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportDBTimeout)
+	defer cancel()
+
+	export := &Export{}
+	err := exportCol().FindOne(ctx, bson.D{{"_id", exportId}, {"userID", userId}}).Decode(export)
+	if err == mongo.ErrNoDocuments {
+		returnValue.StatusCode = http.StatusNotFound
+		return
+	}
+	if err != nil {
+		log.Printf("Error accured: GetExport(Find)\n%v", err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	now := time.Now()
+
+	if export.Status == exportRunning && now.After(export.Deadline) {
+		export.Status = exportFailed
+		export.FinishedTime = export.Deadline
+		finishExport(ctx, export)
+	}
+
+	if export.Status == exportReady && (len(export.Token) < 1 || now.After(export.LinkExpireTime)) {
+		token, err := exportToken()
+		if err != nil {
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+			return
+		}
+
+		linkExpire := now.Add(exportLinkLifetime).UTC()
+		if linkExpire.After(export.ExpireTime) {
+			linkExpire = export.ExpireTime
+		}
+
+		_, err = exportCol().UpdateOne(ctx, bson.D{{"_id", export.ExportID}}, bson.D{{"$set", bson.D{
+			{"token", token},
+			{"linkExpireTime", linkExpire},
+		}}})
+		if err != nil {
+			log.Printf("Error accured: GetExport(UpdateLink)\n%v", err)
+			returnValue.StatusCode = http.StatusInternalServerError
+			return
+		}
+
+		export.Token = token
+		export.LinkExpireTime = linkExpire
+	}
+
+	if export.Status == exportReady {
+		export.DownloadURL = "/v1/Exports/" + export.Token
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(export)
+	return
+}
+
+// ServeExport writes ZIP of export with token to w
+func ServeExport(w http.ResponseWriter, r *http.Request, token string) {
+	if exportDB == nil || len(token) < 1 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), exportDBTimeout)
+	defer cancel()
+
+	export := &Export{}
+	err := exportCol().FindOne(ctx, bson.D{
+		{"token", token},
+		{"status", exportReady},
+		{"linkExpireTime", bson.D{{"$gt", time.Now().UTC()}}},
+	}).Decode(export)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Error accured: ServeExport(Find)\n%v", err)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	bucket, err := exportBucket()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	file, err := bucket.OpenDownloadStream(export.ExportID)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	name := fmt.Sprintf("farerpath-%v-%v.zip", export.UserID, export.FinishedTime.Format("20060102"))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(export.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Download of export %v failed\n%v", export.ExportID, err)
+	}
+}
+
+func buildExport(export *Export) {
+	ctx, cancel := context.WithDeadline(context.Background(), export.Deadline)
+	defer cancel()
+
+	size, err := writeExport(ctx, export)
+
+	export.FinishedTime = time.Now().UTC()
+
+	if err != nil {
+		log.Printf("Export %v of %v failed\n%v", export.ExportID, export.UserID, err)
+		export.Status = exportFailed
+	} else {
+		export.Status = exportReady
+		export.Size = size
+	}
+
+	// Deadline may be passed, status is saved anyway
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), exportDBTimeout)
+	defer saveCancel()
+
+	finishExport(saveCtx, export)
+}
+
+// finishExport saves status of export which was running,
+// ZIP of failed export is deleted
+func finishExport(ctx context.Context, export *Export) {
+	_, err := exportCol().UpdateOne(ctx, bson.D{{"_id", export.ExportID}, {"status", exportRunning}}, bson.D{{"$set", bson.D{
+		{"status", export.Status},
+		{"finishedTime", export.FinishedTime},
+		{"size", export.Size},
+	}}})
+	if err != nil {
+		log.Printf("Error accured: finishExport(Update)\n%v", err)
+	}
+
+	if export.Status != exportFailed {
+		return
+	}
+
+	if bucket, err := exportBucket(); err == nil {
+		if err := bucket.Delete(export.ExportID); err != nil && err != gridfs.ErrFileNotFound {
+			log.Printf("Error accured: finishExport(DeleteFile)\n%v", err)
+		}
+	}
+}
+
+// writeExport writes ZIP of user's data to GridFS file of export, gives size of ZIP
+func writeExport(ctx context.Context, export *Export) (int64, error) {
+	manifest, originals, err := collectExport(ctx, export.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	bucket, err := exportBucket()
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := bucket.OpenUploadStreamWithID(export.ExportID, export.ExportID+".zip")
+	if err != nil {
+		return 0, err
+	}
+
+	if err := file.SetWriteDeadline(export.Deadline); err != nil {
+		file.Abort()
+		return 0, err
+	}
+
+	counter := &countWriter{w: file}
+
+	if err := writeExportZip(ctx, counter, export.UserID, manifest, originals); err != nil {
+		file.Abort()
+		return 0, err
+	}
+
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+
+	return counter.n, nil
+}
+
+// countWriter counts bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// isDuplicateKey tells whether err is of unique index
+func isDuplicateKey(err error) bool {
+	if e, ok := err.(mongo.WriteException); ok {
+		for _, we := range e.WriteErrors {
+			if we.Code == 11000 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// collectExport gathers data of user from services,
+// originals are indexes of manifest pictures which have file in ZIP
+func collectExport(ctx context.Context, userId string) (*exportManifest, []int, error) {
+	ctx, cancel := context.WithTimeout(ctx, exportCollectTimeout)
+	defer cancel()
+
+	user, err := authClient.GetUser(ctx, &authService.GetUserRequest{UserID: userId})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	identities, err := authClient.GetIdentities(ctx, &authService.GetIdentitiesRequest{UserID: userId})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sessions, err := sessionClient.GetAllSessions(ctx, &sessionService.SessionsRequest{UserID: userId})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	albums, err := albumClient.GetAlbumList(ctx, &albumService.GetAlbumListRequest{ReqUserID: userId, DstUserID: userId})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest := &exportManifest{
+		ExportedTime: time.Now().UTC(),
+		Account:      accountFromPb(user),
+		Identities:   identitiesFromPb(identities),
+		Sessions:     sessionsFromPb(sessions),
+		Albums:       []exportAlbum{},
+		Pictures:     []exportPicture{},
+	}
+
+	// Original is put in folder of first album it is in
+	folders := map[string]string{}
+
+	for _, album := range albums.GetAlbumList() {
+		result := exportAlbum{Album: albumFromNode(album)}
+		result.Folder = "albums/" + exportFileName(album.GetAlbumName(), "album") + "-" + album.GetAlbumID() + "/"
+
+		for _, pictureId := range album.GetPictures() {
+			if _, ok := folders[pictureId]; !ok {
+				folders[pictureId] = result.Folder
+			}
+		}
+
+		manifest.Albums = append(manifest.Albums, result)
+	}
+
+	originals := []int{}
+	names := map[string]bool{}
+
+	for _, archived := range []bool{false, true} {
+		pictures, err := albumClient.GetPictureList(ctx, &albumService.GetPictureListRequest{ReqUserID: userId, DstUserID: userId, Archived: archived})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, picture := range pictures.GetPictureList() {
+			result := exportPicture{Picture: pictureFromPb(picture)}
+
+			if result.Owner == userId {
+				folder, ok := folders[picture.GetPictureID()]
+				if !ok {
+					folder = "unsorted/"
+				}
+
+				name := folder + exportFileName(picture.GetPictureName(), picture.GetPictureID())
+				if names[name] {
+					name = folder + picture.GetPictureID() + "-" + exportFileName(picture.GetPictureName(), "picture")
+				}
+				names[name] = true
+
+				result.File = name
+				originals = append(originals, len(manifest.Pictures))
+			}
+
+			manifest.Pictures = append(manifest.Pictures, result)
+		}
+	}
+
+	return manifest, originals, nil
+}
+
+// writeExportZip writes originals and manifest to w,
+// File of picture is cleared when file service has no original
+func writeExportZip(ctx context.Context, w io.Writer, userId string, manifest *exportManifest, originals []int) error {
+	archive := zip.NewWriter(w)
+
+	for _, i := range originals {
+		picture := &manifest.Pictures[i]
+
+		found, err := writeExportOriginal(ctx, archive, userId, string(picture.PictureID), picture.File, picture.TimeMetadata)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			picture.File = ""
+		}
+	}
+
+	part, err := archive.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: manifest.ExportedTime})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(part)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// writeExportOriginal copies original of picture from file service to archive,
+// false when file service has no file
+func writeExportOriginal(ctx context.Context, archive *zip.Writer, userId, pictureId, name string, modified time.Time) (bool, error) {
+	query := url.Values{}
+	query.Set("userId", userId)
+	query.Set("pictureId", pictureId)
+
+	req, err := http.NewRequest(http.MethodGet, FILE_SERVICE_URL+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := exportHTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		log.Printf("Export of %v: no file of picture %v\n", userId, pictureId)
+		return false, nil
+	}
+
+	if !util.IsSucced(int32(resp.StatusCode)) {
+		return false, fmt.Errorf("file of picture %v: status %d", pictureId, resp.StatusCode)
+	}
+
+	// Pictures are compressed already
+	part, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := io.Copy(part, resp.Body); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func albumFromNode(album *albumService.AlbumNode) model.Album {
+	pictures := []uoid.UOID{}
+	for _, picture := range album.GetPictures() {
+		pictures = append(pictures, uoid.FromString(picture))
+	}
+
+	return model.Album{
+		AlbumID:   uoid.FromString(album.GetAlbumID()),
+		AlbumName: album.GetAlbumName(),
+		Owner:     album.GetOwner(),
+		BeginTime: time.Unix(album.GetBeginTime(), 0).UTC(),
+		EndTime:   time.Unix(album.GetEndTime(), 0).UTC(),
+		TravelPath: model.Path{
+			Country: album.GetTravelPath().GetCountry(),
+			City:    album.GetTravelPath().GetCity(),
+			Location: model.GPSData{
+				Latitude:  album.GetTravelPath().GetLocation().GetLatitude(),
+				Longitude: album.GetTravelPath().GetLocation().GetLongitude(),
+				Altitude:  album.GetTravelPath().GetLocation().GetAltitude(),
+			},
+		},
+		PublishRange: album.GetPublishRange(),
+		Members:      album.GetMembers(),
+		Pictures:     pictures,
+		Archived:     album.GetArchived(),
+	}
+}
+
+// exportFileName makes name safe as file name in ZIP, fallback when nothing is left
+func exportFileName(name, fallback string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	name = strings.Trim(strings.TrimSpace(name), ".")
+
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+
+	if len(name) < 1 {
+		return fallback
+	}
+
+	return name
+}
+
+// exportToken gives random token of download link
+func exportToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
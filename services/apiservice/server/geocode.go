@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jasonwinn/geocoder"
+)
+
+// Country and city of uploaded pictures are found from their GPS location.
+// Local geocoder looks up nearest city of GeoNames dataset with no network,
+// remote geocoder asks MapQuest geocoding API
+
+// Place is where coordinates are
+type Place struct {
+	// Country is ISO 3166-1 alpha-2 code
+	Country string
+	City    string
+}
+
+// ReverseGeocoder finds place of coordinates
+type ReverseGeocoder interface {
+	ReverseGeocode(lat, lng float64) (*Place, error)
+}
+
+// ErrNoPlace is returned when there is no city near coordinates
+var ErrNoPlace = errors.New("no place near coordinates")
+
+// maxPlaceDistance is how far in km nearest city may be,
+// coordinates at sea or in wilderness have no place
+const maxPlaceDistance = 150.0
+
+const earthRadius = 6371.0
+
+var reverseGeocoder ReverseGeocoder
+
+// SetReverseGeocoder sets geocoder used for uploaded pictures
+func SetReverseGeocoder(g ReverseGeocoder) {
+	reverseGeocoder = g
+}
+
+// LocalGeocoder finds nearest city in k-d tree of cities
+type LocalGeocoder struct {
+	root *placeNode
+}
+
+type place struct {
+	Place
+	// point is position on unit sphere
+	point [3]float64
+}
+
+type placeNode struct {
+	place       *place
+	axis        int
+	left, right *placeNode
+}
+
+// NewLocalGeocoder loads cities from GeoNames file at path,
+// bundled cities when path is empty.
+// File is tab separated, either GeoNames cities dump
+// or name, country code, latitude and longitude
+func NewLocalGeocoder(path string) (*LocalGeocoder, error) {
+	var r io.Reader = strings.NewReader(bundledCities)
+
+	if len(path) > 0 {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		r = file
+	}
+
+	places, err := readPlaces(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(places) == 0 {
+		return nil, errors.New("no cities in dataset")
+	}
+
+	return &LocalGeocoder{root: buildPlaceTree(places, 0)}, nil
+}
+
+// ReverseGeocode gives nearest city within maxPlaceDistance
+func (g *LocalGeocoder) ReverseGeocode(lat, lng float64) (*Place, error) {
+	if math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return nil, errors.New("coordinates out of range")
+	}
+
+	nearest, chord := g.root.nearest(unitPoint(lat, lng), nil, math.Inf(1))
+
+	// Squared chord to distance along surface
+	if 2*math.Asin(math.Min(math.Sqrt(chord)/2, 1))*earthRadius > maxPlaceDistance {
+		return nil, ErrNoPlace
+	}
+
+	result := nearest.Place
+	return &result, nil
+}
+
+// readPlaces parses cities, lines not looking like city are skipped
+func readPlaces(r io.Reader) ([]place, error) {
+	places := []place{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+
+		var name, country, lat, lng string
+
+		switch {
+		// GeoNames dump: geonameid, name, asciiname, alternatenames, latitude, longitude,
+		// feature class, feature code, country code, ...
+		case len(fields) >= 9:
+			name, lat, lng, country = fields[1], fields[4], fields[5], fields[8]
+		case len(fields) == 4:
+			name, country, lat, lng = fields[0], fields[1], fields[2], fields[3]
+		default:
+			continue
+		}
+
+		latitude, err := strconv.ParseFloat(lat, 64)
+		if err != nil {
+			continue
+		}
+
+		longitude, err := strconv.ParseFloat(lng, 64)
+		if err != nil {
+			continue
+		}
+
+		places = append(places, place{
+			Place: Place{Country: country, City: name},
+			point: unitPoint(latitude, longitude),
+		})
+	}
+
+	return places, scanner.Err()
+}
+
+func unitPoint(lat, lng float64) [3]float64 {
+	phi := lat * math.Pi / 180
+	lambda := lng * math.Pi / 180
+
+	return [3]float64{
+		math.Cos(phi) * math.Cos(lambda),
+		math.Cos(phi) * math.Sin(lambda),
+		math.Sin(phi),
+	}
+}
+
+func buildPlaceTree(places []place, depth int) *placeNode {
+	if len(places) == 0 {
+		return nil
+	}
+
+	axis := depth % 3
+	sort.Slice(places, func(i, j int) bool {
+		return places[i].point[axis] < places[j].point[axis]
+	})
+
+	mid := len(places) / 2
+
+	return &placeNode{
+		place: &places[mid],
+		axis:  axis,
+		left:  buildPlaceTree(places[:mid], depth+1),
+		right: buildPlaceTree(places[mid+1:], depth+1),
+	}
+}
+
+// nearest gives closest place to point in subtree and its squared chord distance
+func (n *placeNode) nearest(point [3]float64, best *place, bestDistance float64) (*place, float64) {
+	if n == nil {
+		return best, bestDistance
+	}
+
+	distance := 0.0
+	for i := range point {
+		d := point[i] - n.place.point[i]
+		distance += d * d
+	}
+
+	if distance < bestDistance {
+		best, bestDistance = n.place, distance
+	}
+
+	diff := point[n.axis] - n.place.point[n.axis]
+
+	near, far := n.left, n.right
+	if diff > 0 {
+		near, far = far, near
+	}
+
+	best, bestDistance = near.nearest(point, best, bestDistance)
+
+	// Other side may be closer only when splitting plane is
+	if diff*diff < bestDistance {
+		best, bestDistance = far.nearest(point, best, bestDistance)
+	}
+
+	return best, bestDistance
+}
+
+// RemoteGeocoder asks MapQuest geocoding API
+type RemoteGeocoder struct{}
+
+// NewRemoteGeocoder sets API key of geocoding API
+func NewRemoteGeocoder(key string) *RemoteGeocoder {
+	geocoder.SetAPIKey(key)
+	return &RemoteGeocoder{}
+}
+
+// ReverseGeocode asks API for address of coordinates
+func (g *RemoteGeocoder) ReverseGeocode(lat, lng float64) (*Place, error) {
+	addr, err := geocoder.ReverseGeocode(lat, lng)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Place{Country: addr.CountryCode, City: addr.City}, nil
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	albumService "github.com/farerpath/albumservice/proto"
+	authService "github.com/farerpath/authservice/proto"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/errors"
+	"github.com/farerpath/server/model/model"
+)
+
+// StartOIDC func
+// Gives login page of identity provider and state to give back with code.
+// sessionUserId is set when identity is linked to logged in user
+func StartOIDC(provider, sessionUserId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := authClient.StartOIDC(context.Background(), &authService.StartOIDCRequest{Provider: provider, UserID: sessionUserId})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.Unavailable:
+			returnValue.StatusCode = http.StatusBadGateway
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"authorizationURL": resp.GetAuthorizationURL(), "state": resp.GetState()})
+	return
+}
+
+// FinishOIDC func
+// Logs in with code from identity provider, account is made for new identity.
+// Identity is linked instead when login was started by logged in user
+func FinishOIDC(provider, state, code string, loginDeviceType int, loginIP string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := authClient.FinishOIDC(context.Background(), &authService.FinishOIDCRequest{
+		Provider:        provider,
+		State:           state,
+		Code:            code,
+		LoginDeviceType: int32(loginDeviceType),
+		LoginIP:         loginIP,
+	})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.Unauthenticated:
+			returnValue.StatusCode = http.StatusUnauthorized
+		case codes.AlreadyExists:
+			returnValue.StatusCode = http.StatusConflict
+		default:
+			log.Printf("Unable to login. GRPC Service returned error.\n%v", err)
+			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
+		}
+		return
+	}
+
+	if resp.GetLinked() {
+		returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"linked": true})
+		return
+	}
+
+	loginResp := resp.GetLogin()
+
+	if resp.GetCreated() {
+		_, err = albumClient.MakeAlbumList(context.Background(), &albumService.MakeAlbumListRequest{ReqUserID: loginResp.GetUserID()})
+		if err != nil {
+			log.Printf("Unable to Register. Failed to make empty album list GRPC Service returned error.\n%v", err)
+			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
+			return
+		}
+		returnValue.StatusCode = http.StatusCreated
+	}
+
+	if loginResp.GetMfaRequired() {
+		returnValue.Value = util.MakeReturnValueToJson(&model.LoginReturnValue{
+			UserID:      loginResp.GetUserID(),
+			MfaRequired: true,
+			MfaTicket:   loginResp.GetMfaTicket(),
+		})
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(&model.LoginReturnValue{
+		UserName:     loginResp.GetUserName(),
+		UserID:       loginResp.GetUserID(),
+		Country:      loginResp.GetCountry(),
+		RefreshToken: loginResp.GetRefreshToken(),
+		AuthToken:    loginResp.GetAuthToken(),
+	})
+	return
+}
+
+// GetIdentities func
+// Gives identity providers user logs in with
+func GetIdentities(sessionUserId, userId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	resp, err := authClient.GetIdentities(context.Background(), &authService.GetIdentitiesRequest{UserID: userId})
+	if err != nil {
+		st, _ := status.FromError(err)
+		if st.Code() == codes.NotFound {
+			returnValue.StatusCode = http.StatusNotFound
+		} else {
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	identities := identitiesFromPb(resp)
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"identities": identities, "hasPassword": resp.GetHasPassword()})
+	return
+}
+
+func identitiesFromPb(resp *authService.GetIdentitiesReply) []model.Identity {
+	identities := []model.Identity{}
+	for _, identity := range resp.GetIdentity() {
+		identities = append(identities, model.Identity{
+			Provider: identity.GetProvider(),
+			Email:    identity.GetEmail(),
+			LinkedAt: time.Unix(identity.GetLinkedAt(), 0).UTC(),
+		})
+	}
+
+	return identities
+}
+
+// UnlinkIdentity func
+// Removes identity provider from user, last one stays when user has no password
+func UnlinkIdentity(sessionUserId, userId, provider string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	_, err := authClient.UnlinkIdentity(context.Background(), &authService.UnlinkIdentityRequest{UserID: userId, Provider: provider})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.FailedPrecondition:
+			returnValue.StatusCode = http.StatusConflict
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.StatusCode = http.StatusNoContent
+	return
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"golang.org/x/net/context"
+
+	albumService "github.com/farerpath/albumservice/proto"
+	authService "github.com/farerpath/authservice/proto"
+)
+
+// InviteMember func
+// invitee is user ID, or email when it contains "@"
+func InviteMember(sessionUserId, albumId, invitee, role string, expiresIn int64) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	req := &albumService.InviteMemberRequest{ReqUserID: sessionUserId, AlbumID: albumId, ExpiresIn: expiresIn}
+
+	if strings.Contains(invitee, "@") {
+		if !isEmailValid(invitee) {
+			returnValue.StatusCode = http.StatusBadRequest
+			return
+		}
+		req.InviteeEmail = invitee
+	} else {
+		if !isUserIDValid(invitee) {
+			returnValue.StatusCode = http.StatusBadRequest
+			return
+		}
+		req.InviteeID = invitee
+	}
+
+	if len(role) > 0 {
+		r, exists := memberRoles[role]
+		if !exists {
+			returnValue.StatusCode = http.StatusBadRequest
+			return
+		}
+		req.Role = r
+	}
+
+	resp, err := albumClient.InviteMember(context.Background(), req)
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.StatusCode = http.StatusCreated
+	returnValue.Value = util.MakeReturnValueToJson(invitationFromPb(resp.GetInvitation()))
+	return
+}
+
+func GetAlbumInvitations(sessionUserId, albumId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.GetAlbumInvitations(context.Background(), &albumService.GetAlbumInvitationsRequest{ReqUserID: sessionUserId, AlbumID: albumId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(invitationListFromPb(resp.GetInvitations()))
+	return
+}
+
+func RevokeInvitation(sessionUserId, invitationId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	_, err := albumClient.RevokeInvitation(context.Background(), &albumService.RevokeInvitationRequest{ReqUserID: sessionUserId, InvitationID: invitationId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	return
+}
+
+// GetInvitations func
+// Pending invitations sent to user's ID or email
+func GetInvitations(sessionUserId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.GetInvitations(context.Background(), &albumService.GetInvitationsRequest{ReqUserID: sessionUserId, ReqEmail: userEmail(sessionUserId)})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(invitationListFromPb(resp.GetInvitations()))
+	return
+}
+
+func AnswerInvitation(sessionUserId, invitationId string, accept bool) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	_, err := albumClient.AnswerInvitation(context.Background(), &albumService.AnswerInvitationRequest{
+		ReqUserID:    sessionUserId,
+		ReqEmail:     userEmail(sessionUserId),
+		InvitationID: invitationId,
+		Accept:       accept,
+	})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	return
+}
+
+// userEmail returns verified email of user, empty string when not found or not verified.
+// Invitations to email are matched with it, so unverified address must not see them
+func userEmail(userId string) string {
+	resp, err := authClient.GetUser(context.Background(), &authService.GetUserRequest{UserID: userId})
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+
+	if !resp.GetEmailVerified() {
+		return ""
+	}
+
+	return resp.GetEmail()
+}
+
+func invitationListFromPb(invitations []*albumService.Invitation) []model.Invitation {
+	result := []model.Invitation{}
+
+	for _, invitation := range invitations {
+		result = append(result, invitationFromPb(invitation))
+	}
+
+	return result
+}
+
+func invitationFromPb(invitation *albumService.Invitation) model.Invitation {
+	return model.Invitation{
+		InvitationID: uoid.FromString(invitation.GetInvitationID()),
+		AlbumID:      uoid.FromString(invitation.GetAlbumID()),
+		AlbumName:    invitation.GetAlbumName(),
+		Inviter:      invitation.GetInviter(),
+		InviteeID:    invitation.GetInviteeID(),
+		InviteeEmail: invitation.GetInviteeEmail(),
+		Role:         invitation.GetRole(),
+		Status:       invitation.GetStatus(),
+		CreatedTime:  time.Unix(invitation.GetCreatedTime(), 0),
+		ExpireTime:   time.Unix(invitation.GetExpireTime(), 0),
+	}
+}
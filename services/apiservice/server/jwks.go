@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"math/big"
+
+	"golang.org/x/net/context"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/errors"
+	"github.com/farerpath/server/model/model"
+
+	sessionService "github.com/farerpath/sessionservice/proto"
+)
+
+// jwk is RSA public key in JSON Web Key format, RFC 7517
+type jwk struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// GetJWKS func
+// Public keys of session service auth tokens
+func GetJWKS() (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := sessionClient.GetPublicKeys(context.Background(), &sessionService.Empty{})
+	if err != nil {
+		log.Printf("Unable to get public keys. GRPC Service returned error.\n%v", err)
+		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
+		return
+	}
+
+	keys := []jwk{}
+
+	for _, key := range resp.GetKey() {
+		keys = append(keys, jwk{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: "RS256",
+			KeyID:     key.GetKeyID(),
+			Modulus:   base64.RawURLEncoding.EncodeToString(key.GetModulus()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.GetExponent())).Bytes()),
+		})
+	}
+
+	// Key set is read by JWT libraries, not wrapped as other responses
+	returnValue.Value, err = json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		log.Println(err)
+		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
+	}
+
+	return
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"time"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/model"
+
+	"golang.org/x/net/context"
+
+	albumService "github.com/farerpath/albumservice/proto"
+)
+
+func GetNiceShots(sessionUserId, pictureId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.GetNiceShots(context.Background(), &albumService.GetNiceShotsRequest{ReqUserID: sessionUserId, PictureID: pictureId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	users := []map[string]interface{}{}
+
+	for _, niceShot := range resp.GetNiceShots() {
+		users = append(users, map[string]interface{}{"userID": niceShot.GetUserID(), "time": time.Unix(niceShot.GetTime(), 0)})
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"countNiceShots": resp.GetCountNiceShots(), "niceShots": users})
+	return
+}
+
+func AddNiceShot(sessionUserId, pictureId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.AddNiceShot(context.Background(), &albumService.AddNiceShotRequest{ReqUserID: sessionUserId, PictureID: pictureId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"countNiceShots": resp.GetCountNiceShots()})
+	return
+}
+
+func SubNiceShot(sessionUserId, pictureId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.SubNiceShot(context.Background(), &albumService.SubNiceShotRequest{ReqUserID: sessionUserId, PictureID: pictureId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"countNiceShots": resp.GetCountNiceShots()})
+	return
+}
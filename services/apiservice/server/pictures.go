@@ -3,17 +3,21 @@ package server
 import (
 	"bytes"
 	"io"
-	"io/ioutil"
 	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/farerpath/server/common/util"
 	"github.com/farerpath/server/model/consts"
 	"github.com/farerpath/server/model/errors"
 	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
 
 	"github.com/farerpath/randstr"
 	"google.golang.org/grpc/codes"
@@ -21,10 +25,6 @@ import (
 
 	"golang.org/x/net/context"
 
-	"github.com/jasonwinn/geocoder"
-
-	"github.com/rwcarlsen/goexif/exif"
-
 	albumService "github.com/farerpath/albumservice/proto"
 )
 
@@ -33,105 +33,122 @@ const FILE_SERVICE_URL = "http://192.168.1.151/"
 func UploadPicture(userId, pictureName string, publishRange uint, file *multipart.File, fileHeader *multipart.FileHeader) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
-	pictureId := randstr.GenerateRandomString(16)
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", fileHeader.Filename)
-
+	allowed, err := canPublish(userId, uint32(publishRange))
 	if err != nil {
+		log.Println(err)
 		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
 		return
 	}
-
-	_, err = io.Copy(part, *file)
-	if err != nil {
-		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
+	if !allowed {
+		notVerified(returnValue)
 		return
 	}
 
-	_ = writer.WriteField("userId", userId)
-	_ = writer.WriteField("pictureId", pictureId)
+	pictureId := randstr.GenerateRandomString(16)
 
-	err = writer.Close()
+	statusCode, err := uploadPictureFile(userId, pictureId, fileHeader.Filename, *file)
 	if err != nil {
+		log.Println(err)
 		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
 		return
 	}
 
-	req, err := http.NewRequest(http.MethodPost, FILE_SERVICE_URL, body)
-	if err != nil {
-		log.Println(err)
-		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
+	if !util.IsSucced(int32(statusCode)) {
+		returnValue.StatusCode = statusCode
 		return
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	uploadResp, err := http.DefaultClient.Do(req)
-	if err != nil {
+	// File was read to the end by upload
+	if _, err := (*file).Seek(0, io.SeekStart); err != nil {
 		log.Println(err)
+		discardPictureFile(userId, pictureId)
 		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
 		return
 	}
 
-	if !util.IsSucced(int32(uploadResp.StatusCode)) {
-		returnValue.StatusCode = uploadResp.StatusCode
+	if err := makePicture(userId, pictureId, pictureName, uint32(publishRange), *file); err != nil {
+		log.Println(err)
+		discardPictureFile(userId, pictureId)
+		returnValue.StatusCode = http.StatusInternalServerError
 		return
 	}
 
-	geocoder.SetAPIKey(consts.GOOGLE_REVGEOCODING_KEY)
+	return
+}
+
+// makePicture registers uploaded file as picture of user,
+// with capture time, location and camera details read from EXIF of file
+func makePicture(userId, pictureId, pictureName string, publishRange uint32, file io.Reader) error {
+	info := readExif(file)
 
 	path := &albumService.Path{}
 
-	metadata, err := exif.Decode(*file)
-	if err == nil {
-		// Cannot decode file.
-		// But not critical.
-		// PASS_THROUGH
-		lat, lng, err := metadata.LatLong()
-		if err == nil {
-			// Cannot decode file.
-			// But not critical.
-			// PASS_THROUGH
-
-			path.Location = &albumService.GPSData{Latitude: strconv.FormatFloat(lat, 'f', -1, 64), Longitude: strconv.FormatFloat(lng, 'f', -1, 64)}
+	if info.location != nil {
+		path.Location = info.location
+
+		lat, _ := strconv.ParseFloat(info.location.Latitude, 64)
+		lng, _ := strconv.ParseFloat(info.location.Longitude, 64)
+
+		if reverseGeocoder != nil {
+			place, err := reverseGeocoder.ReverseGeocode(lat, lng)
+			if err == nil {
+				path.Country = place.Country
+				path.City = place.City
+			} else if err != ErrNoPlace {
+				// Cannot get location.
+				// But not critical.
+				// PASS_THROUGH
+				log.Println(err)
+			}
 		}
+	}
 
-		addr, err := geocoder.ReverseGeocode(lat, lng)
-		if err == nil {
-			// Cannot get location.
-			// But not critical.
-			// PASS_THROUGH
-
-			path.Country = addr.CountryCode
-			path.City = addr.City
-		}
+	// Pictures without capture time are dated by upload
+	captured := info.captured
+	if captured.IsZero() {
+		captured = time.Now()
 	}
 
 	makePicReq := &albumService.MakePictureRequest{
-		// PictureId:     pictureId,// TODO: fix
+		PictureID:     pictureId,
 		PictureName:   pictureName,
 		Owner:         userId,
+		TimeMetadata:  captured.Unix(),
 		CountNiceShot: 0,
-		PublishRange:  uint32(publishRange),
+		PublishRange:  publishRange,
 		Archived:      false,
 		Path:          path,
+		Metadata:      info.metadata,
 	}
 
-	_, err = albumClient.MakePicture(context.Background(), makePicReq)
-	if err != nil {
-		log.Println(err)
-		returnValue.StatusCode = http.StatusInternalServerError
-		return
-	}
+	_, err := albumClient.MakePicture(context.Background(), makePicReq)
+	return err
+}
 
-	return
+// PictureFile is file of picture to be served by ServePictureFile
+type PictureFile struct {
+	Owner     string
+	PictureID string
+	Size      string
+	// Name is file name for client, with rendition extension
+	Name string
+	// Location is location privacy of owner, empty for owner
+	Location string
 }
 
-func DownloadPicture(userId, albumId, pictureId string, fileName *string) (returnValue *model.ReturnValue) {
+// DownloadPicture func
+// Checks session user can see the picture and fills file to serve.
+// albumId is optional, picture must be in the album when set
+// size is one of pictureSizes
+func DownloadPicture(sessionUserId, albumId, pictureId, size string, file *PictureFile) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
-	resp, err := albumClient.GetPicture(context.Background(), &albumService.GetPictureRequest{ReqUserID: userId, AlbumID: albumId, PictureID: pictureId})
+	if !pictureSizes[size] {
+		returnValue.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	resp, err := albumClient.GetPicture(context.Background(), &albumService.GetPictureRequest{ReqUserID: sessionUserId, AlbumID: albumId, PictureID: pictureId})
 	if err != nil {
 		st, _ := status.FromError(err)
 		if st.Code() == codes.NotFound {
@@ -144,19 +161,131 @@ func DownloadPicture(userId, albumId, pictureId string, fileName *string) (retur
 		return
 	}
 
-	picResp, err := http.Get(FILE_SERVICE_URL + "?userId=" + userId + "&pictureId=" + pictureId)
+	*file = PictureFile{
+		Owner:     resp.GetOwner(),
+		PictureID: resp.GetPictureID(),
+		Size:      size,
+		Name:      pictureFileName(resp.GetPictureName(), size),
+	}
 
-	body, err := ioutil.ReadAll(picResp.Body)
+	// Original of owner is served untouched
+	if resp.GetOwner() != sessionUserId {
+		file.Location = locationPrivacies{}.of(resp.GetOwner())
+	}
+
+	return
+}
+
+// Sizes of picture download, renditions are JPEG
+// Empty means original file
+var pictureSizes = map[string]bool{
+	"":         true,
+	"original": true,
+	"small":    true,
+	"medium":   true,
+	"large":    true,
+}
+
+// Headers passed between client and file service,
+// so Range and conditional requests reach the store
+var (
+	fileRequestHeaders  = []string{"Range", "If-Range", "If-Modified-Since", "If-None-Match", "If-Unmodified-Since", "If-Match"}
+	fileResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag"}
+)
+
+// ServePictureFile streams file from file service to w
+func ServePictureFile(w http.ResponseWriter, r *http.Request, file *PictureFile) {
+	query := url.Values{}
+	query.Set("userId", file.Owner)
+	query.Set("pictureId", file.PictureID)
+	if len(file.Size) > 0 {
+		query.Set("size", file.Size)
+	}
+	if len(file.Location) > 0 {
+		query.Set("location", file.Location)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, FILE_SERVICE_URL+"?"+query.Encode(), nil)
 	if err != nil {
 		log.Println(err)
-		returnValue.StatusCode = http.StatusInternalServerError
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	*fileName = resp.GetPictureName()
+	for _, header := range fileRequestHeaders {
+		if value := r.Header.Get(header); len(value) > 0 {
+			req.Header.Set(header, value)
+		}
+	}
 
-	returnValue.Value = body
-	return
+	resp, err := http.DefaultClient.Do(req.WithContext(r.Context()))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range fileResponseHeaders {
+		if value := resp.Header.Get(header); len(value) > 0 {
+			w.Header().Set(header, value)
+		}
+	}
+
+	if util.IsSucced(int32(resp.StatusCode)) {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": file.Name}))
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Println(err)
+	}
+}
+
+// uploadPictureFile streams file to file service, returns its status code
+func uploadPictureFile(userId, pictureId, fileName string, file io.Reader) (int, error) {
+	bodyReader, bodyWriter := io.Pipe()
+	// Unblocks writer when request fails before body is read
+	defer bodyReader.Close()
+
+	writer := multipart.NewWriter(bodyWriter)
+
+	go func() {
+		// File service needs fields before file part
+		err := writer.WriteField("userId", userId)
+		if err == nil {
+			err = writer.WriteField("pictureId", pictureId)
+		}
+
+		if err == nil {
+			var part io.Writer
+			part, err = writer.CreateFormFile("file", fileName)
+			if err == nil {
+				_, err = io.Copy(part, file)
+			}
+		}
+
+		if err == nil {
+			err = writer.Close()
+		}
+
+		bodyWriter.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, FILE_SERVICE_URL, bodyReader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode, nil
 }
 
 func ArchivePicture(userID, pictureID string) (returnValue *model.ReturnValue) {
@@ -216,33 +345,51 @@ func DeletePicture(userId, pictureId string) (returnValue *model.ReturnValue) {
 		return
 	}
 
+	statusCode, err := deletePictureFile(userId, pictureId)
+	if err != nil {
+		log.Println(err)
+		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
+		return
+	}
+
+	returnValue.StatusCode = statusCode
+	return
+}
+
+// deletePictureFile deletes file of picture with its renditions from file service
+func deletePictureFile(userId, pictureId string) (int, error) {
 	client := &http.Client{}
 	data := url.Values{"userId": {userId}, "pictureId": {pictureId}}
 
 	req, err := http.NewRequest(http.MethodDelete, FILE_SERVICE_URL, bytes.NewBufferString(data.Encode()))
 	if err != nil {
-		log.Println(err)
-		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
-		return
+		return 0, err
 	}
 
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
 	fileResp, err := client.Do(req)
 	if err != nil {
-		log.Println(err)
-		returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
-		return
+		return 0, err
 	}
+	defer fileResp.Body.Close()
 
-	returnValue.StatusCode = fileResp.StatusCode
-	return
+	return fileResp.StatusCode, nil
 }
 
-func GetPictureList(userId string) (returnValue *model.ReturnValue) {
+// discardPictureFile deletes file whose picture could not be made, so it is not left orphaned
+func discardPictureFile(userId, pictureId string) {
+	if _, err := deletePictureFile(userId, pictureId); err != nil {
+		log.Printf("Unable to delete file of picture %v\n%v", pictureId, err)
+	}
+}
+
+// GetPictureList func
+// Pictures of userId visible to session user
+func GetPictureList(sessionUserId, userId string) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
-	resp, err := albumClient.GetPictureList(context.Background(), &albumService.GetPictureListRequest{ReqUserID: userId, DstUserID: userId, Archived: false})
+	resp, err := albumClient.GetPictureList(context.Background(), &albumService.GetPictureListRequest{ReqUserID: sessionUserId, DstUserID: userId, Archived: false})
 	if err != nil {
 		st, _ := status.FromError(err)
 		if st.Code() == codes.NotFound {
@@ -256,47 +403,53 @@ func GetPictureList(userId string) (returnValue *model.ReturnValue) {
 	}
 
 	picList := []model.Picture{}
+	privacies := locationPrivacies{}
 
 	for _, picture := range resp.GetPictureList() {
-		path := model.Path{
-			City:    picture.GetPath().GetCity(),
-			Country: picture.GetPath().GetCountry(),
-			Location: model.GPSData{
-				Latitude:  picture.GetPath().GetLocation().GetLatitude(),
-				Longitude: picture.GetPath().GetLocation().GetLongitude(),
-				Altitude:  picture.GetPath().GetLocation().GetAltitude(),
-			},
+		result := pictureFromPb(picture)
+		if result.Owner != sessionUserId {
+			hidePictureLocation(&result, privacies.of(result.Owner))
 		}
+		picList = append(picList, result)
+	}
 
-		comments := []model.Comment{}
+	returnValue.Value = util.MakeReturnValueToJson(picList)
 
-		for _, comment := range picture.Comments {
-			comments = append(comments, model.Comment{
-				Owner:     comment.GetOwner(),
-				UserName:  comment.GetUserName(),
-				Value:     comment.GetValue(),
-				Time:      comment.GetTime(),
-				//CommentID: comment.GetCommentId(), //TODO:fix
-			})
-		}
+	return
+}
 
-		// TODO:fix
-		tmp := model.Picture{
-			PictureName:   picture.GetPictureName(),
-			//PictureID:     picture.GetPictureId(),
-			Owner:         picture.GetOwner(),
-			//TimeMeatadata: picture.GetTimeMetadata(),
-			//PublishRange:  uint(picture.GetPublishRange()),
-			//CountNiceShot: uint(picture.GetCountNiceShot()),
-			Archived:      picture.GetArchived(),
-			Path:          path,
-			Comments:      comments,
+// GetPictureMetadata func
+// Capture details of picture visible to session user.
+// userId must be owner of the picture
+func GetPictureMetadata(sessionUserId, userId, pictureId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.GetPicture(context.Background(), &albumService.GetPictureRequest{ReqUserID: sessionUserId, PictureID: pictureId})
+	if err != nil {
+		st, _ := status.FromError(err)
+		if st.Code() == codes.NotFound {
+			returnValue.StatusCode = http.StatusNotFound
+			return
 		}
 
-		picList = append(picList, tmp)
+		log.Println(err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
 	}
 
-	returnValue.Value = util.MakeReturnValueToJson(picList)
+	if resp.GetOwner() != userId {
+		returnValue.StatusCode = http.StatusNotFound
+		return
+	}
+
+	metadata := metadataFromPb(resp.GetMetadata())
+	if userId != sessionUserId {
+		if privacy := (locationPrivacies{}).of(userId); privacy != consts.LOCATION_EXACT {
+			metadata.Altitude = ""
+		}
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(metadata)
 
 	return
 }
@@ -319,46 +472,69 @@ func GetArchivedPictureList(userID string) (returnValue *model.ReturnValue) {
 	picList := []model.Picture{}
 
 	for _, picture := range resp.GetPictureList() {
-		path := model.Path{
-			City:    picture.GetPath().GetCity(),
-			Country: picture.GetPath().GetCountry(),
-			Location: model.GPSData{
-				Latitude:  picture.GetPath().GetLocation().GetLatitude(),
-				Longitude: picture.GetPath().GetLocation().GetLongitude(),
-				Altitude:  picture.GetPath().GetLocation().GetAltitude(),
-			},
-		}
+		picList = append(picList, pictureFromPb(picture))
+	}
 
-		comments := []model.Comment{}
-
-		// TODO:fix
-		for _, comment := range picture.Comments {
-			comments = append(comments, model.Comment{
-				Owner:     comment.GetOwner(),
-				UserName:  comment.GetUserName(),
-				Value:     comment.GetValue(),
-				Time:      comment.GetTime(),
-				//CommentID: comment.GetCommentId(),
-			})
-		}
+	returnValue.Value = util.MakeReturnValueToJson(picList)
 
-		// TODO: fix
-		tmp := model.Picture{
-			PictureName:   picture.GetPictureName(),
-			//PictureID:     picture.GetPictureId(),
-			Owner:         picture.GetOwner(),
-			//TimeMeatadata: picture.GetTimeMetadata(),
-			//PublishRange:  uint(picture.GetPublishRange()),
-			//CountNiceShot: uint(picture.GetCountNiceShot()),
-			Archived:      picture.GetArchived(),
-			Path:          path,
-			Comments:      comments,
-		}
+	return
+}
 
-		picList = append(picList, tmp)
+func pictureFromPb(picture *albumService.Picture) model.Picture {
+	path := model.Path{
+		City:    picture.GetPath().GetCity(),
+		Country: picture.GetPath().GetCountry(),
+		Location: model.GPSData{
+			Latitude:  picture.GetPath().GetLocation().GetLatitude(),
+			Longitude: picture.GetPath().GetLocation().GetLongitude(),
+			Altitude:  picture.GetPath().GetLocation().GetAltitude(),
+		},
 	}
 
-	returnValue.Value = util.MakeReturnValueToJson(picList)
+	comments := []model.Comment{}
 
-	return
+	for _, comment := range picture.GetComments() {
+		comments = append(comments, commentFromPb(comment))
+	}
+
+	return model.Picture{
+		PictureName:   picture.GetPictureName(),
+		PictureID:     uoid.FromString(picture.GetPictureID()),
+		Owner:         picture.GetOwner(),
+		TimeMetadata:  time.Unix(picture.GetTimeMetadata(), 0),
+		PublishRange:  picture.GetPublishRange(),
+		CountNiceShot: picture.GetCountNiceShot(),
+		Archived:      picture.GetArchived(),
+		Path:          path,
+		Comments:      comments,
+		Metadata:      metadataFromPb(picture.GetMetadata()),
+	}
+}
+
+func metadataFromPb(metadata *albumService.PictureMetadata) model.PictureMetadata {
+	return model.PictureMetadata{
+		CaptureTime:           metadata.GetCaptureTime(),
+		Make:                  metadata.GetMake(),
+		Model:                 metadata.GetModel(),
+		LensMake:              metadata.GetLensMake(),
+		LensModel:             metadata.GetLensModel(),
+		FocalLength:           metadata.GetFocalLength(),
+		EquivalentFocalLength: metadata.GetEquivalentFocalLength(),
+		Aperture:              metadata.GetAperture(),
+		ExposureTime:          metadata.GetExposureTime(),
+		ISO:                   metadata.GetIsoSpeed(),
+		Orientation:           metadata.GetOrientation(),
+		Altitude:              metadata.GetAltitude(),
+		Width:                 metadata.GetWidth(),
+		Height:                metadata.GetHeight(),
+	}
+}
+
+// pictureFileName gives rendition JPEG extension so content type matches
+func pictureFileName(pictureName, size string) string {
+	if len(size) < 1 || size == "original" {
+		return pictureName
+	}
+
+	return strings.TrimSuffix(pictureName, filepath.Ext(pictureName)) + ".jpg"
 }
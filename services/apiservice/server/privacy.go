@@ -0,0 +1,67 @@
+package server
+
+import (
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/farerpath/server/model/consts"
+	"github.com/farerpath/server/model/model"
+
+	"golang.org/x/net/context"
+
+	authService "github.com/farerpath/authservice/proto"
+)
+
+// Location of pictures shown to viewers other than owner
+// follows owner's location privacy setting
+
+// locationPrivacies caches settings of owners during one request
+type locationPrivacies map[string]string
+
+// of gives location privacy of owner,
+// no location when setting cannot be read
+func (p locationPrivacies) of(owner string) string {
+	if privacy, exists := p[owner]; exists {
+		return privacy
+	}
+
+	privacy := consts.LOCATION_NONE
+
+	resp, err := authClient.GetUser(context.Background(), &authService.GetUserRequest{UserID: owner})
+	if err != nil {
+		log.Println(err)
+	} else {
+		privacy = resp.GetLocationPrivacy()
+	}
+
+	p[owner] = privacy
+	return privacy
+}
+
+// hidePictureLocation reduces location of picture to privacy of owner
+func hidePictureLocation(picture *model.Picture, privacy string) {
+	switch privacy {
+	case consts.LOCATION_EXACT:
+		return
+	case consts.LOCATION_CITY:
+		picture.Path.Location = model.GPSData{
+			Latitude:  roundCoordinate(picture.Path.Location.Latitude),
+			Longitude: roundCoordinate(picture.Path.Location.Longitude),
+		}
+	default:
+		picture.Path = model.Path{}
+	}
+
+	picture.Metadata.Altitude = ""
+}
+
+// roundCoordinate rounds degrees to 0.1, about 11km, as file service does
+func roundCoordinate(degrees string) string {
+	value, err := strconv.ParseFloat(degrees, 64)
+	if err != nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64)
+}
@@ -1,7 +1,12 @@
 package server
 
 import (
+	"log"
+	"net/http"
+
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	albumService "github.com/farerpath/albumservice/proto"
 	authService "github.com/farerpath/authservice/proto"
@@ -17,3 +22,27 @@ func InitGrpcConn(conn1, conn2, conn3 *grpc.ClientConn) {
 	albumClient = albumService.NewAlbumClient(conn2)
 	sessionClient = sessionService.NewSessionClient(conn3)
 }
+
+// grpcErrorToStatus gives HTTP status of error returned by GRPC service,
+// unexpected errors are logged
+func grpcErrorToStatus(err error) int {
+	st, _ := status.FromError(err)
+
+	switch st.Code() {
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusGone
+	}
+
+	log.Println(err)
+	return http.StatusInternalServerError
+}
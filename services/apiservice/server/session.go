@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/model"
+
+	sessionService "github.com/farerpath/sessionservice/proto"
+)
+
+// GetSessions func
+// Sessions of user, session of token is marked current
+func GetSessions(userId, token string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := sessionClient.GetAllSessions(context.Background(), &sessionService.SessionsRequest{UserID: userId, AuthToken: token})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	sessions := sessionsFromPb(resp)
+
+	returnValue.Value = util.MakeReturnValueToJson(sessions)
+	return
+}
+
+func sessionsFromPb(resp *sessionService.UserSessions) []*model.Session {
+	sessions := []*model.Session{}
+
+	for _, session := range resp.GetSession() {
+		sessions = append(sessions, &model.Session{
+			SessionID:   session.GetSessionID(),
+			LoginTime:   time.Unix(session.GetLoginTime(), 0).UTC(),
+			LoginIP:     session.GetLoginIP(),
+			LoginRegion: session.GetLoginRegion(),
+			DeviceType:  session.GetDeviceType(),
+			Current:     session.GetCurrent(),
+		})
+	}
+
+	return sessions
+}
+
+// RevokeSession func
+// Logs session of user out on its device
+func RevokeSession(userId, sessionId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	_, err := sessionClient.RevokeSession(context.Background(), &sessionService.RevokeSessionRequest{UserID: userId, SessionID: sessionId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.StatusCode = http.StatusNoContent
+	return
+}
+
+// RevokeOtherSessions func
+// Logs user out on every device but one of token
+func RevokeOtherSessions(userId, token string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	_, err := sessionClient.RevokeOtherSessions(context.Background(), &sessionService.SessionsRequest{UserID: userId, AuthToken: token})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	returnValue.StatusCode = http.StatusNoContent
+	return
+}
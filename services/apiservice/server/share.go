@@ -0,0 +1,200 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/model"
+	"github.com/farerpath/server/model/uoid"
+
+	"golang.org/x/net/context"
+
+	albumService "github.com/farerpath/albumservice/proto"
+)
+
+// MakeShareLink func
+// Exactly one of albumId and pictureId is set
+// expiresIn is seconds, 0 never expires. maxViews 0 is unlimited
+func MakeShareLink(sessionUserId, albumId, pictureId, password string, expiresIn int64, maxViews uint32) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if (len(albumId) == 0) == (len(pictureId) == 0) {
+		returnValue.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	// Share link shows album or picture to anyone, same as public
+	verified, err := isEmailVerified(sessionUserId)
+	if err != nil {
+		log.Println(err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+	if !verified {
+		notVerified(returnValue)
+		return
+	}
+
+	resp, err := albumClient.MakeShareLink(context.Background(), &albumService.MakeShareLinkRequest{
+		ReqUserID: sessionUserId,
+		AlbumID:   albumId,
+		PictureID: pictureId,
+		Password:  password,
+		ExpiresIn: expiresIn,
+		MaxViews:  maxViews,
+	})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	result := make(map[string]interface{})
+	result["token"] = resp.GetToken()
+	result["shareLink"] = shareLinkFromPb(resp.GetShareLink())
+
+	returnValue.StatusCode = http.StatusCreated
+	returnValue.Value = util.MakeReturnValueToJson(result)
+	return
+}
+
+func GetShareLinks(sessionUserId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.GetShareLinks(context.Background(), &albumService.GetShareLinksRequest{ReqUserID: sessionUserId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	shareLinks := []model.ShareLink{}
+
+	for _, shareLink := range resp.GetShareLinks() {
+		shareLinks = append(shareLinks, shareLinkFromPb(shareLink))
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(shareLinks)
+	return
+}
+
+func RevokeShareLink(sessionUserId, shareId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	_, err := albumClient.RevokeShareLink(context.Background(), &albumService.RevokeShareLinkRequest{ReqUserID: sessionUserId, ShareID: shareId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	return
+}
+
+// ResolveShareLink func
+// Anonymous access, opening the link counts as one view
+func ResolveShareLink(token, password string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := albumClient.ResolveShareLink(context.Background(), &albumService.ResolveShareLinkRequest{Token: token, Password: password, CountView: true})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	pictures := []model.Picture{}
+	privacies := locationPrivacies{}
+
+	for _, picture := range resp.GetPictures() {
+		result := pictureFromPb(picture)
+		hidePictureLocation(&result, privacies.of(result.Owner))
+		pictures = append(pictures, result)
+	}
+
+	result := make(map[string]interface{})
+	result["shareLink"] = shareLinkFromPb(resp.GetShareLink())
+	result["pictures"] = pictures
+
+	if resp.GetAlbum() != nil {
+		result["album"] = albumNodeFromPb(resp.GetAlbum())
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(result)
+	return
+}
+
+// DownloadSharedPicture func
+// Anonymous download of picture covered by share link,
+// fills file to serve like DownloadPicture
+func DownloadSharedPicture(token, password, pictureId, size string, file *PictureFile) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if !pictureSizes[size] {
+		returnValue.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	resp, err := albumClient.ResolveShareLink(context.Background(), &albumService.ResolveShareLinkRequest{Token: token, Password: password, PictureID: pictureId})
+	if err != nil {
+		returnValue.StatusCode = grpcErrorToStatus(err)
+		return
+	}
+
+	if len(resp.GetPictures()) == 0 {
+		returnValue.StatusCode = http.StatusNotFound
+		return
+	}
+
+	picture := resp.GetPictures()[0]
+
+	*file = PictureFile{
+		Owner:     picture.GetOwner(),
+		PictureID: picture.GetPictureID(),
+		Size:      size,
+		Name:      pictureFileName(picture.GetPictureName(), size),
+		Location:  locationPrivacies{}.of(picture.GetOwner()),
+	}
+
+	return
+}
+
+func shareLinkFromPb(shareLink *albumService.ShareLink) model.ShareLink {
+	return model.ShareLink{
+		ShareID:     uoid.FromString(shareLink.GetShareID()),
+		Owner:       shareLink.GetOwner(),
+		AlbumID:     uoid.FromString(shareLink.GetAlbumID()),
+		PictureID:   uoid.FromString(shareLink.GetPictureID()),
+		ExpireTime:  time.Unix(shareLink.GetExpireTime(), 0),
+		MaxViews:    shareLink.GetMaxViews(),
+		Views:       shareLink.GetViews(),
+		Revoked:     shareLink.GetRevoked(),
+		CreatedTime: time.Unix(shareLink.GetCreatedTime(), 0),
+	}
+}
+
+func albumNodeFromPb(album *albumService.AlbumNode) model.Album {
+	pictures := []uoid.UOID{}
+
+	for _, picture := range album.GetPictures() {
+		pictures = append(pictures, uoid.FromString(picture))
+	}
+
+	return model.Album{
+		AlbumID:   uoid.FromString(album.GetAlbumID()),
+		AlbumName: album.GetAlbumName(),
+		Owner:     album.GetOwner(),
+		BeginTime: time.Unix(album.GetBeginTime(), 0),
+		EndTime:   time.Unix(album.GetEndTime(), 0),
+		TravelPath: model.Path{
+			Country: album.GetTravelPath().GetCountry(),
+			City:    album.GetTravelPath().GetCity(),
+			Location: model.GPSData{
+				Latitude:  album.GetTravelPath().GetLocation().GetLatitude(),
+				Longitude: album.GetTravelPath().GetLocation().GetLongitude(),
+				Altitude:  album.GetTravelPath().GetLocation().GetAltitude(),
+			},
+		},
+		PublishRange: album.GetPublishRange(),
+		Pictures:     pictures,
+		Archived:     album.GetArchived(),
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	authService "github.com/farerpath/authservice/proto"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/errors"
+	"github.com/farerpath/server/model/model"
+)
+
+// LoginTOTP func
+// Second step of login for users with TOTP, mfaTicket is given by Login.
+// retryAfter is set to seconds to wait when too many logins failed
+func LoginTOTP(mfaTicket, code string, retryAfter *string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	var trailer metadata.MD
+
+	loginResp, err := authClient.LoginTOTP(context.Background(), &authService.LoginTOTPRequest{MfaTicket: mfaTicket, Code: code}, grpc.Trailer(&trailer))
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.ResourceExhausted:
+			returnValue.StatusCode = http.StatusTooManyRequests
+			*retryAfter = retryAfterOf(trailer)
+		case codes.NotFound, codes.FailedPrecondition:
+			// Ticket expired, used up or TOTP turned off, login again
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.Unauthenticated:
+			returnValue.StatusCode = http.StatusUnauthorized
+		default:
+			log.Printf("Unable to login. GRPC Service returned error.\n%v", err)
+			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
+		}
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(&model.LoginReturnValue{
+		UserName:     loginResp.GetUserName(),
+		UserID:       loginResp.GetUserID(),
+		Country:      loginResp.GetCountry(),
+		RefreshToken: loginResp.GetRefreshToken(),
+		AuthToken:    loginResp.GetAuthToken(),
+	})
+	return
+}
+
+// EnrollTOTP func
+// Gives secret and otpauth URI for authenticator app,
+// TOTP is enabled when ConfirmTOTP is given first code
+func EnrollTOTP(sessionUserId, userId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	resp, err := authClient.EnrollTOTP(context.Background(), &authService.EnrollTOTPRequest{UserID: userId})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.AlreadyExists:
+			returnValue.StatusCode = http.StatusConflict
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"secret": resp.GetSecret(), "otpauthURI": resp.GetOtpauthURI()})
+	return
+}
+
+// ConfirmTOTP func
+// Enables TOTP with first code, recovery codes are shown only in this reply
+func ConfirmTOTP(sessionUserId, userId, code string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	resp, err := authClient.ConfirmTOTP(context.Background(), &authService.ConfirmTOTPRequest{UserID: userId, Code: code})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.FailedPrecondition:
+			returnValue.StatusCode = http.StatusConflict
+		case codes.Unauthenticated:
+			returnValue.StatusCode = errors.STATUS_FARERPATH_ERROR
+			returnValue.Value = []byte(errors.FP_VALUE_INVALID)
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"recoveryCodes": resp.GetRecoveryCodes()})
+	return
+}
+
+// DisableTOTP func
+// Turns TOTP off, password and TOTP or recovery code are needed
+func DisableTOTP(sessionUserId, userId, password, code string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	_, err := authClient.DisableTOTP(context.Background(), &authService.DisableTOTPRequest{UserID: userId, Password: password, Code: code})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.FailedPrecondition:
+			returnValue.StatusCode = http.StatusConflict
+		case codes.Unauthenticated:
+			returnValue.StatusCode = errors.STATUS_FARERPATH_ERROR
+			returnValue.Value = []byte(errors.FP_PASSWORD_NOT_MATCH)
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.StatusCode = http.StatusNoContent
+	return
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/farerpath/randstr"
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/model"
+)
+
+// Resumable picture upload, tus 1.0
+// Upload state and chunks are kept by file service under user's key
+
+// MaxUploadLength is largest resumable upload in bytes
+const MaxUploadLength = 4 << 30
+
+// Upload is state of resumable upload
+type Upload struct {
+	UploadID string
+	Length   int64
+	Offset   int64
+	// PictureID is set when upload is completed
+	PictureID string
+}
+
+// uploadState is upload state returned by file service
+type uploadState struct {
+	Length   int64  `json:"length"`
+	Offset   int64  `json:"offset"`
+	Metadata string `json:"metadata"`
+}
+
+type uploadMetadata struct {
+	pictureName  string
+	publishRange uint32
+}
+
+// CreateUpload func
+// metadata is tus Upload-Metadata with filename, pictureName and publishRange
+func CreateUpload(sessionUserId string, length int64, metadata string, upload *Upload) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if length < 1 {
+		returnValue.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	if length > MaxUploadLength {
+		returnValue.StatusCode = http.StatusRequestEntityTooLarge
+		return
+	}
+
+	meta, err := parseUploadMetadata(metadata)
+	if err != nil {
+		returnValue.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	allowed, err := canPublish(sessionUserId, meta.publishRange)
+	if err != nil {
+		log.Println(err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+	if !allowed {
+		notVerified(returnValue)
+		return
+	}
+
+	uploadId := randstr.GenerateRandomString(16)
+
+	query := uploadQuery(sessionUserId, uploadId)
+	query.Set("length", strconv.FormatInt(length, 10))
+	query.Set("metadata", metadata)
+
+	_, statusCode, err := uploadRequest(http.MethodPost, "uploads", query, nil)
+	if err != nil {
+		log.Println(err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	if !util.IsSucced(int32(statusCode)) {
+		returnValue.StatusCode = statusCode
+		return
+	}
+
+	*upload = Upload{UploadID: uploadId, Length: length}
+
+	returnValue.StatusCode = http.StatusCreated
+	return
+}
+
+func GetUpload(sessionUserId, uploadId string, upload *Upload) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	state, statusCode, err := uploadRequest(http.MethodGet, "uploads", uploadQuery(sessionUserId, uploadId), nil)
+	if err != nil {
+		log.Println(err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	if !util.IsSucced(int32(statusCode)) {
+		returnValue.StatusCode = statusCode
+		return
+	}
+
+	*upload = Upload{UploadID: uploadId, Length: state.Length, Offset: state.Offset}
+	return
+}
+
+// WriteUpload func
+// Appends body at offset, picture is made when last byte is written
+func WriteUpload(sessionUserId, uploadId string, offset int64, body io.Reader, upload *Upload) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	query := uploadQuery(sessionUserId, uploadId)
+	query.Set("offset", strconv.FormatInt(offset, 10))
+
+	state, statusCode, err := uploadRequest(http.MethodPatch, "uploads", query, body)
+	if err != nil {
+		log.Println(err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	if !util.IsSucced(int32(statusCode)) {
+		returnValue.StatusCode = statusCode
+		return
+	}
+
+	*upload = Upload{UploadID: uploadId, Length: state.Length, Offset: state.Offset}
+
+	if state.Offset == state.Length {
+		pictureId, err := finishUpload(sessionUserId, uploadId, state.Metadata)
+		if err != nil {
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+			return
+		}
+		upload.PictureID = pictureId
+	}
+
+	returnValue.StatusCode = http.StatusNoContent
+	return
+}
+
+func DeleteUpload(sessionUserId, uploadId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	_, statusCode, err := uploadRequest(http.MethodDelete, "uploads", uploadQuery(sessionUserId, uploadId), nil)
+	if err != nil {
+		log.Println(err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	if !util.IsSucced(int32(statusCode)) {
+		returnValue.StatusCode = statusCode
+		return
+	}
+
+	returnValue.StatusCode = http.StatusNoContent
+	return
+}
+
+// finishUpload joins chunks into picture file and makes picture like UploadPicture
+func finishUpload(userId, uploadId, metadata string) (string, error) {
+	meta, err := parseUploadMetadata(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	pictureId := randstr.GenerateRandomString(16)
+
+	query := uploadQuery(userId, uploadId)
+	query.Set("pictureId", pictureId)
+
+	_, statusCode, err := uploadRequest(http.MethodPost, "uploads/complete", query, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if !util.IsSucced(int32(statusCode)) {
+		return "", fmt.Errorf("complete upload %v: status %d", uploadId, statusCode)
+	}
+
+	// EXIF is read from the joined file
+	fileQuery := url.Values{}
+	fileQuery.Set("userId", userId)
+	fileQuery.Set("pictureId", pictureId)
+
+	// Chunks are gone after completion, so upload cannot be retried.
+	// File is deleted when picture is not made, instead of being orphaned
+	resp, err := http.Get(FILE_SERVICE_URL + "?" + fileQuery.Encode())
+	if err != nil {
+		discardPictureFile(userId, pictureId)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if !util.IsSucced(int32(resp.StatusCode)) {
+		discardPictureFile(userId, pictureId)
+		return "", fmt.Errorf("read completed upload %v: status %d", uploadId, resp.StatusCode)
+	}
+
+	if err := makePicture(userId, pictureId, meta.pictureName, meta.publishRange, resp.Body); err != nil {
+		discardPictureFile(userId, pictureId)
+		return "", err
+	}
+
+	return pictureId, nil
+}
+
+func uploadQuery(userId, uploadId string) url.Values {
+	query := url.Values{}
+	query.Set("userId", userId)
+	query.Set("uploadId", uploadId)
+
+	return query
+}
+
+// uploadRequest calls upload API of file service,
+// state is decoded from successful responses with body
+func uploadRequest(method, path string, query url.Values, body io.Reader) (*uploadState, int, error) {
+	req, err := http.NewRequest(method, FILE_SERVICE_URL+path+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	state := &uploadState{}
+
+	if util.IsSucced(int32(resp.StatusCode)) && resp.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(resp.Body).Decode(state); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return state, resp.StatusCode, nil
+}
+
+// parseUploadMetadata parses tus Upload-Metadata,
+// comma separated "key base64(value)" pairs
+func parseUploadMetadata(header string) (*uploadMetadata, error) {
+	values := make(map[string]string)
+
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) < 1 {
+			continue
+		}
+
+		fields := strings.SplitN(pair, " ", 2)
+		value := ""
+
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			value = string(decoded)
+		}
+
+		values[fields[0]] = value
+	}
+
+	meta := &uploadMetadata{pictureName: values["pictureName"]}
+	if len(meta.pictureName) < 1 {
+		meta.pictureName = values["filename"]
+	}
+
+	if value, exists := values["publishRange"]; exists {
+		publishRange, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		meta.publishRange = uint32(publishRange)
+	}
+
+	return meta, nil
+}
@@ -3,8 +3,12 @@ package server
 import (
 	"log"
 	"net/http"
+	"path"
 	"regexp"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/farerpath/server/common/util"
 	"github.com/farerpath/server/model/errors"
@@ -31,23 +35,9 @@ func GetUserInformation(sessionUserId, userId string) (returnValue *model.Return
 		log.Println(err)
 		returnValue.StatusCode = http.StatusInternalServerError
 		return
-
-		return
 	}
 
-	result := &model.Account{
-		Email:            resp.GetEmail(),
-		UserID:           resp.GetUserID(),
-		UserName:         resp.GetUserName(),
-		FirstName:        resp.GetFirstName(),
-		LastName:         resp.GetLastName(),
-		Country:          resp.GetCountry(),
-		ProfilePhotoPath: resp.GetProfilePhotoPath(),
-		SessionDuration:  resp.GetSessionDuration(),
-		IsBirthdayPublic: resp.GetIsBirthdayPublic(),
-		IsCountryPublic:  resp.GetIsCountryPublic(),
-		IsProfilePublic:  resp.GetIsProfilePublic(),
-	}
+	result := accountFromPb(resp)
 
 	if sessionUserId != userId {
 		if !resp.GetIsProfilePublic() {
@@ -56,6 +46,9 @@ func GetUserInformation(sessionUserId, userId string) (returnValue *model.Return
 		}
 
 		result.SessionDuration = 0
+		result.LocationPrivacy = ""
+		result.EmailVerified = false
+		result.DeleteAt = time.Time{}
 
 		if !resp.GetIsBirthdayPublic() {
 			result.Birthday = time.Time{}
@@ -73,7 +66,35 @@ func GetUserInformation(sessionUserId, userId string) (returnValue *model.Return
 	return
 }
 
-func UpdateUserPassword(sessionUserId, userId string, oldPassword, newPassword string) (returnValue *model.ReturnValue) {
+// accountFromPb gives account of user as user sees it
+func accountFromPb(resp *authService.GetUserReply) *model.Account {
+	account := &model.Account{
+		Email:            resp.GetEmail(),
+		UserID:           resp.GetUserID(),
+		UserName:         resp.GetUserName(),
+		FirstName:        resp.GetFirstName(),
+		LastName:         resp.GetLastName(),
+		Country:          resp.GetCountry(),
+		Birthday:         time.Unix(resp.GetBirthday(), 0).UTC(),
+		ProfilePhotoPath: resp.GetProfilePhotoPath(),
+		SessionDuration:  resp.GetSessionDuration(),
+		IsBirthdayPublic: resp.GetIsBirthdayPublic(),
+		IsCountryPublic:  resp.GetIsCountryPublic(),
+		IsProfilePublic:  resp.GetIsProfilePublic(),
+		LocationPrivacy:  resp.GetLocationPrivacy(),
+		EmailVerified:    resp.GetEmailVerified(),
+	}
+
+	if resp.GetDeleteAt() > 0 {
+		account.DeleteAt = time.Unix(resp.GetDeleteAt(), 0).UTC()
+	}
+
+	return account
+}
+
+// UpdateUserPassword func
+// Other sessions of user than one of token are logged out
+func UpdateUserPassword(sessionUserId, userId, oldPassword, newPassword, token string) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
 	if sessionUserId != userId {
@@ -86,60 +107,45 @@ func UpdateUserPassword(sessionUserId, userId string, oldPassword, newPassword s
 		return
 	}
 
-	/*
-		resp, err := dbClient.FindUser(context.Background(), &dbService.UserId{UserId: userId})
-		if err != nil {
-			fmt.Println(err)
-			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
-			return
-		}
+	req := &authService.ChangePasswordRequest{UserID: userId, OldPassword: oldPassword, NewPassword: newPassword, AuthToken: token}
 
-		if !util.IsSucced(resp.StatusCode) {
-			returnValue.StatusCode = int(resp.GetStatusCode())
-			return
-		}
-
-		user := &dbService.User{}
-		err = ptypes.UnmarshalAny(resp.Value, user)
-		if err != nil {
-			fmt.Println(err)
-			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
-			return
-		}
-
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(oldPassword))
-		if err != nil {
-			fmt.Println(err)
+	_, err := authClient.ChangePassword(context.Background(), req)
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.Unauthenticated:
 			returnValue.StatusCode = errors.STATUS_FARERPATH_ERROR
-			returnValue.Value = []byte(string(errors.FP_PASSWORD_NOT_MATCH))
-			return
-		}
-
-		newPsw, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-		if err != nil {
-			fmt.Println(err)
-			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
-			return
-		}
-
-		user.Password = string(newPsw)
-
-		resp, err = dbClient.UpdateUser(context.Background(), &dbService.NewUser{UserId: userId, NewUser: user})
-		if err != nil {
-			fmt.Println(err)
-			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
-			return
+			returnValue.Value = []byte(errors.FP_PASSWORD_NOT_MATCH)
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
 		}
+		return
+	}
 
-		if !util.IsSucced(resp.StatusCode) {
-			returnValue.StatusCode = int(resp.GetStatusCode())
-			return
-		}
-	*/
 	return
 }
 
-func UpdateUserProfile(sessionUserID, userID, userName, email, profilePhotoPath, country, birthday string, isCountryPublic, isBirthdayPublic, isProfilePublic bool) (returnValue *model.ReturnValue) {
+// Profile is profile update of user, empty fields are not changed
+type Profile struct {
+	UserName  string
+	Email     string
+	FirstName string
+	LastName  string
+	Country   string
+	// Birthday is YYYYMMDD
+	Birthday         string
+	ProfilePhotoPath string
+	// SessionDuration is hours, 0 is not changed
+	SessionDuration  uint32
+	IsCountryPublic  bool
+	IsBirthdayPublic bool
+	IsProfilePublic  bool
+}
+
+func UpdateUserProfile(sessionUserID, userID string, profile *Profile) (returnValue *model.ReturnValue) {
 	returnValue = util.InitReturnValue()
 
 	if sessionUserID != userID {
@@ -147,48 +153,82 @@ func UpdateUserProfile(sessionUserID, userID, userName, email, profilePhotoPath,
 		return
 	}
 
-	/*
-		resp, err := dbClient.FindUser(context.Background(), &dbService.UserId{UserId: userID})
-		if err != nil {
-			fmt.Println(err)
-			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
-			return
-		}
+	req := &authService.UpdateProfileRequest{
+		UserID:           userID,
+		UserName:         profile.UserName,
+		Email:            profile.Email,
+		FirstName:        profile.FirstName,
+		LastName:         profile.LastName,
+		Country:          strings.ToUpper(profile.Country),
+		ProfilePhotoPath: profile.ProfilePhotoPath,
+		SessionDuration:  profile.SessionDuration,
+		IsCountryPublic:  profile.IsCountryPublic,
+		IsBirthdayPublic: profile.IsBirthdayPublic,
+		IsProfilePublic:  profile.IsProfilePublic,
+	}
 
-		if !util.IsSucced(resp.StatusCode) {
-			returnValue.StatusCode = int(resp.GetStatusCode())
-			return
-		}
+	valid := (len(req.UserName) == 0 || isUserNameValid(req.UserName)) &&
+		(len(req.Email) == 0 || isEmailValid(req.Email)) &&
+		(len(req.FirstName) == 0 || isPersonalNameValid(req.FirstName)) &&
+		(len(req.LastName) == 0 || isPersonalNameValid(req.LastName)) &&
+		(len(req.Country) == 0 || isCountryCodeValid(req.Country)) &&
+		(len(profile.Birthday) == 0 || isBirthdayValid(profile.Birthday)) &&
+		(len(req.ProfilePhotoPath) == 0 || isProfilePhotoPathValid(userID, req.ProfilePhotoPath)) &&
+		req.SessionDuration <= maxSessionDuration
 
-		user := &dbService.User{}
-		err = ptypes.UnmarshalAny(resp.Value, user)
-		if err != nil {
-			fmt.Println(err)
-			returnValue.StatusCode = errors.STATUS_INTERNAL_ERROR
-			return
-		}
+	if !valid {
+		returnValue.StatusCode = http.StatusBadRequest
+		return
+	}
 
-		if isUserNameValid(userName) {
-			user.UserName = userName
-		}
+	if len(profile.Birthday) > 0 {
+		birthday, _ := time.Parse(birthdayLayout, profile.Birthday)
+		req.Birthday = birthday.Unix()
+	}
 
-		if isEmailValid(email) {
-			user.Email = email
+	_, err := authClient.UpdateProfile(context.Background(), req)
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.AlreadyExists:
+			returnValue.StatusCode = http.StatusConflict
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
 		}
+		return
+	}
 
-		if isCountryCodeValid(country) {
-			user.Country = country
-		}
+	return
+}
 
-		if isBirthdayValid(birthday) {
-			user.Birthday = birthday
-		}
+// UpdateLocationPrivacy func
+// How precisely other viewers see location of user's pictures:
+// exact, city or none
+func UpdateLocationPrivacy(sessionUserId, userId, locationPrivacy string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
 
-		//user.ProfilePhotoPath = profilePhotoPath
-		user.IsCountryPublic = isCountryPublic
-		user.IsBirthdayPublic = isBirthdayPublic
-		user.IsProfilePublic = isProfilePublic
-	*/
+	_, err := authClient.UpdateLocationPrivacy(context.Background(), &authService.UpdateLocationPrivacyRequest{UserID: userId, LocationPrivacy: locationPrivacy})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.InvalidArgument:
+			returnValue.StatusCode = http.StatusBadRequest
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
 
 	return
 }
@@ -202,18 +242,49 @@ func isUserNameValid(userName string) bool {
 	return re.MatchString(userName)
 }
 
+// isCountryCodeValid accepts ISO 3166-1 alpha-2 or alpha-3 code
 func isCountryCodeValid(code string) bool {
-	if len(code) != 2 || len(code) != 3 {
+	re := regexp.MustCompile("^[a-zA-Z]{2,3}$")
+	return re.MatchString(code)
+}
+
+// birthdayLayout is YYYYMMDD
+const birthdayLayout = "20060102"
+
+// isBirthdayValid accepts real date in the past, not before 1900
+func isBirthdayValid(birthday string) bool {
+	date, err := time.Parse(birthdayLayout, birthday)
+	if err != nil {
 		return false
 	}
 
-	return true
+	return date.Year() >= 1900 && date.Before(time.Now())
 }
 
-func isBirthdayValid(birthday string) bool {
-	if len(birthday) != 8 {
+// maxSessionDuration is hours, refresh token extends no session longer
+const maxSessionDuration = 24 * 30
+
+func isPersonalNameValid(name string) bool {
+	if utf8.RuneCountInString(name) > 50 || strings.TrimSpace(name) != name {
 		return false
 	}
 
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+
 	return true
 }
+
+// isProfilePhotoPathValid accepts only picture of user, as /v1/Pictures/{userId}/{pictureId}
+func isProfilePhotoPathValid(userID, photoPath string) bool {
+	prefix := "/v1/Pictures/" + userID + "/"
+	if !strings.HasPrefix(photoPath, prefix) || path.Clean(photoPath) != photoPath {
+		return false
+	}
+
+	pictureID := strings.TrimPrefix(photoPath, prefix)
+	return len(pictureID) > 0 && !strings.Contains(pictureID, "/")
+}
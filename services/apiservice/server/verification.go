@@ -0,0 +1,144 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	authService "github.com/farerpath/authservice/proto"
+
+	"github.com/farerpath/server/common/util"
+	"github.com/farerpath/server/model/consts"
+	"github.com/farerpath/server/model/errors"
+	"github.com/farerpath/server/model/model"
+)
+
+// Users verify email with link mailed by auth service.
+// Until then they keep pictures private or to album members,
+// public pictures and share links need verified email
+
+// RequestEmailVerification func
+// Mails verification link to email of user again
+func RequestEmailVerification(sessionUserId, userId string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if sessionUserId != userId {
+		returnValue.StatusCode = errors.STATUS_NOT_AUTHORIZED
+		return
+	}
+
+	_, err := authClient.RequestEmailVerification(context.Background(), &authService.RequestEmailVerificationRequest{UserID: userId})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			returnValue.StatusCode = http.StatusNotFound
+		case codes.FailedPrecondition:
+			returnValue.StatusCode = http.StatusConflict
+		default:
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.StatusCode = http.StatusAccepted
+	return
+}
+
+// VerifyEmail func
+// Marks email verified with token of verification link
+func VerifyEmail(token string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	resp, err := authClient.VerifyEmail(context.Background(), &authService.VerifyEmailRequest{Token: token})
+	if err != nil {
+		st, _ := status.FromError(err)
+		if st.Code() == codes.NotFound {
+			returnValue.StatusCode = http.StatusNotFound
+		} else {
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"userID": resp.GetUserID()})
+	return
+}
+
+// RequestPasswordReset func
+// Mails reset link when email is registered.
+// Reply is same either way, so emails of users are not told
+func RequestPasswordReset(email string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if !isEmailValid(email) {
+		returnValue.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	_, err := authClient.RequestPasswordReset(context.Background(), &authService.RequestPasswordResetRequest{Email: email})
+	if err != nil {
+		log.Println(err)
+		returnValue.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	returnValue.StatusCode = http.StatusAccepted
+	return
+}
+
+// ResetPassword func
+// Sets new password with token of reset link, all sessions of user are logged out
+func ResetPassword(token, newPassword string) (returnValue *model.ReturnValue) {
+	returnValue = util.InitReturnValue()
+
+	if !isPasswordValid(newPassword) {
+		returnValue.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	resp, err := authClient.ResetPassword(context.Background(), &authService.ResetPasswordRequest{Token: token, NewPassword: newPassword})
+	if err != nil {
+		st, _ := status.FromError(err)
+		if st.Code() == codes.NotFound {
+			returnValue.StatusCode = http.StatusNotFound
+		} else {
+			log.Println(err)
+			returnValue.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	returnValue.Value = util.MakeReturnValueToJson(map[string]interface{}{"userID": resp.GetUserID()})
+	return
+}
+
+// canPublish tells whether user may publish with publishRange,
+// public needs verified email
+func canPublish(userId string, publishRange uint32) (bool, error) {
+	if publishRange != consts.PUBLIC {
+		return true, nil
+	}
+
+	return isEmailVerified(userId)
+}
+
+// notVerified sets reply of publishing refused for unverified email
+func notVerified(returnValue *model.ReturnValue) {
+	returnValue.StatusCode = http.StatusForbidden
+	returnValue.Value = []byte(errors.FP_EMAIL_NOT_VERIFIED)
+}
+
+func isEmailVerified(userId string) (bool, error) {
+	resp, err := authClient.GetUser(context.Background(), &authService.GetUserRequest{UserID: userId})
+	if err != nil {
+		return false, err
+	}
+
+	return resp.GetEmailVerified(), nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	pb "github.com/farerpath/authservice/proto"
+	"github.com/farerpath/server/model/model"
+
+	palbum "github.com/farerpath/albumservice/proto"
+	psession "github.com/farerpath/sessionservice/proto"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Account is deleted after DELETIONGRACE, user can cancel deletion until then.
+// Deletion runs steps in order, each step can run again and finished steps
+// are recorded, so deletion stopped by failure or crash continues
+// from its first unfinished step when its lease expires
+
+const (
+	// deletionLease is how long a worker owns deletion, failed deletion is retried after it
+	deletionLease = 10 * time.Minute
+	// deletionPoll is interval of looking for due deletions
+	deletionPoll = time.Minute
+)
+
+// accountDeletion model
+// farerpath.accountDeletions
+// Requested deletion, deleted when deletion finished
+type accountDeletion struct {
+	UserID      string    `bson:"_id"`
+	RequestedAt time.Time `bson:"requestedAt"`
+	DeleteAt    time.Time `bson:"deleteAt"`
+	// Done are names of finished steps
+	Done []string `bson:"done,omitempty"`
+	// StartedAt is set by first run, deletion cannot be cancelled after it
+	StartedAt   time.Time `bson:"startedAt,omitempty"`
+	LockedUntil time.Time `bson:"lockedUntil,omitempty"`
+	Attempts    int       `bson:"attempts,omitempty"`
+	LastError   string    `bson:"lastError,omitempty"`
+}
+
+// deletionAudit model
+// farerpath.auditLog
+type deletionAudit struct {
+	Event       string    `bson:"event"`
+	Subject     string    `bson:"subject"`
+	RequestedAt time.Time `bson:"requestedAt"`
+	At          time.Time `bson:"at"`
+}
+
+func deletionCol() *mongo.Collection {
+	return col.Database().Collection("accountDeletions")
+}
+
+type deletionStep struct {
+	name string
+	run  func(ctx context.Context, userID string) error
+}
+
+// deletionSteps run in order. User is locked out before data is deleted,
+// account is deleted last, so user ID is not taken again before deletion finished
+var deletionSteps = []deletionStep{
+	{"lock", lockAccount},
+	{"sessions", revokeSessions},
+	{"albums", deleteAlbumData},
+	{"files", deleteFiles},
+	{"account", deleteAccount},
+}
+
+// userIDTaken tells whether user ID is used by account or account being deleted
+func userIDTaken(ctx context.Context, userID string) (bool, error) {
+	for _, c := range []*mongo.Collection{col, deletionCol()} {
+		n, err := c.CountDocuments(ctx, bson.D{{"_id", userID}})
+		if err != nil {
+			return false, err
+		}
+		if n != 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RequestAccountDeletion func
+// Schedules deletion of account after grace period and mails user about it.
+// Requesting again gives time of deletion already scheduled
+func (a *authServer) RequestAccountDeletion(ctx context.Context, req *pb.RequestAccountDeletionRequest) (*pb.RequestAccountDeletionReply, error) {
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"_id", req.GetUserID()}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.RequestAccountDeletionReply{}, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("Error accured: RequestAccountDeletion(FindUser)\n%v", err)
+		return &pb.RequestAccountDeletionReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	// Users logging in only with identity providers have no password to check
+	if len(user.Password) > 0 {
+		match, _, err := verifyPassword(user.Password, user.UserID, req.GetPassword())
+		if err != nil {
+			log.Printf("Error accured: RequestAccountDeletion(VerifyPassword)\n%v", err)
+			return &pb.RequestAccountDeletionReply{}, status.Error(codes.Internal, err.Error())
+		}
+		if !match {
+			return &pb.RequestAccountDeletionReply{}, status.Error(codes.Unauthenticated, "password not match")
+		}
+	}
+
+	now := time.Now().UTC()
+
+	result, err := deletionCol().UpdateOne(ctx, bson.D{{"_id", user.UserID}},
+		bson.D{{"$setOnInsert", bson.D{{"requestedAt", now}, {"deleteAt", now.Add(DELETIONGRACE)}}}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error accured: RequestAccountDeletion\n%v", err)
+		return &pb.RequestAccountDeletionReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	deletion := &accountDeletion{}
+	if err := deletionCol().FindOne(ctx, bson.D{{"_id", user.UserID}}).Decode(deletion); err != nil {
+		log.Printf("Error accured: RequestAccountDeletion(FindDeletion)\n%v", err)
+		return &pb.RequestAccountDeletionReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	_, err = col.UpdateOne(ctx, bson.D{{"_id", user.UserID}}, bson.D{{"$set", bson.D{{"deleteAt", deletion.DeleteAt}}}})
+	if err != nil {
+		log.Printf("Error accured: RequestAccountDeletion(UpdateUser)\n%v", err)
+		return &pb.RequestAccountDeletionReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.UpsertedCount > 0 {
+		if err := sendDeletionNotice(user, deletion.DeleteAt); err != nil {
+			log.Printf("Error accured: RequestAccountDeletion(SendMail)\n%v", err)
+		}
+	}
+
+	return &pb.RequestAccountDeletionReply{DeleteAt: deletion.DeleteAt.Unix()}, nil
+}
+
+// CancelAccountDeletion func
+// Keeps account, deletion cannot be cancelled after it started
+func (a *authServer) CancelAccountDeletion(ctx context.Context, req *pb.CancelAccountDeletionRequest) (*pb.CancelAccountDeletionReply, error) {
+	result, err := deletionCol().DeleteOne(ctx, bson.D{{"_id", req.GetUserID()}, {"startedAt", bson.D{{"$exists", false}}}})
+	if err != nil {
+		log.Printf("Error accured: CancelAccountDeletion\n%v", err)
+		return &pb.CancelAccountDeletionReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.DeletedCount == 0 {
+		n, err := deletionCol().CountDocuments(ctx, bson.D{{"_id", req.GetUserID()}})
+		if err != nil {
+			log.Printf("Error accured: CancelAccountDeletion\n%v", err)
+			return &pb.CancelAccountDeletionReply{}, status.Error(codes.Unknown, err.Error())
+		}
+		if n == 0 {
+			return &pb.CancelAccountDeletionReply{}, status.Error(codes.NotFound, "deletion not requested")
+		}
+		return &pb.CancelAccountDeletionReply{}, status.Error(codes.FailedPrecondition, "deletion already started")
+	}
+
+	_, err = col.UpdateOne(ctx, bson.D{{"_id", req.GetUserID()}}, bson.D{{"$unset", bson.D{{"deleteAt", ""}}}})
+	if err != nil {
+		log.Printf("Error accured: CancelAccountDeletion(UpdateUser)\n%v", err)
+		return &pb.CancelAccountDeletionReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.CancelAccountDeletionReply{}, nil
+}
+
+func sendDeletionNotice(user *model.Account, deleteAt time.Time) error {
+	return mailer.Send(user.Email, "Your Farerpath account will be deleted",
+		fmt.Sprintf("Hello %v,\n\nYour account, albums and pictures will be deleted on %v.\n\nLog in and cancel the deletion before then to keep your account.\n",
+			user.UserID, deleteAt.Format("2 Jan 2006 15:04 MST")))
+}
+
+// deletionWorker runs due deletions, several workers may run at once
+func deletionWorker() {
+	for range time.Tick(deletionPoll) {
+		for runDueDeletion(context.Background()) {
+		}
+	}
+}
+
+// runDueDeletion takes lease of one due deletion and runs it,
+// false when no deletion is due
+func runDueDeletion(ctx context.Context) bool {
+	now := time.Now().UTC()
+	deletion := &accountDeletion{}
+
+	err := deletionCol().FindOneAndUpdate(ctx, bson.D{
+		{"deleteAt", bson.D{{"$lte", now}}},
+		{"$or", bson.A{
+			bson.D{{"lockedUntil", bson.D{{"$exists", false}}}},
+			bson.D{{"lockedUntil", bson.D{{"$lte", now}}}},
+		}},
+	}, bson.D{
+		{"$set", bson.D{{"lockedUntil", now.Add(deletionLease)}}},
+		{"$min", bson.D{{"startedAt", now}}},
+		{"$inc", bson.D{{"attempts", 1}}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(deletion)
+	if err == mongo.ErrNoDocuments {
+		return false
+	}
+	if err != nil {
+		log.Printf("Error accured: Deletion(FindDue)\n%v", err)
+		return false
+	}
+
+	if err := runDeletion(ctx, deletion); err != nil {
+		log.Printf("Error accured: Deletion of %v, attempt %v\n%v", deletion.UserID, deletion.Attempts, err)
+
+		_, err = deletionCol().UpdateOne(ctx, bson.D{{"_id", deletion.UserID}}, bson.D{{"$set", bson.D{{"lastError", err.Error()}}}})
+		if err != nil {
+			log.Printf("Error accured: Deletion(RecordError)\n%v", err)
+		}
+	}
+
+	return true
+}
+
+// runDeletion runs unfinished steps of deletion, deletion is removed after last step
+func runDeletion(ctx context.Context, deletion *accountDeletion) error {
+	done := map[string]bool{}
+	for _, name := range deletion.Done {
+		done[name] = true
+	}
+
+	for _, step := range deletionSteps {
+		if done[step.name] {
+			continue
+		}
+
+		// Lease is renewed for each step, so long deletion is not taken by other worker
+		_, err := deletionCol().UpdateOne(ctx, bson.D{{"_id", deletion.UserID}}, bson.D{{"$set", bson.D{{"lockedUntil", time.Now().Add(deletionLease).UTC()}}}})
+		if err != nil {
+			return err
+		}
+
+		stepCtx, cancel := context.WithTimeout(ctx, deletionLease)
+		err = step.run(stepCtx, deletion.UserID)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("step %v: %v", step.name, err)
+		}
+
+		_, err = deletionCol().UpdateOne(ctx, bson.D{{"_id", deletion.UserID}}, bson.D{{"$addToSet", bson.D{{"done", step.name}}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Deletion is kept in audit log only
+	_, err := auditCol().InsertOne(ctx, &deletionAudit{
+		Event:       "accountDeleted",
+		Subject:     deletion.UserID,
+		RequestedAt: deletion.RequestedAt,
+		At:          time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := deletionCol().DeleteOne(ctx, bson.D{{"_id", deletion.UserID}}); err != nil {
+		return err
+	}
+
+	log.Printf("Account %v deleted\n", deletion.UserID)
+	return nil
+}
+
+// lockAccount stops logins of user
+func lockAccount(ctx context.Context, userID string) error {
+	_, err := col.UpdateOne(ctx, bson.D{{"_id", userID}}, bson.D{{"$set", bson.D{{"deleting", true}}}})
+	return err
+}
+
+// revokeSessions deletes all sessions of user, no session is kept without auth token
+func revokeSessions(ctx context.Context, userID string) error {
+	_, err := sclient.RevokeOtherSessions(ctx, &psession.SessionsRequest{UserID: userID})
+	return err
+}
+
+// deleteAlbumData deletes albums, pictures, memberships and reactions of user in album service
+func deleteAlbumData(ctx context.Context, userID string) error {
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"_id", userID}}).Decode(user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	_, err = aclient.DelUserData(ctx, &palbum.DelUserDataRequest{UserID: userID, Email: user.Email})
+	return err
+}
+
+// deleteFiles deletes pictures, renditions and uploads of user in file service
+func deleteFiles(ctx context.Context, userID string) error {
+	req, err := http.NewRequest(http.MethodDelete, FILESERVICEURL+"users?"+url.Values{"userId": {userID}}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("file service replied %v", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// deleteAccount deletes account and data of user kept by auth service
+func deleteAccount(ctx context.Context, userID string) error {
+	if _, err := tokenCol().DeleteMany(ctx, bson.D{{"userID", userID}}); err != nil {
+		return err
+	}
+
+	if _, err := oidcStateCol().DeleteMany(ctx, bson.D{{"userID", userID}}); err != nil {
+		return err
+	}
+
+	if _, err := auditCol().DeleteMany(ctx, bson.D{{"scope", accountPolicy.scope}, {"subject", userID}}); err != nil {
+		return err
+	}
+
+	clearFailures(userID)
+
+	// Sessions made while lock was set are revoked again
+	if err := revokeSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	_, err := col.DeleteOne(ctx, bson.D{{"_id", userID}})
+	return err
+}
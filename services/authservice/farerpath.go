@@ -7,16 +7,20 @@ import (
 
 	pb "github.com/farerpath/authservice/proto"
 
+	"github.com/farerpath/server/model/consts"
 	"github.com/farerpath/server/model/model"
 
+	palbum "github.com/farerpath/albumservice/proto"
 	psession "github.com/farerpath/sessionservice/proto"
 
 	"net"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/go-redis/redis"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -40,24 +44,141 @@ var (
 	DBADDR = "mongodb://maindb-service:27017"
 )
 
+// REDISADDR : Redis Address, failed logins are counted in it
+var REDISADDR = "localhost:6379"
+
+var (
+	// SMTPADDR : host:port of SMTP server, service does not start without it unless MAILLOG is set
+	SMTPADDR     = ""
+	SMTPUSER     = ""
+	SMTPPASSWORD = ""
+	MAILFROM     = "Farerpath <no-reply@farerpath.com>"
+	// MAILLOG : mails with their links are logged instead of sent, for development only
+	MAILLOG = false
+	// MAILDIR : directory log mailer writes mails to
+	MAILDIR = ""
+	// WEBURL : base of links in mails
+	WEBURL = "https://farerpath.com"
+)
+
+// Identity providers, provider is enabled when its client ID is set
+var (
+	GOOGLECLIENTID     = ""
+	GOOGLECLIENTSECRET = ""
+	// Sign in with Apple: services ID, team ID, key ID and .p8 key file
+	APPLECLIENTID = ""
+	APPLETEAMID   = ""
+	APPLEKEYID    = ""
+	APPLEKEYFILE  = ""
+	// Generic OpenID Connect provider
+	OIDCNAME         = "oidc"
+	OIDCISSUER       = ""
+	OIDCCLIENTID     = ""
+	OIDCCLIENTSECRET = ""
+	// OIDCREDIRECTURL : web page providers send users back to, WEBURL/oauth/callback by default
+	OIDCREDIRECTURL = ""
+)
+
+// SALT : prefix of legacy bcrypt passwords
 const (
 	SALT = ""
 )
 
+// PEPPER : key of argon2id passwords, kept out of DB. Required
+var PEPPER = ""
+
 type query map[string]interface{}
 
 const SESSIONSERVICEADDR = "sessionservice-service:17080"
 
 var sclient psession.SessionClient
 
+// ALBUMSERVICEADDR : album service, albums of deleted accounts are deleted there
+const ALBUMSERVICEADDR = "albumservice-service:17080"
+
+var aclient palbum.AlbumClient
+
+// FILESERVICEURL : file service, files of deleted accounts are deleted there
+var FILESERVICEURL = "http://fileservice-service/"
+
+// DELETIONGRACE : time user can cancel account deletion
+var DELETIONGRACE = 14 * 24 * time.Hour
+
 func init() {
 	if addr := os.Getenv("FP_DB_ADDRESS"); len(addr) > 1 {
 		log.Printf("DB address received: %v\n", addr)
 		DBADDR = addr
 	}
+
+	PEPPER = os.Getenv("FP_PASSWORD_PEPPER")
+
+	if addr := os.Getenv("FP_REDIS_ADDRESS"); len(addr) > 1 {
+		log.Printf("REDIS address received: %v\n", addr)
+		REDISADDR = addr
+	}
+
+	if addr := os.Getenv("FP_SMTP_ADDRESS"); len(addr) > 1 {
+		log.Printf("SMTP address received: %v\n", addr)
+		SMTPADDR = addr
+	}
+
+	SMTPUSER = os.Getenv("FP_SMTP_USER")
+	SMTPPASSWORD = os.Getenv("FP_SMTP_PASSWORD")
+
+	if from := os.Getenv("FP_MAIL_FROM"); len(from) > 1 {
+		MAILFROM = from
+	}
+
+	MAILLOG = os.Getenv("FP_MAIL_LOG") == "true"
+	MAILDIR = os.Getenv("FP_MAIL_DIR")
+
+	if url := os.Getenv("FP_WEB_URL"); len(url) > 1 {
+		WEBURL = strings.TrimSuffix(url, "/")
+	}
+
+	GOOGLECLIENTID = os.Getenv("FP_GOOGLE_CLIENT_ID")
+	GOOGLECLIENTSECRET = os.Getenv("FP_GOOGLE_CLIENT_SECRET")
+
+	APPLECLIENTID = os.Getenv("FP_APPLE_CLIENT_ID")
+	APPLETEAMID = os.Getenv("FP_APPLE_TEAM_ID")
+	APPLEKEYID = os.Getenv("FP_APPLE_KEY_ID")
+	APPLEKEYFILE = os.Getenv("FP_APPLE_KEY_FILE")
+
+	if name := os.Getenv("FP_OIDC_NAME"); len(name) > 1 {
+		OIDCNAME = name
+	}
+	OIDCISSUER = os.Getenv("FP_OIDC_ISSUER")
+	OIDCCLIENTID = os.Getenv("FP_OIDC_CLIENT_ID")
+	OIDCCLIENTSECRET = os.Getenv("FP_OIDC_CLIENT_SECRET")
+
+	OIDCREDIRECTURL = WEBURL + "/oauth/callback"
+	if url := os.Getenv("FP_OIDC_REDIRECT_URL"); len(url) > 1 {
+		OIDCREDIRECTURL = url
+	}
+
+	if url := os.Getenv("FP_FILESERVICE_URL"); len(url) > 1 {
+		FILESERVICEURL = strings.TrimSuffix(url, "/") + "/"
+	}
+
+	if hours, err := strconv.Atoi(os.Getenv("FP_DELETION_GRACE_HOURS")); err == nil && hours >= 0 {
+		log.Printf("Deletion grace period received: %v hours\n", hours)
+		DELETIONGRACE = time.Duration(hours) * time.Hour
+	}
 }
 
 func main() {
+	// Hashes do not tell whether they were keyed, so service never hashes without PEPPER
+	if len(PEPPER) < 1 {
+		log.Fatalln("FP_PASSWORD_PEPPER not set")
+	}
+
+	rClient = redis.NewClient(&redis.Options{
+		Addr:     REDISADDR,
+		Password: "",
+		DB:       0,
+	})
+	defer rClient.Close()
+
 	client, err := mongo.NewClient(options.Client().ApplyURI(DBADDR))
 	if err != nil {
 		log.Fatalf("DB Connection failed:\n%v", err)
@@ -72,6 +193,21 @@ func main() {
 
 	col = client.Database("farerpath").Collection(USERDB)
 
+	migrate(context.Background())
+
+	// Logged mails have live reset and verification links
+	switch {
+	case len(SMTPADDR) > 0:
+		mailer = newSMTPMailer(SMTPADDR, MAILFROM, SMTPUSER, SMTPPASSWORD)
+	case MAILLOG:
+		log.Println("FP_MAIL_LOG set, mails are logged and not sent")
+		mailer = &logMailer{dir: MAILDIR}
+	default:
+		log.Fatalln("FP_SMTP_ADDRESS not set, set FP_MAIL_LOG=true to log mails in development")
+	}
+
+	setupOIDCProviders()
+
 	conn, err := grpc.Dial(SESSIONSERVICEADDR, grpc.WithInsecure())
 	if err != nil {
 		log.Printf("Failed to connetc grpc Session Service %v", err)
@@ -79,6 +215,15 @@ func main() {
 
 	sclient = psession.NewSessionClient(conn)
 
+	conn, err = grpc.Dial(ALBUMSERVICEADDR, grpc.WithInsecure())
+	if err != nil {
+		log.Printf("Failed to connetc grpc Album Service %v", err)
+	}
+
+	aclient = palbum.NewAlbumClient(conn)
+
+	go deletionWorker()
+
 	listener, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Printf("Failed to listen tcp %v", err)
@@ -99,37 +244,73 @@ func main() {
 func (a *authServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginReply, error) {
 	resp := &pb.LoginReply{}
 
+	if err := checkThrottle(ctx, req.GetLoginUserID(), req.GetLoginIP()); err != nil {
+		return resp, err
+	}
+
 	result := &model.Account{}
 
 	err := col.FindOne(ctx, bson.D{{"_id", req.LoginUserID}}).Decode(result)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
+			recordFailure(ctx, req.GetLoginUserID(), req.GetLoginIP())
 			return resp, status.Error(codes.NotFound, "id not found")
 		}
 		log.Printf("Error accured: Login(FindUser)\n%v", err)
 		return resp, status.Error(codes.Unknown, err.Error())
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(result.Password), []byte(SALT+req.GetLoginUserID()+req.GetLoginPassword()))
-	if err != nil {
+	match, rehash, err := verifyPassword(result.Password, req.GetLoginUserID(), req.GetLoginPassword())
+	if err != nil || !match {
 		log.Printf("Failed to validate password. May password not match or data damaged \n%v", err)
+		recordFailure(ctx, req.GetLoginUserID(), req.GetLoginIP())
 		return resp, status.Error(codes.Unauthenticated, "401")
 	}
 
+	// Failures of TOTP account are cleared when second factor passes, so password alone does not reset them
+	if !result.TOTPEnabled {
+		clearFailures(req.GetLoginUserID())
+	}
+
+	if rehash {
+		rehashPassword(ctx, result.UserID, result.Password, req.GetLoginPassword())
+	}
+
+	return login(ctx, result, req.GetLoginDeviceType(), req.GetLoginIP())
+}
+
+// login makes session of user, asks second factor first when user has TOTP
+func login(ctx context.Context, user *model.Account, deviceType int32, loginIP string) (*pb.LoginReply, error) {
+	if user.TOTPEnabled {
+		return startMFALogin(ctx, user, deviceType, loginIP)
+	}
+
+	return newSession(user, deviceType, loginIP)
+}
+
+// newSession logs user in
+func newSession(user *model.Account, deviceType int32, loginIP string) (*pb.LoginReply, error) {
+	resp := &pb.LoginReply{}
+
+	// Account is being deleted
+	if user.Deleting {
+		return resp, status.Error(codes.NotFound, "id not found")
+	}
+
 	// Session
 	// Session Service will return JWT Token
 	// return THAT JWT Token
 	token, err := sclient.NewSession(context.Background(), &psession.SessionRequest{
-		UserID: req.GetLoginUserID(), LoginDeviceType: req.GetLoginDeviceType(), SessionDurationTime: int32(result.SessionDuration), LoginIP: req.GetLoginIP()})
+		UserID: user.UserID, LoginDeviceType: deviceType, SessionDurationTime: int32(user.SessionDuration), LoginIP: loginIP})
 
 	if err != nil {
 		log.Printf("Failed to get token, service returned error \n%v", err)
 		return resp, err
 	}
 
-	resp.UserID = result.UserID
-	resp.UserName = result.UserName
-	resp.Country = result.Country
+	resp.UserID = user.UserID
+	resp.UserName = user.UserName
+	resp.Country = user.Country
 	resp.RefreshToken = token.RefreshToken
 	resp.AuthToken = token.AuthToken
 
@@ -165,16 +346,16 @@ func (a *authServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb
 	resp := &pb.RegisterReply{}
 	// Existance check
 
-	n, err := col.CountDocuments(ctx, bson.D{{"_id", req.UserID}})
+	taken, err := userIDTaken(ctx, req.UserID)
 	if err != nil {
 		log.Printf("Error accured: Register\n%v", err)
 		return resp, status.Error(codes.Unknown, err.Error())
 	}
-	if n != 0 {
+	if taken {
 		return resp, status.Error(codes.AlreadyExists, "UserID already exists")
 	}
 
-	n, err = col.CountDocuments(ctx, bson.D{{"email", req.Email}})
+	n, err := col.CountDocuments(ctx, bson.D{{"email", req.Email}})
 	if err != nil {
 		log.Printf("Error accured: Register\n%v", err)
 		return resp, status.Error(codes.Unknown, err.Error())
@@ -183,21 +364,36 @@ func (a *authServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb
 		return resp, status.Error(codes.AlreadyExists, "Email already exists")
 	}
 
-	// Email confirm
-
-	// If confirmed
 	// Hash Password and Send to DB Service
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(SALT+req.GetUserID()+req.GetPassword()), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(req.GetPassword())
 	if err != nil {
 		log.Printf("password hashing failed\n %v", err)
 		return resp, status.Error(codes.Internal, err.Error())
 	}
 
-	user := &model.Account{
-		UserID:           req.GetUserID(),
+	user := newAccount(req.GetUserID(), req.GetEmail(), hashedPassword)
+
+	_, err = col.InsertOne(ctx, user)
+	if err != nil {
+		log.Printf("Error accured: Register\n%v", err)
+		return resp, status.Error(codes.Internal, err.Error())
+	}
+
+	// Account is made, user can ask for verification again when mail is lost
+	if err := sendVerification(ctx, user.UserID, user.Email); err != nil {
+		log.Printf("Error accured: Register(SendVerification)\n%v", err)
+	}
+
+	return resp, nil
+}
+
+// newAccount gives account with default settings
+func newAccount(userID, email, hashedPassword string) *model.Account {
+	return &model.Account{
+		UserID:           userID,
 		UserName:         "",
-		Password:         string(hashedPassword),
-		Email:            req.GetEmail(),
+		Password:         hashedPassword,
+		Email:            email,
 		FirstName:        "",
 		LastName:         "",
 		Country:          "",
@@ -207,21 +403,17 @@ func (a *authServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb
 		IsBirthdayPublic: false,
 		IsCountryPublic:  false,
 		IsProfilePublic:  false,
+		LocationPrivacy:  consts.LOCATION_CITY,
 	}
-
-	_, err = col.InsertOne(ctx, user)
-	if err != nil {
-		log.Printf("Error accured: Register\n%v", err)
-		return resp, status.Error(codes.Internal, err.Error())
-	}
-
-	return resp, nil
 }
 
 func (a *authServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserReply, error) {
 	user := &model.Account{}
 
 	err := col.FindOne(ctx, bson.D{{"_id", req.UserID}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.GetUserReply{}, status.Error(codes.NotFound, "user not found")
+	}
 	if err != nil {
 		log.Printf("Error accured: Login(GetUser)\n%v", err)
 		return &pb.GetUserReply{}, status.Error(codes.Unknown, err.Error())
@@ -240,7 +432,159 @@ func (a *authServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.G
 		IsProfilePublic:  user.IsProfilePublic,
 		IsCountryPublic:  user.IsCountryPublic,
 		IsBirthdayPublic: user.IsBirthdayPublic,
+		LocationPrivacy:  user.LocationPrivacy,
+		EmailVerified:    user.EmailVerified,
+		TotpEnabled:      user.TOTPEnabled,
+	}
+
+	if !user.DeleteAt.IsZero() {
+		reply.DeleteAt = user.DeleteAt.Unix()
+	}
+
+	if len(reply.LocationPrivacy) < 1 {
+		reply.LocationPrivacy = consts.LOCATION_CITY
 	}
 
 	return reply, nil
 }
+
+// UpdateLocationPrivacy func
+// Sets how precisely other viewers see location of user's pictures
+func (a *authServer) UpdateLocationPrivacy(ctx context.Context, req *pb.UpdateLocationPrivacyRequest) (*pb.UpdateLocationPrivacyReply, error) {
+	switch req.GetLocationPrivacy() {
+	case consts.LOCATION_EXACT, consts.LOCATION_CITY, consts.LOCATION_NONE:
+	default:
+		return &pb.UpdateLocationPrivacyReply{}, status.Error(codes.InvalidArgument, "unknown location privacy")
+	}
+
+	result, err := col.UpdateOne(ctx, bson.D{{"_id", req.GetUserID()}}, bson.D{{"$set", bson.D{{"locationPrivacy", req.GetLocationPrivacy()}}}})
+	if err != nil {
+		log.Printf("Error accured: UpdateLocationPrivacy\n%v", err)
+		return &pb.UpdateLocationPrivacyReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.MatchedCount == 0 {
+		return &pb.UpdateLocationPrivacyReply{}, status.Error(codes.NotFound, "user not found")
+	}
+
+	return &pb.UpdateLocationPrivacyReply{}, nil
+}
+
+// ChangePassword func
+// Replaces password when old one matches, other sessions of user are logged out
+func (a *authServer) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordReply, error) {
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"_id", req.GetUserID()}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.ChangePasswordReply{}, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("Error accured: ChangePassword(FindUser)\n%v", err)
+		return &pb.ChangePasswordReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	match, _, err := verifyPassword(user.Password, req.GetUserID(), req.GetOldPassword())
+	if err != nil {
+		log.Printf("Error accured: ChangePassword(VerifyPassword)\n%v", err)
+		return &pb.ChangePasswordReply{}, status.Error(codes.Internal, err.Error())
+	}
+	if !match {
+		return &pb.ChangePasswordReply{}, status.Error(codes.Unauthenticated, "password not match")
+	}
+
+	hashedPassword, err := hashPassword(req.GetNewPassword())
+	if err != nil {
+		log.Printf("password hashing failed\n %v", err)
+		return &pb.ChangePasswordReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	_, err = col.UpdateOne(ctx, bson.D{{"_id", req.GetUserID()}}, bson.D{{"$set", bson.D{{"password", hashedPassword}}}})
+	if err != nil {
+		log.Printf("Error accured: ChangePassword\n%v", err)
+		return &pb.ChangePasswordReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	// Whoever knew old password is logged out
+	_, err = sclient.RevokeOtherSessions(ctx, &psession.SessionsRequest{UserID: req.GetUserID(), AuthToken: req.GetAuthToken()})
+	if err != nil {
+		log.Printf("Error accured: ChangePassword(RevokeOtherSessions)\n%v", err)
+		return &pb.ChangePasswordReply{}, status.Error(codes.Internal, "password changed, but other sessions not logged out")
+	}
+
+	return &pb.ChangePasswordReply{}, nil
+}
+
+// UpdateProfile func
+// Sets profile and privacy flags of user, empty fields are kept
+func (a *authServer) UpdateProfile(ctx context.Context, req *pb.UpdateProfileRequest) (*pb.UpdateProfileReply, error) {
+	set := bson.D{
+		{"isBirthdayPublic", req.GetIsBirthdayPublic()},
+		{"isCountryPublic", req.GetIsCountryPublic()},
+		{"isProfilePublic", req.GetIsProfilePublic()},
+	}
+
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"_id", req.GetUserID()}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.UpdateProfileReply{}, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("Error accured: UpdateProfile(FindUser)\n%v", err)
+		return &pb.UpdateProfileReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	emailChanged := len(req.GetEmail()) > 0 && req.GetEmail() != user.Email
+
+	if emailChanged {
+		n, err := col.CountDocuments(ctx, bson.D{{"email", req.GetEmail()}, {"_id", bson.D{{"$ne", req.GetUserID()}}}})
+		if err != nil {
+			log.Printf("Error accured: UpdateProfile\n%v", err)
+			return &pb.UpdateProfileReply{}, status.Error(codes.Unknown, err.Error())
+		}
+		if n != 0 {
+			return &pb.UpdateProfileReply{}, status.Error(codes.AlreadyExists, "Email already exists")
+		}
+
+		set = append(set, bson.E{"email", req.GetEmail()}, bson.E{"emailVerified", false})
+	}
+
+	for _, field := range []bson.E{
+		{"userName", req.GetUserName()},
+		{"firstName", req.GetFirstName()},
+		{"lastName", req.GetLastName()},
+		{"country", req.GetCountry()},
+		{"profilePhotoPath", req.GetProfilePhotoPath()},
+	} {
+		if len(field.Value.(string)) > 0 {
+			set = append(set, field)
+		}
+	}
+
+	if req.GetBirthday() != 0 {
+		set = append(set, bson.E{"age", time.Unix(req.GetBirthday(), 0).UTC()})
+	}
+
+	if req.GetSessionDuration() != 0 {
+		set = append(set, bson.E{"sessionDuration", req.GetSessionDuration()})
+	}
+
+	result, err := col.UpdateOne(ctx, bson.D{{"_id", req.GetUserID()}}, bson.D{{"$set", set}})
+	if err != nil {
+		log.Printf("Error accured: UpdateProfile\n%v", err)
+		return &pb.UpdateProfileReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.MatchedCount == 0 {
+		return &pb.UpdateProfileReply{}, status.Error(codes.NotFound, "user not found")
+	}
+
+	if emailChanged {
+		if err := sendVerification(ctx, req.GetUserID(), req.GetEmail()); err != nil {
+			log.Printf("Error accured: UpdateProfile(SendVerification)\n%v", err)
+		}
+	}
+
+	return &pb.UpdateProfileReply{}, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mails to users are sent by mailer set in main.
+// SMTP mailer is used when SMTP server is configured,
+// log mailer writes mails to directory or log for local development
+
+// Mailer sends plain text mail
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+var mailer Mailer = &logMailer{}
+
+// smtpMailer sends through SMTP server, with PLAIN auth when user is set
+type smtpMailer struct {
+	address  string
+	from     string
+	user     string
+	password string
+}
+
+func newSMTPMailer(address, from, user, password string) *smtpMailer {
+	return &smtpMailer{address: address, from: from, user: user, password: password}
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if len(m.user) > 0 {
+		host, _, err := net.SplitHostPort(m.address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.user, m.password, host)
+	}
+
+	return smtp.SendMail(m.address, auth, m.from, []string{to}, composeMail(m.from, to, subject, body))
+}
+
+// logMailer writes mails to files in dir, to log when dir is empty
+type logMailer struct {
+	dir string
+}
+
+func (m *logMailer) Send(to, subject, body string) error {
+	if len(m.dir) < 1 {
+		log.Printf("Mail to %v: %v\n%v", to, subject, body)
+		return nil
+	}
+
+	name := fmt.Sprintf("%v-%v.eml", time.Now().UnixNano(), strings.Replace(to, "/", "_", -1))
+
+	return ioutil.WriteFile(filepath.Join(m.dir, name), composeMail(MAILFROM, to, subject, body), 0600)
+}
+
+func composeMail(from, to, subject, body string) []byte {
+	// Header values come from users, line breaks would add headers
+	header := strings.NewReplacer("\r", "", "\n", "")
+
+	return []byte("From: " + header.Replace(from) + "\r\n" +
+		"To: " + header.Replace(to) + "\r\n" +
+		"Subject: " + header.Replace(subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.Replace(body, "\n", "\r\n", -1))
+}
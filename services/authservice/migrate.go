@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+)
+
+// migrate func
+// Creates indexes on start up, idempotent.
+// Failures are logged only, service keeps running without them
+func migrate(ctx context.Context) {
+	// Identity logs in one user only, also when two logins make accounts at once
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"identities.provider", 1}, {"identities.subject", 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.D{{"identities.subject", bson.D{{"$exists", true}}}}),
+	})
+	if err != nil {
+		log.Printf("Migration failed: identities index\n%v", err)
+	}
+
+	// Accounts made before email verification keep public uploads and share links,
+	// new accounts always store emailVerified
+	result, err := col.UpdateMany(ctx, bson.D{{"emailVerified", bson.D{{"$exists", false}}}}, bson.D{{"$set", bson.D{{"emailVerified", true}}}})
+	if err != nil {
+		log.Printf("Migration failed: emailVerified of existing accounts\n%v", err)
+	} else if result.ModifiedCount > 0 {
+		log.Printf("Migration: %v existing accounts marked verified\n", result.ModifiedCount)
+	}
+
+	// Expired tokens and login states are deleted by DB
+	for _, c := range []*mongo.Collection{tokenCol(), oidcStateCol()} {
+		_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{"expiresAt", 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			log.Printf("Migration failed: %v expiry index\n%v", c.Name(), err)
+		}
+	}
+
+	// Worker looks for due deletions
+	_, err = deletionCol().Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{"deleteAt", 1}}})
+	if err != nil {
+		log.Printf("Migration failed: accountDeletions index\n%v", err)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Users log in with accounts of OpenID Connect providers.
+// Authorization code flow with PKCE is used, ID token of provider
+// is verified with keys provider publishes and its subject
+// is identity of user linked to account
+
+const (
+	// oidcKeyRefresh is least interval of fetching keys for unknown key ID
+	oidcKeyRefresh = time.Minute
+	// oidcLeeway is clock difference allowed to provider
+	oidcLeeway = time.Minute
+)
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider is OpenID Connect provider users may log in with
+type oidcProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	// formPost asks provider to post result to redirect URI, Apple needs it for email scope
+	formPost bool
+	// secretFunc makes client secret per request when set, Apple signs it
+	secretFunc func() (string, error)
+
+	mu          sync.Mutex
+	config      *oidcConfig
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// oidcConfig is discovery document of provider
+type oidcConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// idClaims are claims of ID token used for login
+type idClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	Nonce     string   `json:"nonce"`
+	Email     string   `json:"email"`
+	// EmailVerified is bool, Apple gives string
+	EmailVerified interface{} `json:"email_verified"`
+}
+
+// audience is aud claim, string or array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+
+	*a = multiple
+	return nil
+}
+
+func (c *idClaims) Valid() error {
+	now := time.Now()
+
+	if c.ExpiresAt == 0 || now.Add(-oidcLeeway).Unix() > c.ExpiresAt {
+		return errors.New("token expired")
+	}
+
+	if c.IssuedAt > now.Add(oidcLeeway).Unix() {
+		return errors.New("token used before issued")
+	}
+
+	return nil
+}
+
+func (c *idClaims) emailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+
+	return false
+}
+
+var oidcProviders = map[string]*oidcProvider{}
+
+// discover gives discovery document of provider, fetched once
+func (p *oidcProvider) discover() (*oidcConfig, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config != nil {
+		return p.config, nil
+	}
+
+	config := &oidcConfig{}
+	if err := getJSON(strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", config); err != nil {
+		return nil, fmt.Errorf("discovery of %v: %v", p.name, err)
+	}
+
+	if config.Issuer != p.issuer {
+		return nil, fmt.Errorf("discovery of %v: issuer %v does not match", p.name, config.Issuer)
+	}
+
+	p.config = config
+	return config, nil
+}
+
+// authorizationURL gives URL of provider's login page
+func (p *oidcProvider) authorizationURL(redirectURI, state, nonce, codeVerifier string) (string, error) {
+	config, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.clientID)
+	v.Set("redirect_uri", redirectURI)
+	v.Set("scope", strings.Join(p.scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+	if p.formPost {
+		v.Set("response_mode", "form_post")
+	}
+
+	separator := "?"
+	if strings.Contains(config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return config.AuthorizationEndpoint + separator + v.Encode(), nil
+}
+
+// exchange trades authorization code for verified ID token claims
+func (p *oidcProvider) exchange(code, redirectURI, codeVerifier, nonce string) (*idClaims, error) {
+	config, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	secret := p.clientSecret
+	if p.secretFunc != nil {
+		if secret, err = p.secretFunc(); err != nil {
+			return nil, err
+		}
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", p.clientID)
+	form.Set("code_verifier", codeVerifier)
+	if len(secret) > 0 {
+		form.Set("client_secret", secret)
+	}
+
+	resp, err := oidcClient.PostForm(config.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint of %v replied %v: %s", p.name, resp.StatusCode, body)
+	}
+
+	token := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+
+	if len(token.IDToken) < 1 {
+		return nil, fmt.Errorf("token endpoint of %v gave no ID token", p.name)
+	}
+
+	return p.verify(token.IDToken, nonce)
+}
+
+// verify checks signature, issuer, audience, expiry and nonce of ID token
+func (p *oidcProvider) verify(idToken, nonce string) (*idClaims, error) {
+	claims := &idClaims{}
+
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		// Algorithm is fixed by key type, so token cannot choose HMAC with public key
+		if t.Method != jwt.SigningMethodRS256 && t.Method != jwt.SigningMethodES256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+
+		kid, _ := t.Header["kid"].(string)
+
+		key, err := p.key(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if t.Method != jwt.SigningMethodRS256 {
+				return nil, errors.New("signing method does not match key")
+			}
+		case *ecdsa.PublicKey:
+			if t.Method != jwt.SigningMethodES256 {
+				return nil, errors.New("signing method does not match key")
+			}
+		}
+
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != p.issuer {
+		return nil, fmt.Errorf("issuer %v does not match", claims.Issuer)
+	}
+
+	matched := false
+	for _, aud := range claims.Audience {
+		matched = matched || aud == p.clientID
+	}
+	if !matched {
+		return nil, errors.New("audience does not match")
+	}
+
+	if len(claims.Subject) < 1 || claims.Nonce != nonce {
+		return nil, errors.New("subject missing or nonce does not match")
+	}
+
+	return claims, nil
+}
+
+// key gives public key of provider by key ID, keys are fetched again for unknown ID
+func (p *oidcProvider) key(kid string) (interface{}, error) {
+	config, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, exists := p.keys[kid]; exists {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetched) < oidcKeyRefresh {
+		return nil, fmt.Errorf("unknown key %v", kid)
+	}
+
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := getJSON(config.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("keys of %v: %v", p.name, err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if key := jwk.publicKey(); key != nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key, exists := p.keys[kid]; exists {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key %v", kid)
+}
+
+// jsonWebKey is RSA or P-256 key of JWKS
+type jsonWebKey struct {
+	KeyID string `json:"kid"`
+	Type  string `json:"kty"`
+	Use   string `json:"use"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// publicKey gives signing key, nil for other keys
+func (k *jsonWebKey) publicKey() interface{} {
+	if len(k.Use) > 0 && k.Use != "sig" {
+		return nil
+	}
+
+	switch k.Type {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			return nil
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil
+		}
+
+		return key
+	}
+
+	return nil
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v replied %v", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// appleSecret makes client secret function of Sign in with Apple,
+// JWT signed with private key(.p8) of Apple developer account
+func appleSecret(teamID, keyID, clientID, keyFile string) (func() (string, error), error) {
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("apple key is not PEM")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apple key is not ECDSA")
+	}
+
+	return func() (string, error) {
+		now := time.Now()
+
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.StandardClaims{
+			Issuer:    teamID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(5 * time.Minute).Unix(),
+			Audience:  "https://appleid.apple.com",
+			Subject:   clientID,
+		})
+		token.Header["kid"] = keyID
+
+		return token.SignedString(key)
+	}, nil
+}
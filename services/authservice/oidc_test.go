@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	testClientID = "farerpath-test"
+	testNonce    = "nonce"
+	testVerifier = "verifier"
+)
+
+// testIssuer is local OpenID Connect provider publishing RSA and P-256 keys
+type testIssuer struct {
+	server     *httptest.Server
+	rsaKey     *rsa.PrivateKey
+	ecKey      *ecdsa.PrivateKey
+	keyFetches int32
+	// idToken is replied by token endpoint
+	idToken string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcConfig{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&issuer.keyFetches, 1)
+
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {
+			{KeyID: "rsa", Type: "RSA", Use: "sig", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{KeyID: "ec", Type: "EC", Use: "sig", Curve: "P-256", X: b64(ecKey.X.Bytes()), Y: b64(ecKey.Y.Bytes())},
+			{KeyID: "enc", Type: "RSA", Use: "enc", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "code" || r.PostFormValue("code_verifier") != testVerifier || r.PostFormValue("client_id") != testClientID {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+
+	return issuer
+}
+
+func (i *testIssuer) provider() *oidcProvider {
+	return &oidcProvider{name: "test", issuer: i.server.URL, clientID: testClientID}
+}
+
+func (i *testIssuer) claims() jwt.MapClaims {
+	now := time.Now()
+
+	return jwt.MapClaims{
+		"iss":   i.server.URL,
+		"sub":   "subject",
+		"aud":   testClientID,
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": testNonce,
+		"email": "user@example.com",
+	}
+}
+
+func (i *testIssuer) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) string {
+	token := jwt.NewWithClaims(method, claims)
+	if len(kid) > 0 {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestOIDCVerify(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	publicPEM, err := x509.MarshalPKIXPublicKey(&issuer.rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicPEM})
+
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := issuer.claims()
+		claims[key] = value
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", issuer.sign(t, jwt.SigningMethodRS256, "rsa", issuer.claims(), issuer.rsaKey), true},
+		{"ES256", issuer.sign(t, jwt.SigningMethodES256, "ec", issuer.claims(), issuer.ecKey), true},
+		{"audience in list", issuer.sign(t, jwt.SigningMethodRS256, "rsa", with("aud", []string{"other", testClientID}), issuer.rsaKey), true},
+		{"bad audience", issuer.sign(t, jwt.SigningMethodRS256, "rsa", with("aud", "other"), issuer.rsaKey), false},
+		{"bad nonce", issuer.sign(t, jwt.SigningMethodRS256, "rsa", with("nonce", "other"), issuer.rsaKey), false},
+		{"no nonce", issuer.sign(t, jwt.SigningMethodRS256, "rsa", with("nonce", ""), issuer.rsaKey), false},
+		{"bad issuer", issuer.sign(t, jwt.SigningMethodRS256, "rsa", with("iss", "https://attacker.example"), issuer.rsaKey), false},
+		{"no subject", issuer.sign(t, jwt.SigningMethodRS256, "rsa", with("sub", ""), issuer.rsaKey), false},
+		{"expired", issuer.sign(t, jwt.SigningMethodRS256, "rsa", with("exp", time.Now().Add(-time.Hour).Unix()), issuer.rsaKey), false},
+		// Public key must not be taken as HMAC secret
+		{"alg swap to HS256", issuer.sign(t, jwt.SigningMethodHS256, "rsa", issuer.claims(), publicPEM), false},
+		{"alg none", issuer.sign(t, jwt.SigningMethodNone, "rsa", issuer.claims(), jwt.UnsafeAllowNoneSignatureType), false},
+		{"ES256 with RSA key ID", issuer.sign(t, jwt.SigningMethodES256, "rsa", issuer.claims(), issuer.ecKey), false},
+		{"RS256 with EC key ID", issuer.sign(t, jwt.SigningMethodRS256, "ec", issuer.claims(), issuer.rsaKey), false},
+		{"encryption key", issuer.sign(t, jwt.SigningMethodRS256, "enc", issuer.claims(), issuer.rsaKey), false},
+		{"unknown key ID", issuer.sign(t, jwt.SigningMethodRS256, "unknown", issuer.claims(), issuer.rsaKey), false},
+		{"no key ID", issuer.sign(t, jwt.SigningMethodRS256, "", issuer.claims(), issuer.rsaKey), false},
+	}
+
+	p := issuer.provider()
+
+	for _, tt := range tests {
+		claims, err := p.verify(tt.token, testNonce)
+		if tt.ok && err != nil {
+			t.Errorf("%v: verify failed: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%v: verify accepted token of %v", tt.name, claims.Subject)
+		}
+	}
+
+	// Unknown key IDs do not make provider fetch keys on every token
+	if n := atomic.LoadInt32(&issuer.keyFetches); n != 1 {
+		t.Errorf("keys fetched %v times, want 1", n)
+	}
+}
+
+func TestOIDCUnknownKeyRefetch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	p := issuer.provider()
+
+	// Keys fetched before refresh interval are fetched again for unknown key ID, as provider rotated keys
+	p.keys = map[string]interface{}{}
+	p.keysFetched = time.Now().Add(-2 * oidcKeyRefresh)
+
+	token := issuer.sign(t, jwt.SigningMethodRS256, "rsa", issuer.claims(), issuer.rsaKey)
+	if _, err := p.verify(token, testNonce); err != nil {
+		t.Fatalf("verify after key rotation failed: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&issuer.keyFetches); n != 1 {
+		t.Errorf("keys fetched %v times, want 1", n)
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	p := issuer.provider()
+
+	issuer.idToken = issuer.sign(t, jwt.SigningMethodRS256, "rsa", issuer.claims(), issuer.rsaKey)
+
+	claims, err := p.exchange("code", "https://farerpath.test/callback", testVerifier, testNonce)
+	if err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	if claims.Subject != "subject" || claims.Email != "user@example.com" {
+		t.Errorf("exchange gave claims %+v", claims)
+	}
+
+	if _, err := p.exchange("code", "https://farerpath.test/callback", "other verifier", testNonce); err == nil {
+		t.Error("exchange accepted refused code")
+	}
+
+	if _, err := p.exchange("code", "https://farerpath.test/callback", testVerifier, "other nonce"); err == nil {
+		t.Error("exchange accepted ID token of other nonce")
+	}
+
+	issuer.idToken = ""
+	if _, err := p.exchange("code", "https://farerpath.test/callback", testVerifier, testNonce); err == nil {
+		t.Error("exchange accepted reply without ID token")
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	p := issuer.provider()
+	p.issuer = issuer.server.URL + "/"
+
+	if _, err := p.discover(); err == nil {
+		t.Error("discovery accepted document of other issuer")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
+)
+
+// Passwords are stored as PHC strings of argon2id,
+// $argon2id$v=19$m=65536,t=3,p=2$salt$hash with unpadded base64 salt and hash.
+// Password is keyed with PEPPER before hashing, so DB alone cannot be cracked.
+// PEPPER is required at startup, as PHC string does not record it.
+// PEPPER cannot change without reset of passwords hashed with it.
+// Legacy hashes are bcrypt of SALT, user ID and password,
+// they are verified and replaced with argon2id at login
+
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 2
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+var errUnknownHash = errors.New("unknown password hash format")
+
+type argonParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+var currentArgonParams = argonParams{memory: argonMemory, time: argonTime, threads: argonThreads}
+
+// hashPassword gives PHC string of password with current parameters
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := currentArgonParams
+	key := argon2.IDKey(pepper(password), salt, p.time, p.memory, p.threads, argonKeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword tells whether password matches hash of user,
+// rehash is set when hash is legacy or of older parameters
+func verifyPassword(hash, userID, password string) (match bool, rehash bool, err error) {
+	if len(hash) < 1 {
+		// Users logging in only with identity providers have no password
+		return false, false, nil
+	}
+
+	if strings.HasPrefix(hash, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(SALT+userID+password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	}
+
+	p, salt, key, err := parseArgonHash(hash)
+	if err != nil {
+		return false, false, err
+	}
+
+	computed := argon2.IDKey(pepper(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false, nil
+	}
+
+	return true, p != currentArgonParams || len(key) != argonKeyLen, nil
+}
+
+// rehashPassword replaces hash of user with current one after login,
+// unless password was changed meanwhile
+func rehashPassword(ctx context.Context, userID, oldHash, password string) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("password hashing failed\n %v", err)
+		return
+	}
+
+	_, err = col.UpdateOne(ctx, bson.D{{"_id", userID}, {"password", oldHash}}, bson.D{{"$set", bson.D{{"password", hash}}}})
+	if err != nil {
+		log.Printf("Error accured: Login(Rehash)\n%v", err)
+	}
+}
+
+func parseArgonHash(hash string) (argonParams, []byte, []byte, error) {
+	p := argonParams{}
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	fields := strings.Split(hash, "$")
+	if len(fields) != 6 || fields[1] != "argon2id" {
+		return p, nil, nil, errUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errUnknownHash
+	}
+
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil || p.time < 1 || p.threads < 1 {
+		return p, nil, nil, errUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return p, nil, nil, errUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(key) < 16 {
+		return p, nil, nil, errUnknownHash
+	}
+
+	return p, salt, key, nil
+}
+
+// pepper keys password with PEPPER
+func pepper(password string) []byte {
+	mac := hmac.New(sha256.New, []byte(PEPPER))
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
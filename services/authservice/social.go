@@ -0,0 +1,382 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	pb "github.com/farerpath/authservice/proto"
+
+	"github.com/farerpath/server/model/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Login with identity providers.
+// Identity logs in account it is linked to. Unknown identity is linked
+// to account of same email when both provider and account verified it,
+// otherwise new account is made for it
+
+const oidcStateLifetime = 10 * time.Minute
+
+// oidcState model
+// Login waiting for user to come back from provider
+// farerpath.oidcStates
+type oidcState struct {
+	// StateHash is hex SHA-256 of state
+	StateHash    string `bson:"_id"`
+	Provider     string `bson:"provider"`
+	CodeVerifier string `bson:"codeVerifier"`
+	Nonce        string `bson:"nonce"`
+	// UserID is user identity is linked to, empty for login
+	UserID    string    `bson:"userID,omitempty"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+var errIdentityTaken = errors.New("identity linked to other user")
+
+func oidcStateCol() *mongo.Collection {
+	return col.Database().Collection("oidcStates")
+}
+
+// setupOIDCProviders makes providers configured by env
+func setupOIDCProviders() {
+	scopes := []string{"openid", "email", "profile"}
+
+	if len(GOOGLECLIENTID) > 0 {
+		oidcProviders["google"] = &oidcProvider{
+			name:         "google",
+			issuer:       "https://accounts.google.com",
+			clientID:     GOOGLECLIENTID,
+			clientSecret: GOOGLECLIENTSECRET,
+			scopes:       scopes,
+		}
+	}
+
+	if len(APPLECLIENTID) > 0 {
+		secretFunc, err := appleSecret(APPLETEAMID, APPLEKEYID, APPLECLIENTID, APPLEKEYFILE)
+		if err != nil {
+			log.Printf("Sign in with Apple disabled, key not loaded\n%v", err)
+		} else {
+			oidcProviders["apple"] = &oidcProvider{
+				name:       "apple",
+				issuer:     "https://appleid.apple.com",
+				clientID:   APPLECLIENTID,
+				scopes:     []string{"openid", "email", "name"},
+				formPost:   true,
+				secretFunc: secretFunc,
+			}
+		}
+	}
+
+	if len(OIDCISSUER) > 0 && len(OIDCCLIENTID) > 0 {
+		oidcProviders[OIDCNAME] = &oidcProvider{
+			name:         OIDCNAME,
+			issuer:       OIDCISSUER,
+			clientID:     OIDCCLIENTID,
+			clientSecret: OIDCCLIENTSECRET,
+			scopes:       scopes,
+		}
+	}
+
+	for name := range oidcProviders {
+		log.Printf("Login with %v enabled\n", name)
+	}
+}
+
+// StartOIDC func
+// Gives login page of provider, state of login is kept until FinishOIDC
+func (a *authServer) StartOIDC(ctx context.Context, req *pb.StartOIDCRequest) (*pb.StartOIDCReply, error) {
+	provider, exists := oidcProviders[req.GetProvider()]
+	if !exists {
+		return &pb.StartOIDCReply{}, status.Error(codes.NotFound, "provider not found")
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		log.Printf("Error accured: StartOIDC\n%v", err)
+		return &pb.StartOIDCReply{}, status.Error(codes.Internal, err.Error())
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		log.Printf("Error accured: StartOIDC\n%v", err)
+		return &pb.StartOIDCReply{}, status.Error(codes.Internal, err.Error())
+	}
+	verifier, err := randomToken()
+	if err != nil {
+		log.Printf("Error accured: StartOIDC\n%v", err)
+		return &pb.StartOIDCReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	authURL, err := provider.authorizationURL(OIDCREDIRECTURL, state, nonce, verifier)
+	if err != nil {
+		log.Printf("Error accured: StartOIDC(Discovery)\n%v", err)
+		return &pb.StartOIDCReply{}, status.Error(codes.Unavailable, err.Error())
+	}
+
+	_, err = oidcStateCol().InsertOne(ctx, &oidcState{
+		StateHash:    hashToken(state),
+		Provider:     provider.name,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		UserID:       req.GetUserID(),
+		ExpiresAt:    time.Now().Add(oidcStateLifetime).UTC(),
+	})
+	if err != nil {
+		log.Printf("Error accured: StartOIDC\n%v", err)
+		return &pb.StartOIDCReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.StartOIDCReply{AuthorizationURL: authURL, State: state}, nil
+}
+
+// FinishOIDC func
+// Verifies identity with code from provider,
+// then links it to user of StartOIDC or logs in with it
+func (a *authServer) FinishOIDC(ctx context.Context, req *pb.FinishOIDCRequest) (*pb.FinishOIDCReply, error) {
+	state := &oidcState{}
+
+	err := oidcStateCol().FindOneAndDelete(ctx, bson.D{
+		{"_id", hashToken(req.GetState())},
+		{"provider", req.GetProvider()},
+		{"expiresAt", bson.D{{"$gt", time.Now().UTC()}}},
+	}).Decode(state)
+	if err == mongo.ErrNoDocuments {
+		return &pb.FinishOIDCReply{}, status.Error(codes.NotFound, "state not found or expired")
+	}
+	if err != nil {
+		log.Printf("Error accured: FinishOIDC(FindState)\n%v", err)
+		return &pb.FinishOIDCReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	provider, exists := oidcProviders[state.Provider]
+	if !exists {
+		return &pb.FinishOIDCReply{}, status.Error(codes.NotFound, "provider not found")
+	}
+
+	claims, err := provider.exchange(req.GetCode(), OIDCREDIRECTURL, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Printf("Failed to verify identity of %v\n%v", provider.name, err)
+		return &pb.FinishOIDCReply{}, status.Error(codes.Unauthenticated, "identity not verified")
+	}
+
+	identity := model.Identity{
+		Provider: provider.name,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		LinkedAt: time.Now().UTC(),
+	}
+
+	linked := &model.Account{}
+
+	err = col.FindOne(ctx, bson.D{{"identities", bson.D{{"$elemMatch", bson.D{{"provider", identity.Provider}, {"subject", identity.Subject}}}}}}).Decode(linked)
+	if err == mongo.ErrNoDocuments {
+		linked = nil
+	} else if err != nil {
+		log.Printf("Error accured: FinishOIDC(FindIdentity)\n%v", err)
+		return &pb.FinishOIDCReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	if len(state.UserID) > 0 {
+		if linked != nil {
+			if linked.UserID != state.UserID {
+				return &pb.FinishOIDCReply{}, status.Error(codes.AlreadyExists, errIdentityTaken.Error())
+			}
+			return &pb.FinishOIDCReply{Linked: true}, nil
+		}
+
+		if err := linkIdentity(ctx, state.UserID, identity); err != nil {
+			return &pb.FinishOIDCReply{}, err
+		}
+		return &pb.FinishOIDCReply{Linked: true}, nil
+	}
+
+	created := false
+
+	if linked == nil {
+		linked, created, err = accountOfIdentity(ctx, identity, claims.emailVerified())
+		if err != nil {
+			return &pb.FinishOIDCReply{}, err
+		}
+	}
+
+	resp, err := login(ctx, linked, req.GetLoginDeviceType(), req.GetLoginIP())
+	if err != nil {
+		return &pb.FinishOIDCReply{}, err
+	}
+
+	return &pb.FinishOIDCReply{Login: resp, Created: created}, nil
+}
+
+// linkIdentity adds identity to user, one identity of each provider
+func linkIdentity(ctx context.Context, userID string, identity model.Identity) error {
+	result, err := col.UpdateOne(ctx,
+		bson.D{{"_id", userID}, {"identities.provider", bson.D{{"$ne", identity.Provider}}}},
+		bson.D{{"$push", bson.D{{"identities", identity}}}})
+	if err != nil {
+		log.Printf("Error accured: LinkIdentity\n%v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if result.MatchedCount == 0 {
+		n, err := col.CountDocuments(ctx, bson.D{{"_id", userID}})
+		if err != nil {
+			log.Printf("Error accured: LinkIdentity\n%v", err)
+			return status.Error(codes.Unknown, err.Error())
+		}
+		if n == 0 {
+			return status.Error(codes.NotFound, "user not found")
+		}
+		return status.Error(codes.AlreadyExists, fmt.Sprintf("%v already linked", identity.Provider))
+	}
+
+	return nil
+}
+
+// accountOfIdentity gives account for identity linked to no user,
+// account of same verified email or new account
+func accountOfIdentity(ctx context.Context, identity model.Identity, emailVerified bool) (*model.Account, bool, error) {
+	if len(identity.Email) > 0 {
+		user := &model.Account{}
+
+		err := col.FindOne(ctx, bson.D{{"email", identity.Email}}).Decode(user)
+		if err == nil {
+			// Unverified email on either side may belong to someone else,
+			// user logs in with password and links identity then
+			if !emailVerified || !user.EmailVerified {
+				return nil, false, status.Error(codes.AlreadyExists, "Email already exists")
+			}
+
+			if err := linkIdentity(ctx, user.UserID, identity); err != nil {
+				return nil, false, err
+			}
+			return user, false, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Error accured: FinishOIDC(FindEmail)\n%v", err)
+			return nil, false, status.Error(codes.Unknown, err.Error())
+		}
+	}
+
+	userID, err := freeUserID(ctx, identity)
+	if err != nil {
+		log.Printf("Error accured: FinishOIDC(UserID)\n%v", err)
+		return nil, false, status.Error(codes.Internal, err.Error())
+	}
+
+	// No password, user logs in with identity or sets password by reset
+	user := newAccount(userID, identity.Email, "")
+	user.EmailVerified = emailVerified
+	user.Identities = []model.Identity{identity}
+
+	if _, err := col.InsertOne(ctx, user); err != nil {
+		log.Printf("Error accured: FinishOIDC(Register)\n%v", err)
+		return nil, false, status.Error(codes.Internal, err.Error())
+	}
+
+	if len(user.Email) > 0 && !user.EmailVerified {
+		if err := sendVerification(ctx, user.UserID, user.Email); err != nil {
+			log.Printf("Error accured: FinishOIDC(SendVerification)\n%v", err)
+		}
+	}
+
+	return user, true, nil
+}
+
+var notUserIDChars = regexp.MustCompile("[^a-zA-Z0-9]")
+
+// freeUserID gives user ID not taken, made from email of identity
+func freeUserID(ctx context.Context, identity model.Identity) (string, error) {
+	base := notUserIDChars.ReplaceAllString(strings.Split(identity.Email, "@")[0], "")
+	if len(base) > 16 {
+		base = base[:16]
+	}
+	if len(base) < 4 {
+		base = "traveller"
+	}
+
+	userID := base
+	for i := 0; i < 10; i++ {
+		taken, err := userIDTaken(ctx, userID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return userID, nil
+		}
+
+		userID = fmt.Sprintf("%v%04d", base, rand.Intn(10000))
+	}
+
+	return "", errors.New("no free user ID")
+}
+
+// GetIdentities func
+// Gives identities linked to user
+func (a *authServer) GetIdentities(ctx context.Context, req *pb.GetIdentitiesRequest) (*pb.GetIdentitiesReply, error) {
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"_id", req.GetUserID()}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.GetIdentitiesReply{}, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("Error accured: GetIdentities(FindUser)\n%v", err)
+		return &pb.GetIdentitiesReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	reply := &pb.GetIdentitiesReply{HasPassword: len(user.Password) > 0}
+
+	for _, identity := range user.Identities {
+		reply.Identity = append(reply.Identity, &pb.Identity{
+			Provider: identity.Provider,
+			Email:    identity.Email,
+			LinkedAt: identity.LinkedAt.Unix(),
+		})
+	}
+
+	return reply, nil
+}
+
+// UnlinkIdentity func
+// Removes identity of provider from user, user keeps some way to log in
+func (a *authServer) UnlinkIdentity(ctx context.Context, req *pb.UnlinkIdentityRequest) (*pb.UnlinkIdentityReply, error) {
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"_id", req.GetUserID()}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.UnlinkIdentityReply{}, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("Error accured: UnlinkIdentity(FindUser)\n%v", err)
+		return &pb.UnlinkIdentityReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	found := false
+	for _, identity := range user.Identities {
+		found = found || identity.Provider == req.GetProvider()
+	}
+	if !found {
+		return &pb.UnlinkIdentityReply{}, status.Error(codes.NotFound, "identity not found")
+	}
+
+	if len(user.Password) < 1 && len(user.Identities) < 2 {
+		return &pb.UnlinkIdentityReply{}, status.Error(codes.FailedPrecondition, "last way to log in")
+	}
+
+	_, err = col.UpdateOne(ctx, bson.D{{"_id", req.GetUserID()}}, bson.D{{"$pull", bson.D{{"identities", bson.D{{"provider", req.GetProvider()}}}}}})
+	if err != nil {
+		log.Printf("Error accured: UnlinkIdentity\n%v", err)
+		return &pb.UnlinkIdentityReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.UnlinkIdentityReply{}, nil
+}
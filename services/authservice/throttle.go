@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Failed logins are counted in redis per account and per IP.
+// After free attempts each failure makes next attempt wait twice as long,
+// at lockout attempts account or IP is locked and lockout is audited.
+// Counters are kept until no failure for failureWindow
+
+type throttlePolicy struct {
+	scope         string
+	freeAttempts  int64
+	lockoutAfter  int64
+	lockoutPeriod time.Duration
+}
+
+var (
+	// IP is shared by users behind NAT, so it is allowed more
+	accountPolicy = throttlePolicy{scope: "user", freeAttempts: 3, lockoutAfter: 10, lockoutPeriod: 15 * time.Minute}
+	ipPolicy      = throttlePolicy{scope: "ip", freeAttempts: 10, lockoutAfter: 20, lockoutPeriod: 30 * time.Minute}
+)
+
+const (
+	failureWindow = time.Hour
+	backoffBase   = time.Second
+)
+
+// retryAfterKey : trailer of ResourceExhausted errors, seconds to wait
+const retryAfterKey = "retry-after"
+
+var rClient *redis.Client
+
+// loginAudit model
+// farerpath.auditLog
+type loginAudit struct {
+	Event    string    `bson:"event"`
+	Scope    string    `bson:"scope"`
+	Subject  string    `bson:"subject"`
+	Failures int64     `bson:"failures"`
+	Until    time.Time `bson:"until"`
+	At       time.Time `bson:"at"`
+}
+
+func auditCol() *mongo.Collection {
+	return col.Database().Collection("auditLog")
+}
+
+func failureKey(scope, subject string) string {
+	return "loginFailures:" + scope + ":" + subject
+}
+
+func lockKey(scope, subject string) string {
+	return "loginLock:" + scope + ":" + subject
+}
+
+// backoff gives how long login waits after failures, lockout period from lockout attempts
+func (p throttlePolicy) backoff(failures int64) time.Duration {
+	if failures <= p.freeAttempts {
+		return 0
+	}
+
+	if failures >= p.lockoutAfter {
+		return p.lockoutPeriod
+	}
+
+	wait := backoffBase
+	for i := p.freeAttempts + 1; i < failures && wait < p.lockoutPeriod; i++ {
+		wait *= 2
+	}
+
+	if wait > p.lockoutPeriod {
+		return p.lockoutPeriod
+	}
+
+	return wait
+}
+
+// checkThrottle gives ResourceExhausted error when user or IP must wait,
+// retry-after trailer is set then
+func checkThrottle(ctx context.Context, userID, ip string) error {
+	var wait time.Duration
+
+	for _, lock := range []string{lockKey(accountPolicy.scope, userID), lockKey(ipPolicy.scope, ip)} {
+		ttl, err := rClient.PTTL(lock).Result()
+		if err != nil {
+			// Login is not refused when redis is down
+			log.Printf("Redis TTL failed,\n%v", err)
+			return nil
+		}
+
+		if ttl > wait {
+			wait = ttl
+		}
+	}
+
+	if wait <= 0 {
+		return nil
+	}
+
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	grpc.SetTrailer(ctx, metadata.Pairs(retryAfterKey, strconv.FormatInt(seconds, 10)))
+
+	return status.Error(codes.ResourceExhausted, fmt.Sprintf("too many failed logins, retry after %v seconds", seconds))
+}
+
+// recordFailure counts failed login of user from IP
+func recordFailure(ctx context.Context, userID, ip string) {
+	recordPolicyFailure(ctx, accountPolicy, userID)
+	if len(ip) > 0 {
+		recordPolicyFailure(ctx, ipPolicy, ip)
+	}
+}
+
+func recordPolicyFailure(ctx context.Context, p throttlePolicy, subject string) {
+	key := failureKey(p.scope, subject)
+
+	failures, err := rClient.Incr(key).Result()
+	if err != nil {
+		log.Printf("Redis incr failed,\n%v", err)
+		return
+	}
+	rClient.Expire(key, failureWindow)
+
+	wait := p.backoff(failures)
+	if wait <= 0 {
+		return
+	}
+
+	if err := rClient.Set(lockKey(p.scope, subject), failures, wait).Err(); err != nil {
+		log.Printf("Redis set failed,\n%v", err)
+	}
+
+	if failures < p.lockoutAfter {
+		return
+	}
+
+	audit := &loginAudit{
+		Event:    "lockout",
+		Scope:    p.scope,
+		Subject:  subject,
+		Failures: failures,
+		Until:    time.Now().Add(wait).UTC(),
+		At:       time.Now().UTC(),
+	}
+
+	log.Printf("Login of %v %v locked until %v after %v failures\n", p.scope, subject, audit.Until, failures)
+
+	if _, err := auditCol().InsertOne(ctx, audit); err != nil {
+		log.Printf("Error accured: Audit lockout\n%v", err)
+	}
+}
+
+// clearFailures forgets failures of user after login,
+// failures of IP are kept as IP may try other users
+func clearFailures(userID string) {
+	if err := rClient.Del(failureKey(accountPolicy.scope, userID), lockKey(accountPolicy.scope, userID)).Err(); err != nil {
+		log.Printf("Redis del failed,\n%v", err)
+	}
+}
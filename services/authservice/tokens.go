@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	pb "github.com/farerpath/authservice/proto"
+
+	"github.com/farerpath/server/model/model"
+
+	psession "github.com/farerpath/sessionservice/proto"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Email verification and password reset tokens are mailed to users as links,
+// login tickets wait for second factor of login.
+// Only hashes of tokens are kept in DB, each token is used once
+// and newer mailed token of same purpose replaces older one
+
+const (
+	purposeVerify = "verify"
+	purposeReset  = "reset"
+	purposeMFA    = "mfa"
+)
+
+const (
+	verifyTokenLifetime = 24 * time.Hour
+	resetTokenLifetime  = time.Hour
+)
+
+// accountToken model
+// farerpath.accountTokens
+type accountToken struct {
+	// TokenHash is hex SHA-256 of token
+	TokenHash string `bson:"_id"`
+	UserID    string `bson:"userID"`
+	Purpose   string `bson:"purpose"`
+	// Email is address token was sent to
+	Email     string    `bson:"email"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+
+	// Login of login ticket
+	DeviceType int32  `bson:"deviceType,omitempty"`
+	LoginIP    string `bson:"loginIP,omitempty"`
+	// Attempts is codes given for login ticket
+	Attempts int `bson:"attempts,omitempty"`
+}
+
+var errTokenNotFound = errors.New("token not found or expired")
+
+func tokenCol() *mongo.Collection {
+	return col.Database().Collection("accountTokens")
+}
+
+// randomToken gives 256 bit random hex string
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueToken stores new token of purpose for user, older ones are dropped
+func issueToken(ctx context.Context, userID, email, purpose string, lifetime time.Duration) (string, error) {
+	_, err := tokenCol().DeleteMany(ctx, bson.D{{"userID", userID}, {"purpose", purpose}})
+	if err != nil {
+		return "", err
+	}
+
+	return storeToken(ctx, &accountToken{UserID: userID, Purpose: purpose, Email: email}, lifetime)
+}
+
+// storeToken stores new random token with fields of t
+func storeToken(ctx context.Context, t *accountToken, lifetime time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	t.TokenHash = hashToken(token)
+	t.ExpiresAt = time.Now().Add(lifetime).UTC()
+
+	if _, err := tokenCol().InsertOne(ctx, t); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeToken deletes unexpired token of purpose and gives it
+func consumeToken(ctx context.Context, token, purpose string) (*accountToken, error) {
+	result := &accountToken{}
+
+	err := tokenCol().FindOneAndDelete(ctx, bson.D{
+		{"_id", hashToken(token)},
+		{"purpose", purpose},
+		{"expiresAt", bson.D{{"$gt", time.Now().UTC()}}},
+	}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return nil, errTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// tokenLink gives web page link carrying token
+func tokenLink(page, token string) string {
+	return fmt.Sprintf("%v/%v?token=%v", WEBURL, page, url.QueryEscape(token))
+}
+
+// sendVerification mails verification link to email of user
+func sendVerification(ctx context.Context, userID, email string) error {
+	token, err := issueToken(ctx, userID, email, purposeVerify, verifyTokenLifetime)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(email, "Verify your Farerpath email",
+		fmt.Sprintf("Hello %v,\n\nOpen the link below to verify your email address.\n\n%v\n\nThe link expires in 24 hours.\n",
+			userID, tokenLink("verify", token)))
+}
+
+// RequestEmailVerification func
+// Mails new verification link, earlier links stop working
+func (a *authServer) RequestEmailVerification(ctx context.Context, req *pb.RequestEmailVerificationRequest) (*pb.RequestEmailVerificationReply, error) {
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"_id", req.GetUserID()}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.RequestEmailVerificationReply{}, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("Error accured: RequestEmailVerification(FindUser)\n%v", err)
+		return &pb.RequestEmailVerificationReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	if user.EmailVerified {
+		return &pb.RequestEmailVerificationReply{}, status.Error(codes.FailedPrecondition, "email already verified")
+	}
+
+	if err := sendVerification(ctx, user.UserID, user.Email); err != nil {
+		log.Printf("Error accured: RequestEmailVerification\n%v", err)
+		return &pb.RequestEmailVerificationReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.RequestEmailVerificationReply{}, nil
+}
+
+// VerifyEmail func
+// Marks email verified when token was sent to current email of user
+func (a *authServer) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailReply, error) {
+	token, err := consumeToken(ctx, req.GetToken(), purposeVerify)
+	if err == errTokenNotFound {
+		return &pb.VerifyEmailReply{}, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error accured: VerifyEmail(ConsumeToken)\n%v", err)
+		return &pb.VerifyEmailReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	// Email changed after token was sent, so token does not prove new one
+	result, err := col.UpdateOne(ctx, bson.D{{"_id", token.UserID}, {"email", token.Email}}, bson.D{{"$set", bson.D{{"emailVerified", true}}}})
+	if err != nil {
+		log.Printf("Error accured: VerifyEmail\n%v", err)
+		return &pb.VerifyEmailReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.MatchedCount == 0 {
+		return &pb.VerifyEmailReply{}, status.Error(codes.NotFound, "token not found or expired")
+	}
+
+	return &pb.VerifyEmailReply{UserID: token.UserID}, nil
+}
+
+// RequestPasswordReset func
+// Mails reset link when email is registered, reply does not tell whether it is
+func (a *authServer) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetReply, error) {
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"email", req.GetEmail()}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.RequestPasswordResetReply{}, nil
+	}
+	if err != nil {
+		log.Printf("Error accured: RequestPasswordReset(FindUser)\n%v", err)
+		return &pb.RequestPasswordResetReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	token, err := issueToken(ctx, user.UserID, user.Email, purposeReset, resetTokenLifetime)
+	if err != nil {
+		log.Printf("Error accured: RequestPasswordReset(IssueToken)\n%v", err)
+		return &pb.RequestPasswordResetReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	err = mailer.Send(user.Email, "Reset your Farerpath password",
+		fmt.Sprintf("Hello %v,\n\nOpen the link below to set a new password.\n\n%v\n\nThe link expires in 1 hour. If you did not ask for this, ignore this mail.\n",
+			user.UserID, tokenLink("reset-password", token)))
+	// Failure is not replied, it would tell email is registered
+	if err != nil {
+		log.Printf("Error accured: RequestPasswordReset(SendMail)\n%v", err)
+	}
+
+	return &pb.RequestPasswordResetReply{}, nil
+}
+
+// ResetPassword func
+// Sets new password with reset token, all sessions of user are logged out
+func (a *authServer) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ResetPasswordReply, error) {
+	token, err := consumeToken(ctx, req.GetToken(), purposeReset)
+	if err == errTokenNotFound {
+		return &pb.ResetPasswordReply{}, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error accured: ResetPassword(ConsumeToken)\n%v", err)
+		return &pb.ResetPasswordReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	hashedPassword, err := hashPassword(req.GetNewPassword())
+	if err != nil {
+		log.Printf("password hashing failed\n %v", err)
+		return &pb.ResetPasswordReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	result, err := col.UpdateOne(ctx, bson.D{{"_id", token.UserID}}, bson.D{{"$set", bson.D{{"password", hashedPassword}}}})
+	if err != nil {
+		log.Printf("Error accured: ResetPassword\n%v", err)
+		return &pb.ResetPasswordReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.MatchedCount == 0 {
+		return &pb.ResetPasswordReply{}, status.Error(codes.NotFound, "user not found")
+	}
+
+	// Reset link reached the mailbox, so email is verified too
+	_, err = col.UpdateOne(ctx, bson.D{{"_id", token.UserID}, {"email", token.Email}}, bson.D{{"$set", bson.D{{"emailVerified", true}}}})
+	if err != nil {
+		log.Printf("Error accured: ResetPassword(VerifyEmail)\n%v", err)
+	}
+
+	// No session is kept, whoever knew old password is logged out
+	_, err = sclient.RevokeOtherSessions(ctx, &psession.SessionsRequest{UserID: token.UserID})
+	if err != nil {
+		log.Printf("Error accured: ResetPassword(RevokeOtherSessions)\n%v", err)
+		return &pb.ResetPasswordReply{}, status.Error(codes.Internal, "password reset, but sessions not logged out")
+	}
+
+	return &pb.ResetPasswordReply{UserID: token.UserID}, nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	pb "github.com/farerpath/authservice/proto"
+
+	"github.com/farerpath/server/model/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Users may turn on TOTP(RFC 6238) as second factor of login.
+// Login with password then gives short lived ticket instead of session,
+// session is made when ticket is given with code of authenticator app
+// or one of recovery codes
+
+const (
+	totpIssuer = "Farerpath"
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is time steps before and after now codes are accepted in
+	totpSkew = 1
+	// totpSecretSize is bytes, 160 bits as RFC 4226 recommends
+	totpSecretSize = 20
+
+	recoveryCodeCount = 10
+
+	mfaTicketLifetime = 5 * time.Minute
+	// maxMFAAttempts is codes allowed for one login ticket
+	maxMFAAttempts = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode gives code of secret at time step, RFC 4226 HOTP with SHA-1
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// matchTOTP gives time step code is of, 0 when code does not match
+func matchTOTP(secret, code string, now time.Time) int64 {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) == 0 || len(code) != totpDigits {
+		return 0
+	}
+
+	current := now.Unix() / totpPeriod
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+
+	return 0
+}
+
+func totpURI(userID, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+userID) + "?" + v.Encode()
+}
+
+// newRecoveryCodes gives recovery codes like abcde-fghij and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// useSecondFactor uses TOTP code or recovery code of user once,
+// false when code does not match or was used
+func useSecondFactor(ctx context.Context, user *model.Account, code string) (bool, error) {
+	if step := matchTOTP(user.TOTPSecret, strings.TrimSpace(code), time.Now()); step != 0 {
+		// Step is checked in update, same code at same time is used once
+		result, err := col.UpdateOne(ctx,
+			bson.D{{"_id", user.UserID}, {"totpLastStep", bson.D{{"$not", bson.D{{"$gte", step}}}}}},
+			bson.D{{"$set", bson.D{{"totpLastStep", step}}}})
+		if err != nil {
+			return false, err
+		}
+
+		return result.ModifiedCount == 1, nil
+	}
+
+	hash := hashRecoveryCode(code)
+
+	result, err := col.UpdateOne(ctx,
+		bson.D{{"_id", user.UserID}, {"recoveryCodes", hash}},
+		bson.D{{"$pull", bson.D{{"recoveryCodes", hash}}}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+// startMFALogin gives login ticket of user waiting for second factor
+func startMFALogin(ctx context.Context, user *model.Account, deviceType int32, loginIP string) (*pb.LoginReply, error) {
+	ticket, err := storeToken(ctx, &accountToken{
+		UserID:     user.UserID,
+		Purpose:    purposeMFA,
+		DeviceType: deviceType,
+		LoginIP:    loginIP,
+	}, mfaTicketLifetime)
+	if err != nil {
+		log.Printf("Error accured: Login(StoreTicket)\n%v", err)
+		return &pb.LoginReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.LoginReply{UserID: user.UserID, MfaRequired: true, MfaTicket: ticket}, nil
+}
+
+// LoginTOTP func
+// Makes session for login ticket when code is right.
+// Ticket is used up by login or too many wrong codes
+func (a *authServer) LoginTOTP(ctx context.Context, req *pb.LoginTOTPRequest) (*pb.LoginReply, error) {
+	ticket := &accountToken{}
+
+	// Attempt is counted before code is checked, so parallel tries are counted too
+	err := tokenCol().FindOneAndUpdate(ctx, bson.D{
+		{"_id", hashToken(req.GetMfaTicket())},
+		{"purpose", purposeMFA},
+		{"expiresAt", bson.D{{"$gt", time.Now().UTC()}}},
+		{"attempts", bson.D{{"$not", bson.D{{"$gte", maxMFAAttempts}}}}},
+	}, bson.D{{"$inc", bson.D{{"attempts", 1}}}}).Decode(ticket)
+	if err == mongo.ErrNoDocuments {
+		return &pb.LoginReply{}, status.Error(codes.NotFound, errTokenNotFound.Error())
+	}
+	if err != nil {
+		log.Printf("Error accured: LoginTOTP(FindTicket)\n%v", err)
+		return &pb.LoginReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	if err := checkThrottle(ctx, ticket.UserID, ticket.LoginIP); err != nil {
+		return &pb.LoginReply{}, err
+	}
+
+	user := &model.Account{}
+
+	err = col.FindOne(ctx, bson.D{{"_id", ticket.UserID}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.LoginReply{}, status.Error(codes.NotFound, "id not found")
+	}
+	if err != nil {
+		log.Printf("Error accured: LoginTOTP(FindUser)\n%v", err)
+		return &pb.LoginReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	if !user.TOTPEnabled {
+		return &pb.LoginReply{}, status.Error(codes.FailedPrecondition, "totp not enabled")
+	}
+
+	ok, err := useSecondFactor(ctx, user, req.GetCode())
+	if err != nil {
+		log.Printf("Error accured: LoginTOTP(UseCode)\n%v", err)
+		return &pb.LoginReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if !ok {
+		recordFailure(ctx, ticket.UserID, ticket.LoginIP)
+		return &pb.LoginReply{}, status.Error(codes.Unauthenticated, "code not match")
+	}
+
+	// Ticket is deleted before session is made, so it makes one session
+	if _, err := consumeToken(ctx, req.GetMfaTicket(), purposeMFA); err == errTokenNotFound {
+		return &pb.LoginReply{}, status.Error(codes.NotFound, err.Error())
+	} else if err != nil {
+		log.Printf("Error accured: LoginTOTP(ConsumeTicket)\n%v", err)
+		return &pb.LoginReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	clearFailures(ticket.UserID)
+
+	return newSession(user, ticket.DeviceType, ticket.LoginIP)
+}
+
+// EnrollTOTP func
+// Gives new secret for authenticator app, TOTP is enabled by ConfirmTOTP
+func (a *authServer) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPReply, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		log.Printf("Error accured: EnrollTOTP\n%v", err)
+		return &pb.EnrollTOTPReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	result, err := col.UpdateOne(ctx,
+		bson.D{{"_id", req.GetUserID()}, {"totpEnabled", bson.D{{"$ne", true}}}},
+		bson.D{{"$set", bson.D{{"totpPendingSecret", secret}}}})
+	if err != nil {
+		log.Printf("Error accured: EnrollTOTP\n%v", err)
+		return &pb.EnrollTOTPReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.MatchedCount == 0 {
+		n, err := col.CountDocuments(ctx, bson.D{{"_id", req.GetUserID()}})
+		if err != nil {
+			log.Printf("Error accured: EnrollTOTP\n%v", err)
+			return &pb.EnrollTOTPReply{}, status.Error(codes.Unknown, err.Error())
+		}
+		if n == 0 {
+			return &pb.EnrollTOTPReply{}, status.Error(codes.NotFound, "user not found")
+		}
+		return &pb.EnrollTOTPReply{}, status.Error(codes.AlreadyExists, "totp already enabled")
+	}
+
+	return &pb.EnrollTOTPReply{Secret: secret, OtpauthURI: totpURI(req.GetUserID(), secret)}, nil
+}
+
+// ConfirmTOTP func
+// Enables TOTP when code of pending secret matches, gives recovery codes
+func (a *authServer) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPReply, error) {
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"_id", req.GetUserID()}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.ConfirmTOTPReply{}, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("Error accured: ConfirmTOTP(FindUser)\n%v", err)
+		return &pb.ConfirmTOTPReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	if len(user.TOTPPendingSecret) < 1 {
+		return &pb.ConfirmTOTPReply{}, status.Error(codes.FailedPrecondition, "totp not enrolled")
+	}
+
+	step := matchTOTP(user.TOTPPendingSecret, strings.TrimSpace(req.GetCode()), time.Now())
+	if step == 0 {
+		return &pb.ConfirmTOTPReply{}, status.Error(codes.Unauthenticated, "code not match")
+	}
+
+	recoveryCodes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		log.Printf("Error accured: ConfirmTOTP\n%v", err)
+		return &pb.ConfirmTOTPReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	// Pending secret is checked in update, secret enrolled again meanwhile is not enabled
+	result, err := col.UpdateOne(ctx,
+		bson.D{{"_id", req.GetUserID()}, {"totpPendingSecret", user.TOTPPendingSecret}},
+		bson.D{
+			{"$set", bson.D{
+				{"totpEnabled", true},
+				{"totpSecret", user.TOTPPendingSecret},
+				{"totpLastStep", step},
+				{"recoveryCodes", hashes},
+			}},
+			{"$unset", bson.D{{"totpPendingSecret", ""}}},
+		})
+	if err != nil {
+		log.Printf("Error accured: ConfirmTOTP\n%v", err)
+		return &pb.ConfirmTOTPReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if result.MatchedCount == 0 {
+		return &pb.ConfirmTOTPReply{}, status.Error(codes.FailedPrecondition, "totp not enrolled")
+	}
+
+	return &pb.ConfirmTOTPReply{RecoveryCodes: recoveryCodes}, nil
+}
+
+// DisableTOTP func
+// Turns TOTP off with password and code, login needs password only after
+func (a *authServer) DisableTOTP(ctx context.Context, req *pb.DisableTOTPRequest) (*pb.DisableTOTPReply, error) {
+	user := &model.Account{}
+
+	err := col.FindOne(ctx, bson.D{{"_id", req.GetUserID()}}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return &pb.DisableTOTPReply{}, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("Error accured: DisableTOTP(FindUser)\n%v", err)
+		return &pb.DisableTOTPReply{}, status.Error(codes.Unknown, err.Error())
+	}
+
+	if !user.TOTPEnabled {
+		return &pb.DisableTOTPReply{}, status.Error(codes.FailedPrecondition, "totp not enabled")
+	}
+
+	match, _, err := verifyPassword(user.Password, req.GetUserID(), req.GetPassword())
+	if err != nil {
+		log.Printf("Error accured: DisableTOTP(VerifyPassword)\n%v", err)
+		return &pb.DisableTOTPReply{}, status.Error(codes.Internal, err.Error())
+	}
+	if !match {
+		return &pb.DisableTOTPReply{}, status.Error(codes.Unauthenticated, "password not match")
+	}
+
+	ok, err := useSecondFactor(ctx, user, req.GetCode())
+	if err != nil {
+		log.Printf("Error accured: DisableTOTP(UseCode)\n%v", err)
+		return &pb.DisableTOTPReply{}, status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		return &pb.DisableTOTPReply{}, status.Error(codes.Unauthenticated, "code not match")
+	}
+
+	_, err = col.UpdateOne(ctx, bson.D{{"_id", req.GetUserID()}}, bson.D{
+		{"$set", bson.D{{"totpEnabled", false}}},
+		{"$unset", bson.D{{"totpSecret", ""}, {"totpPendingSecret", ""}, {"totpLastStep", ""}, {"recoveryCodes", ""}}},
+	})
+	if err != nil {
+		log.Printf("Error accured: DisableTOTP\n%v", err)
+		return &pb.DisableTOTPReply{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.DisableTOTPReply{}, nil
+}
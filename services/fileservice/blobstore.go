@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// BlobStore stores picture files by key, "userId/pictureId"
+type BlobStore interface {
+	Put(key string, body io.Reader) error
+	// Get returns errNotFound when key does not exist
+	Get(key string) (io.ReadCloser, error)
+	// GetRange reads length bytes from offset
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+	// Delete of missing key is not an error
+	Delete(key string) error
+	Stat(key string) (BlobInfo, error)
+	List(prefix string) ([]BlobInfo, error)
+}
+
+// BlobInfo is metadata of stored blob
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+var (
+	errNotFound   = errors.New("blob not found")
+	errInvalidKey = errors.New("invalid blob key")
+)
+
+// newBlobStore returns store of backend: local, s3 or memory
+func newBlobStore(backend string) (BlobStore, error) {
+	switch backend {
+	case "local":
+		return newLocalStore(BLOBDIR)
+	case "s3":
+		return newS3Store(S3ENDPOINT, S3REGION, S3BUCKET, S3ACCESSKEY, S3SECRETKEY)
+	case "memory":
+		return newMemoryStore(), nil
+	}
+
+	return nil, fmt.Errorf("unknown blob backend %q", backend)
+}
+
+// validKey rejects keys escaping the store, like "../x" or "/x"
+func validKey(key string) bool {
+	if len(key) < 1 || strings.HasPrefix(key, "/") {
+		return false
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+func readBlob(key string) ([]byte, error) {
+	body, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+func writeBlob(key string, data []byte) error {
+	return store.Put(key, bytes.NewReader(data))
+}
+
+// blobReader is io.ReadSeeker over stored blob for http.ServeContent.
+// Only the range being read is fetched from store
+type blobReader struct {
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func newBlobReader(info BlobInfo) *blobReader {
+	return &blobReader{key: info.Key, size: info.Size}
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		body, err := store.GetRange(r.key, r.offset, r.size-r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+
+	return n, err
+}
+
+func (r *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+
+	return offset, nil
+}
+
+func (r *blobReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+
+	return err
+}
@@ -1,28 +1,58 @@
 package main
 
 import (
-	"bytes"
 	"flag"
-	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"time"
+	"os"
+	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/gorilla/mux"
 	"github.com/urfave/negroni"
 
 	"github.com/farerpath/server/model/consts"
 )
 
+// Blob storage configuration
+// FP_BLOB_BACKEND selects local, s3 or memory
+var (
+	BLOBBACKEND = "s3"
+	BLOBDIR     = "/var/lib/farerpath/blobs"
+
+	// S3ENDPOINT : empty for AWS, set for S3-compatible server like MinIO
+	S3ENDPOINT  = ""
+	S3REGION    = endpoints.ApNortheast2RegionID
+	S3BUCKET    = "farerpathalpha1"
+	S3ACCESSKEY = consts.AWS_ACCESS_KEY
+	S3SECRETKEY = consts.AWS_SECRET_ACCESS_KEY
+)
+
+var store BlobStore
+
+// maxFieldLength limits form fields read before file part
+const maxFieldLength = 1024
+
+func init() {
+	config := map[string]*string{
+		"FP_BLOB_BACKEND":  &BLOBBACKEND,
+		"FP_BLOB_DIR":      &BLOBDIR,
+		"FP_S3_ENDPOINT":   &S3ENDPOINT,
+		"FP_S3_REGION":     &S3REGION,
+		"FP_S3_BUCKET":     &S3BUCKET,
+		"FP_S3_ACCESS_KEY": &S3ACCESSKEY,
+		"FP_S3_SECRET_KEY": &S3SECRETKEY,
+	}
+
+	for name, value := range config {
+		if env := os.Getenv(name); len(env) > 0 {
+			*value = env
+		}
+	}
+}
+
 func App() http.Handler {
 	r := mux.NewRouter()
 
@@ -30,6 +60,16 @@ func App() http.Handler {
 	r.HandleFunc("/", UploadHandler).Methods(http.MethodPost)
 	r.HandleFunc("/", DeleteHandler).Methods(http.MethodDelete)
 
+	// All files of user, when account is deleted
+	r.HandleFunc("/users", DeleteUserHandler).Methods(http.MethodDelete)
+
+	// Resumable uploads
+	r.HandleFunc("/uploads", CreateUploadHandler).Methods(http.MethodPost)
+	r.HandleFunc("/uploads", GetUploadHandler).Methods(http.MethodGet)
+	r.HandleFunc("/uploads", WriteUploadHandler).Methods(http.MethodPatch)
+	r.HandleFunc("/uploads", DeleteUploadHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/uploads/complete", CompleteUploadHandler).Methods(http.MethodPost)
+
 	n := negroni.New(negroni.NewLogger(), negroni.NewRecovery())
 	n.UseHandler(r)
 
@@ -39,104 +79,202 @@ func App() http.Handler {
 func main() {
 	port := flag.String("port", "80", "bind port (default: 80)")
 
-	err := http.ListenAndServe(":"+*port, App())
+	var err error
+	store, err = newBlobStore(BLOBBACKEND)
+	if err != nil {
+		log.Fatalf("Blob store init failed:\n%v", err)
+	}
+	log.Printf("Blob backend: %v\n", BLOBBACKEND)
+
+	err = http.ListenAndServe(":"+*port, App())
 	log.Fatal(err)
 }
 
+// UploadHandler func
+// Multipart form with userId and pictureId fields before file.
+// File part is streamed to store as it arrives
 func UploadHandler(w http.ResponseWriter, r *http.Request) {
-	userId := r.FormValue("userId")
-	pictureId := r.FormValue("pictureId")
-
-	file, _, err := r.FormFile("file")
+	reader, err := r.MultipartReader()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	awsCredential := credentials.NewStaticCredentials(consts.AWS_ACCESS_KEY, consts.AWS_SECRET_ACCESS_KEY, "")
-	awsSession := session.Must(session.NewSession(&aws.Config{Credentials: awsCredential, Region: aws.String(endpoints.ApNortheast2RegionID)}))
+	fields := make(map[string]string)
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			// io.EOF without file part
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if part.FormName() != "file" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFieldLength))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		key := fields["userId"] + "/" + fields["pictureId"]
+		if !validKey(key) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 
-	awsS3 := s3.New(awsSession)
+		err = store.Put(key, part)
+		if err != nil {
+			log.Printf("Upload failed: %v\n%v", key, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 
-	key := userId + "/" + pictureId
+		// Missing renditions are made on first request
+		go storeRenditions(key)
 
-	buffer, err := ioutil.ReadAll(file)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
+}
 
-	_, err = awsS3.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String("farerpathalpha1"),
-		Key:    aws.String(key),
-		Body:   bytes.NewReader(buffer),
-	})
+// DownloadHandler func
+// size is one of renditions, original file when empty.
+// Range requests read only requested bytes from store
+func DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("userId") + "/" + r.FormValue("pictureId")
+	if !validKey(key) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
+	size := r.FormValue("size")
+	name := r.FormValue("pictureId")
+
+	// location is set when viewer is not owner
+	location := r.FormValue("location")
+	if len(location) > 0 && location != consts.LOCATION_EXACT && location != consts.LOCATION_CITY && location != consts.LOCATION_NONE {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
+	var patch []byte
 
-func DownloadHandler(w http.ResponseWriter, r *http.Request) {
-	buffer := &aws.WriteAtBuffer{}
+	if (len(size) < 1 || size == "original") && len(location) > 0 && location != consts.LOCATION_EXACT {
+		info, err := store.Stat(key)
+		if err != nil {
+			w.WriteHeader(blobErrorToStatus(err))
+			return
+		}
 
-	awsCredential := credentials.NewStaticCredentials(consts.AWS_ACCESS_KEY, consts.AWS_SECRET_ACCESS_KEY, "")
-	awsSession := session.Must(session.NewSession(&aws.Config{Credentials: awsCredential, Region: aws.String(endpoints.ApNortheast2RegionID)}))
+		patch, err = locationPatch(info, location)
+		if err == errNoMetadataEnd {
+			size = "large"
+		} else if err != nil {
+			w.WriteHeader(blobErrorToStatus(err))
+			return
+		}
+	}
 
-	key := r.FormValue("userId") + "/" + r.FormValue("pictureId")
+	if len(size) > 0 && size != "original" {
+		maxEdge, exists := renditions[size]
+		if !exists {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 
-	downloader := s3manager.NewDownloader(awsSession)
+		if err := ensureRendition(key, size, maxEdge); err != nil {
+			w.WriteHeader(blobErrorToStatus(err))
+			return
+		}
 
-	downloader.Download(buffer, &s3.GetObjectInput{
-		Bucket: aws.String("farerpathalpha1"),
-		Key:    aws.String(key),
-	})
+		name += ".jpg"
+		key = renditionKey(key, size)
+	}
 
-	http.ServeContent(w, r, r.FormValue("pictureId"), time.Now(), bytes.NewReader(buffer.Bytes()))
+	info, err := store.Stat(key)
+	if err != nil {
+		w.WriteHeader(blobErrorToStatus(err))
+		return
+	}
+
+	reader := newBlobReader(info)
+	defer reader.Close()
+
+	if patch != nil {
+		http.ServeContent(w, r, name, info.ModTime, &patchedReader{blobReader: reader, patch: patch})
+		return
+	}
+
+	http.ServeContent(w, r, name, info.ModTime, reader)
 }
 
 func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.FormValue("userId") + "/" + r.FormValue("pictureId")
-
-	ns, err := session.NewSession()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if !validKey(key) {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	svc := s3.New(ns)
-	input := &s3.DeleteObjectInput{
-		Bucket: aws.String("farerpathalpha1"),
-		Key:    aws.String(key),
+	keys := []string{key}
+	for size := range renditions {
+		keys = append(keys, renditionKey(key, size))
 	}
 
-	_, err = svc.DeleteObject(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
+	for _, k := range keys {
+		if err := store.Delete(k); err != nil {
+			log.Printf("Delete failed: %v\n%v", k, err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteUserHandler func
+// Deletes pictures, renditions and unfinished uploads of user.
+// Files left by failed deletion are deleted when it is called again
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId := r.FormValue("userId")
+	if strings.Contains(userId, "/") || !validKey(userId) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	blobs, err := store.List(userId + "/")
+	if err != nil {
+		log.Printf("List failed: %v\n%v", userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	for _, blob := range blobs {
+		if err := store.Delete(blob.Key); err != nil {
+			log.Printf("Delete failed: %v\n%v", blob.Key, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Printf("Deleted %v files of %v\n", len(blobs), userId)
+
 	w.WriteHeader(http.StatusOK)
 }
+
+func blobErrorToStatus(err error) int {
+	switch err {
+	case errNotFound:
+		return http.StatusNotFound
+	case errInvalidKey:
+		return http.StatusBadRequest
+	case errNoRendition:
+		return http.StatusUnprocessableEntity
+	}
+
+	log.Println(err)
+	return http.StatusInternalServerError
+}
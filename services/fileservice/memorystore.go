@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps blobs in memory, for tests and offline runs
+type memoryStore struct {
+	mutex sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{blobs: make(map[string]memoryBlob)}
+}
+
+func (s *memoryStore) Put(key string, body io.Reader) error {
+	if !validKey(key) {
+		return errInvalidKey
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.blobs[key] = memoryBlob{data: data, modTime: time.Now()}
+	return nil
+}
+
+func (s *memoryStore) Get(key string) (io.ReadCloser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	blob, exists := s.blobs[key]
+	if !exists {
+		return nil, errNotFound
+	}
+
+	// Put replaces data slice, never modifies it
+	return ioutil.NopCloser(bytes.NewReader(blob.data)), nil
+}
+
+func (s *memoryStore) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	blob, exists := s.blobs[key]
+	if !exists {
+		return nil, errNotFound
+	}
+
+	size := int64(len(blob.data))
+	if offset > size {
+		offset = size
+	}
+	if length > size-offset {
+		length = size - offset
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(blob.data[offset : offset+length])), nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s *memoryStore) Stat(key string) (BlobInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	blob, exists := s.blobs[key]
+	if !exists {
+		return BlobInfo{}, errNotFound
+	}
+
+	return BlobInfo{Key: key, Size: int64(len(blob.data)), ModTime: blob.modTime}, nil
+}
+
+func (s *memoryStore) List(prefix string) ([]BlobInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	blobs := []BlobInfo{}
+
+	for key, blob := range s.blobs {
+		if strings.HasPrefix(key, prefix) {
+			blobs = append(blobs, BlobInfo{Key: key, Size: int64(len(blob.data)), ModTime: blob.modTime})
+		}
+	}
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+
+	return blobs, nil
+}
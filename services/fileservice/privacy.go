@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/farerpath/server/model/consts"
+)
+
+// Location in originals served to viewers other than owner.
+// Metadata of JPEG is rewritten in place, so patched file keeps its size
+// and range requests work as for stored file.
+// Other originals are replaced by large rendition, which has no metadata
+
+// maxMetadataPrefix is how far JPEG segments are looked for location
+const maxMetadataPrefix = 1 << 20
+
+// cityPrecision rounds coordinates to 0.1 degree, about 11km
+const cityPrecision = 10
+
+var errNoMetadataEnd = errors.New("metadata segments exceed prefix")
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// patchedReader serves patch over start of blob of the same size
+type patchedReader struct {
+	*blobReader
+	patch []byte
+}
+
+func (r *patchedReader) Read(p []byte) (int, error) {
+	if r.offset < int64(len(r.patch)) {
+		n := copy(p, r.patch[r.offset:])
+		r.Seek(int64(n), io.SeekCurrent)
+		return n, nil
+	}
+
+	return r.blobReader.Read(p)
+}
+
+// locationPatch reads start of JPEG blob and hides location in it.
+// errNoMetadataEnd when blob is not JPEG or its metadata could not be read whole
+func locationPatch(info BlobInfo, location string) ([]byte, error) {
+	length := info.Size
+	if length > maxMetadataPrefix {
+		length = maxMetadataPrefix
+	}
+
+	body, err := store.GetRange(info.Key, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	prefix, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := hideLocation(prefix, location)
+	if err != nil {
+		return nil, err
+	}
+
+	return prefix[:end], nil
+}
+
+// hideLocation rewrites metadata segments of JPEG in data,
+// returns where image data starts
+func hideLocation(data []byte, location string) (int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, errNoMetadataEnd
+	}
+
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return 0, errNoMetadataEnd
+		}
+
+		marker := data[pos+1]
+		// Start of scan, no metadata after
+		if marker == 0xDA {
+			return pos, nil
+		}
+
+		// Fill byte
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		start, end := pos+4, pos+2+length
+		if length < 2 || end > len(data) {
+			return 0, errNoMetadataEnd
+		}
+
+		// APP1 holds EXIF and XMP
+		if marker == 0xE1 {
+			segment := data[start:end]
+
+			if bytes.HasPrefix(segment, exifHeader) {
+				if err := hideExifLocation(segment[len(exifHeader):], location); err != nil {
+					// Broken EXIF is turned into comment with no content
+					data[pos+1] = 0xFE
+					zero(segment)
+				}
+			} else if bytes.HasPrefix(segment, xmpHeader) {
+				// XMP may repeat location, packet is blanked
+				blank := segment[len(xmpHeader):]
+				for i := range blank {
+					blank[i] = ' '
+				}
+			}
+		}
+
+		pos = end
+	}
+}
+
+// GPS tags kept at city precision
+const (
+	gpsVersionID    = 0x0000
+	gpsLatitudeRef  = 0x0001
+	gpsLatitude     = 0x0002
+	gpsLongitudeRef = 0x0003
+	gpsLongitude    = 0x0004
+
+	gpsInfoPointer = 0x8825
+)
+
+// Sizes of TIFF types
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+type tiffEntry struct {
+	raw   []byte
+	tag   uint16
+	value []byte
+}
+
+var errBadExif = errors.New("bad exif")
+
+// hideExifLocation rewrites GPS IFD of EXIF TIFF data in place.
+// Entries are dropped by moving kept ones to front and zeroing the rest
+func hideExifLocation(data []byte, location string) error {
+	if len(data) < 8 {
+		return errBadExif
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errBadExif
+	}
+
+	ifd0, err := readIFD(data, order, order.Uint32(data[4:]))
+	if err != nil {
+		return err
+	}
+
+	var gpsOffset uint32
+	for _, entry := range ifd0 {
+		if entry.tag == gpsInfoPointer {
+			gpsOffset = order.Uint32(entry.value)
+		}
+	}
+
+	if gpsOffset == 0 {
+		return nil
+	}
+
+	gps, err := readIFD(data, order, gpsOffset)
+	if err != nil {
+		return err
+	}
+
+	kept := [][]byte{}
+
+	for _, entry := range gps {
+		keep := false
+
+		if location == consts.LOCATION_CITY {
+			switch entry.tag {
+			case gpsVersionID, gpsLatitudeRef, gpsLongitudeRef:
+				keep = true
+			case gpsLatitude, gpsLongitude:
+				keep = roundCoordinate(entry.value, order)
+			}
+		}
+
+		if keep {
+			kept = append(kept, append([]byte{}, entry.raw...))
+		} else {
+			zero(entry.value)
+		}
+	}
+
+	// Entries and next IFD offset of GPS IFD
+	entries := data[gpsOffset+2 : int(gpsOffset)+2+len(gps)*12+4]
+	zero(entries)
+
+	order.PutUint16(data[gpsOffset:], uint16(len(kept)))
+	for i, raw := range kept {
+		copy(entries[i*12:], raw)
+	}
+
+	return nil
+}
+
+func readIFD(data []byte, order binary.ByteOrder, offset uint32) ([]tiffEntry, error) {
+	if int64(offset)+2 > int64(len(data)) {
+		return nil, errBadExif
+	}
+
+	count := int(order.Uint16(data[offset:]))
+	start := int(offset) + 2
+	if start+count*12+4 > len(data) {
+		return nil, errBadExif
+	}
+
+	entries := []tiffEntry{}
+
+	for i := 0; i < count; i++ {
+		raw := data[start+i*12 : start+i*12+12]
+
+		size, exists := tiffTypeSizes[order.Uint16(raw[2:])]
+		if !exists {
+			return nil, errBadExif
+		}
+
+		length := int64(size) * int64(order.Uint32(raw[4:]))
+
+		// Values up to 4 bytes are inline
+		value := raw[8:12]
+		if length > 4 {
+			valueOffset := int64(order.Uint32(raw[8:]))
+			if valueOffset+length > int64(len(data)) {
+				return nil, errBadExif
+			}
+			value = data[valueOffset : valueOffset+length]
+		} else {
+			value = value[:length]
+		}
+
+		entries = append(entries, tiffEntry{raw: raw, tag: order.Uint16(raw), value: value})
+	}
+
+	return entries, nil
+}
+
+// roundCoordinate rewrites degrees, minutes, seconds rationals
+// as degrees at city precision, false when value is not such rationals
+func roundCoordinate(value []byte, order binary.ByteOrder) bool {
+	if len(value) != 24 {
+		return false
+	}
+
+	degrees := 0.0
+	for i, unit := range []float64{1, 60, 3600} {
+		num := order.Uint32(value[i*8:])
+		den := order.Uint32(value[i*8+4:])
+		if den == 0 {
+			return false
+		}
+		degrees += float64(num) / float64(den) / unit
+	}
+
+	zero(value)
+	order.PutUint32(value, uint32(math.Round(degrees*cityPrecision)))
+	order.PutUint32(value[4:], cityPrecision)
+	order.PutUint32(value[12:], 1)
+	order.PutUint32(value[20:], 1)
+
+	return true
+}
+
+func zero(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log"
+
+	// Decoders for originals
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Rendition sizes, longest edge in pixels
+var renditions = map[string]int{
+	"small":  320,
+	"medium": 1024,
+	"large":  2048,
+}
+
+const renditionQuality = 85
+
+// renditionKey is stored next to original key
+func renditionKey(key, size string) string {
+	return key + "_" + size
+}
+
+// maxRenditionSource is largest original decoded for renditions,
+// decoding needs the whole picture in memory
+const maxRenditionSource = 64 << 20
+
+var errNoRendition = errors.New("rendition cannot be made")
+
+// storeRenditions makes every rendition of stored original
+func storeRenditions(key string) {
+	for size, maxEdge := range renditions {
+		if err := ensureRendition(key, size, maxEdge); err != nil {
+			log.Printf("Rendition failed: %v %v\n%v", key, size, err)
+			return
+		}
+	}
+}
+
+// ensureRendition makes rendition from original when it is not stored yet
+func ensureRendition(key, size string, maxEdge int) error {
+	_, err := store.Stat(renditionKey(key, size))
+	if err != errNotFound {
+		return err
+	}
+
+	info, err := store.Stat(key)
+	if err != nil {
+		return err
+	}
+
+	if info.Size > maxRenditionSource {
+		return errNoRendition
+	}
+
+	original, err := readBlob(key)
+	if err != nil {
+		return err
+	}
+
+	rendition, err := makeRendition(original, maxEdge)
+	if err != nil {
+		log.Printf("Rendition failed: %v %v\n%v", key, size, err)
+		return errNoRendition
+	}
+
+	return writeBlob(renditionKey(key, size), rendition)
+}
+
+// makeRendition returns JPEG of original, scaled down to maxEdge
+// and rotated upright by EXIF orientation
+func makeRendition(original []byte, maxEdge int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, err
+	}
+
+	// Flatten on white, JPEG has no transparency
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	rgba = orient(resize(rgba, maxEdge), readOrientation(original))
+
+	buffer := &bytes.Buffer{}
+	if err := jpeg.Encode(buffer, rgba, &jpeg.Options{Quality: renditionQuality}); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// readOrientation returns EXIF orientation, 1 when not found
+func readOrientation(original []byte) int {
+	metadata, err := exif.Decode(bytes.NewReader(original))
+	if err != nil {
+		return 1
+	}
+
+	tag, err := metadata.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+
+	orientation, err := tag.Int(0)
+	if err != nil {
+		return 1
+	}
+
+	return orientation
+}
+
+// resize scales src down by area averaging so longest edge is maxEdge
+func resize(src *image.RGBA, maxEdge int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w <= maxEdge && h <= maxEdge {
+		return src
+	}
+
+	dw, dh := maxEdge, h*maxEdge/w
+	if h > w {
+		dw, dh = w*maxEdge/h, maxEdge
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, (y+1)*h/dh
+
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, (x+1)*w/dw
+
+			var sum [4]int
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+
+			n := (sy1 - sy0) * (sx1 - sx0)
+			offset := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// orient applies EXIF orientation so picture is upright
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[y*src.Stride+x*4:y*src.Stride+x*4+4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Uploads larger than one part go as multipart upload.
+// Memory of an upload is about s3PartSize * s3Concurrency
+const (
+	s3PartSize    = 8 << 20
+	s3Concurrency = 2
+)
+
+// s3Store keeps blobs in S3 bucket.
+// With endpoint set it talks to S3-compatible server like MinIO
+type s3Store struct {
+	bucket   string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+func newS3Store(endpoint, region, bucket, accessKey, secretKey string) (*s3Store, error) {
+	config := &aws.Config{
+		Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
+		Region:      aws.String(region),
+	}
+
+	if len(endpoint) > 0 {
+		config.Endpoint = aws.String(endpoint)
+		// MinIO does not support virtual-hosted buckets
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	awsSession, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Store{
+		bucket: bucket,
+		client: s3.New(awsSession),
+		uploader: s3manager.NewUploader(awsSession, func(u *s3manager.Uploader) {
+			u.PartSize = s3PartSize
+			u.Concurrency = s3Concurrency
+		}),
+	}, nil
+}
+
+func (s *s3Store) Put(key string, body io.Reader) error {
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+
+	return err
+}
+
+func (s *s3Store) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return resp.Body, nil
+}
+
+func (s *s3Store) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	// S3 rejects empty range
+	if length < 1 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+
+	resp, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return resp.Body, nil
+}
+
+func (s *s3Store) Delete(key string) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	return err
+}
+
+func (s *s3Store) Stat(key string) (BlobInfo, error) {
+	resp, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return BlobInfo{}, s3Error(err)
+	}
+
+	return BlobInfo{Key: key, Size: aws.Int64Value(resp.ContentLength), ModTime: aws.TimeValue(resp.LastModified)}, nil
+}
+
+func (s *s3Store) List(prefix string) ([]BlobInfo, error) {
+	blobs := []BlobInfo{}
+
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			blobs = append(blobs, BlobInfo{
+				Key:     aws.StringValue(object.Key),
+				Size:    aws.Int64Value(object.Size),
+				ModTime: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+
+	return blobs, err
+}
+
+// s3Error maps missing object to errNotFound.
+// HeadObject has no body, so its error code is "NotFound"
+func s3Error(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return errNotFound
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads
+// Chunks are stored as blobs next to upload info and joined on completion
+//  userId/uploads/uploadId/info
+//  userId/uploads/uploadId/chunk-{offset}
+// Offset is total size of stored chunks, so it survives restarts.
+// Chunk is stored whole or not at all, interrupted write is sent again from last offset
+
+type uploadInfo struct {
+	Length   int64     `json:"length"`
+	Metadata string    `json:"metadata"`
+	Created  time.Time `json:"created"`
+}
+
+type uploadState struct {
+	Length   int64  `json:"length"`
+	Offset   int64  `json:"offset"`
+	Metadata string `json:"metadata"`
+}
+
+// uploadLocks serializes writes of an upload
+var uploadLocks sync.Map
+
+func uploadLock(prefix string) *sync.Mutex {
+	lock, _ := uploadLocks.LoadOrStore(prefix, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// uploadPrefix returns key prefix of upload, empty when invalid
+func uploadPrefix(r *http.Request) string {
+	uploadId := r.FormValue("uploadId")
+	if strings.Contains(uploadId, "/") {
+		return ""
+	}
+
+	prefix := r.FormValue("userId") + "/uploads/" + uploadId + "/"
+	if !validKey(prefix + "info") {
+		return ""
+	}
+
+	return prefix
+}
+
+func chunkKey(prefix string, offset int64) string {
+	// Zero padded so chunk keys sort by offset
+	return fmt.Sprintf("%schunk-%020d", prefix, offset)
+}
+
+func loadUpload(prefix string) (*uploadState, []BlobInfo, error) {
+	data, err := readBlob(prefix + "info")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := uploadInfo{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, nil, err
+	}
+
+	chunks, err := store.List(prefix + "chunk-")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Key < chunks[j].Key })
+
+	state := &uploadState{Length: info.Length, Metadata: info.Metadata}
+	for _, chunk := range chunks {
+		state.Offset += chunk.Size
+	}
+
+	return state, chunks, nil
+}
+
+func writeUploadState(w http.ResponseWriter, statusCode int, state *uploadState) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(state)
+}
+
+// CreateUploadHandler func
+// userId, uploadId, length and opaque metadata
+func CreateUploadHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := uploadPrefix(r)
+	if len(prefix) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.FormValue("length"), 10, 64)
+	if err != nil || length < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(uploadInfo{Length: length, Metadata: r.FormValue("metadata"), Created: time.Now().UTC()})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeBlob(prefix+"info", data); err != nil {
+		w.WriteHeader(blobErrorToStatus(err))
+		return
+	}
+
+	writeUploadState(w, http.StatusCreated, &uploadState{Length: length, Metadata: r.FormValue("metadata")})
+}
+
+func GetUploadHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := uploadPrefix(r)
+	if len(prefix) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	state, _, err := loadUpload(prefix)
+	if err != nil {
+		w.WriteHeader(blobErrorToStatus(err))
+		return
+	}
+
+	writeUploadState(w, http.StatusOK, state)
+}
+
+// WriteUploadHandler func
+// Body is chunk starting at offset, which must be current offset of upload
+func WriteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := uploadPrefix(r)
+	if len(prefix) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.FormValue("offset"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	lock := uploadLock(prefix)
+	lock.Lock()
+	defer lock.Unlock()
+
+	state, _, err := loadUpload(prefix)
+	if err != nil {
+		w.WriteHeader(blobErrorToStatus(err))
+		return
+	}
+
+	if offset != state.Offset {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	body := &countingReader{reader: io.LimitReader(r.Body, state.Length-state.Offset)}
+
+	if err := store.Put(chunkKey(prefix, offset), body); err != nil {
+		log.Printf("Upload chunk failed: %v\n%v", prefix, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if body.count == 0 {
+		store.Delete(chunkKey(prefix, offset))
+	}
+
+	state.Offset += body.count
+
+	writeUploadState(w, http.StatusOK, state)
+}
+
+// CompleteUploadHandler func
+// Joins chunks of finished upload into userId/pictureId
+func CompleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := uploadPrefix(r)
+	key := r.FormValue("userId") + "/" + r.FormValue("pictureId")
+	if len(prefix) < 1 || !validKey(key) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	lock := uploadLock(prefix)
+	lock.Lock()
+	defer lock.Unlock()
+
+	state, chunks, err := loadUpload(prefix)
+	if err != nil {
+		w.WriteHeader(blobErrorToStatus(err))
+		return
+	}
+
+	if state.Offset != state.Length {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	reader := &chunkReader{chunks: chunks}
+	defer reader.Close()
+
+	if err := store.Put(key, reader); err != nil {
+		log.Printf("Upload complete failed: %v\n%v", prefix, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := deleteUpload(prefix); err != nil {
+		log.Printf("Upload cleanup failed: %v\n%v", prefix, err)
+	}
+	uploadLocks.Delete(prefix)
+
+	go storeRenditions(key)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func DeleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := uploadPrefix(r)
+	if len(prefix) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	lock := uploadLock(prefix)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := store.Stat(prefix + "info"); err != nil {
+		w.WriteHeader(blobErrorToStatus(err))
+		return
+	}
+
+	if err := deleteUpload(prefix); err != nil {
+		w.WriteHeader(blobErrorToStatus(err))
+		return
+	}
+	uploadLocks.Delete(prefix)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUpload removes chunks, then info
+func deleteUpload(prefix string) error {
+	chunks, err := store.List(prefix + "chunk-")
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if err := store.Delete(chunk.Key); err != nil {
+			return err
+		}
+	}
+
+	return store.Delete(prefix + "info")
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// chunkReader reads chunks one after another, opening each when reached
+type chunkReader struct {
+	chunks  []BlobInfo
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+
+			body, err := store.Get(r.chunks[0].Key)
+			if err != nil {
+				return 0, err
+			}
+			r.current = body
+			r.chunks = r.chunks[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+
+	err := r.current.Close()
+	r.current = nil
+
+	return err
+}
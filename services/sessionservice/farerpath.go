@@ -2,18 +2,17 @@ package main
 
 import (
 	"crypto/rand"
-	"encoding/json"
-	"io/ioutil"
 	"log"
 	"net"
-	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	pb "github.com/farerpath/sessionservice/proto"
 
 	"github.com/go-redis/redis"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -35,6 +34,19 @@ var DBADDRESS = "mongodb://localhost:27017"
 // REDISADDR : Redis Address
 var REDISADDR = "localhost:6379"
 
+// refreshTokenLifetime : how long refresh token can extend session
+const refreshTokenLifetime = 24 * 30 * time.Hour
+
+// maxUsedRefreshTokens : replaced refresh tokens kept to detect reuse,
+// older ones are forgotten and cannot revoke session
+const maxUsedRefreshTokens = 20
+
+// defaultSessionDuration : hours of auth token when session has no duration
+const defaultSessionDuration = 3
+
+// GEOIPDB : MaxMind database for login region, no region when empty
+var GEOIPDB = ""
+
 type sessionService struct{}
 
 var (
@@ -58,6 +70,16 @@ func init() {
 		log.Printf("REDIS address received: %v\n", addr)
 		REDISADDR = addr
 	}
+
+	if hours, err := strconv.Atoi(os.Getenv("FP_KEY_ROTATION_HOURS")); err == nil && hours > 0 {
+		log.Printf("Key rotation received: %v hours\n", hours)
+		KEYROTATION = time.Duration(hours) * time.Hour
+	}
+
+	if path := os.Getenv("FP_GEOIP_DB"); len(path)>1 {
+		log.Printf("GeoIP database received: %v\n", path)
+		GEOIPDB = path
+	}
 }
 
 func main() {
@@ -83,6 +105,30 @@ func main() {
 
 	log.Println("DB Connection succeed")
 
+	migrate(context.Background())
+
+	if err := rotateKeys(context.Background()); err != nil {
+		log.Fatalf("Failed to load signing keys:\n%v", err)
+	}
+
+	go func() {
+		for range time.Tick(keyReload) {
+			if err := rotateKeys(context.Background()); err != nil {
+				log.Printf("Failed to rotate signing keys:\n%v", err)
+			}
+		}
+	}()
+
+	// Sessions are made without region when database cannot be opened
+	if len(GEOIPDB) > 0 {
+		db, err := newMMDBGeoIP(GEOIPDB)
+		if err != nil {
+			log.Printf("Failed to open GeoIP database:\n%v\n", err)
+		} else {
+			geoIP = db
+		}
+	}
+
 	listener, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatalf("Failed to listen tcp:\n%v\n", err)
@@ -102,71 +148,15 @@ func main() {
 }
 
 func (s *sessionService) NewSession(ctx context.Context, in *pb.SessionRequest) (*pb.SessionResponse, error) {
-	v := secureRandomString(16)
 	sign := secureRandomString(16)
 	key := secureRandomString(32)
 
-	authClaim := FPClaim{
-		key,
-		"AUTH",
-		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * time.Duration(in.SessionDurationTime)).Unix(),
-			Issuer:    "farerpath",
-		},
-	}
-
-	authToken := jwt.NewWithClaims(jwt.SigningMethodHS512, authClaim)
-	authTokenString, err := authToken.SignedString([]byte(v))
-	if err != nil {
-		log.Printf("Failed to generate token.\n%v", err)
-		return &pb.SessionResponse{}, status.Error(codes.Internal, err.Error())
-	}
-
-	refreshClaim := FPClaim {
-		key,
-		"REFRESH",
-		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * time.Duration(24*30)).Unix(),
-			Issuer:    "farerpath",
-		},
-	}
-
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS512, refreshClaim)
-	refreshTokenString, err := refreshToken.SignedString([]byte(sign))
+	authTokenString, refreshTokenString, err := signTokens(key, sign, in.UserID, in.SessionDurationTime)
 	if err != nil {
 		log.Printf("Failed to generate token.\n%v", err)
 		return &pb.SessionResponse{}, status.Error(codes.Internal, err.Error())
 	}
 
-	// IP country query
-	countryCode := ""
-
-	ip := net.ParseIP(in.GetLoginIP())
-	if ip != nil {
-		// Check IP Location
-		resp, err := http.Get("http://api.geoify.info/" + ip.String())
-		if err != nil {
-			log.Println(err)
-		}
-		defer resp.Body.Close()
-
-		buffer, _ := ioutil.ReadAll(resp.Body)
-
-		georesp := make(map[string]string)
-
-		err = json.Unmarshal(buffer, georesp)
-		if err != nil {
-			log.Println(err)
-		}
-
-		c, exists := georesp["isoCountryCode"]
-		if !exists {
-			log.Println("err country code missing!")
-		}
-
-		countryCode = c
-	}
-
 	session := &model.UserSession{
 		UserID:      	in.UserID,
 		AuthToken:   	authTokenString,
@@ -174,8 +164,10 @@ func (s *sessionService) NewSession(ctx context.Context, in *pb.SessionRequest)
 		LoginTime:   	time.Now().UTC(),
 		DeviceType:  	in.LoginDeviceType,
 		LoginIP:     	in.LoginIP,
-		LoginRegion: 	countryCode,
 		Sign:			sign,
+		SessionDuration:	in.SessionDurationTime,
+		RefreshExpiresAt:	time.Now().Add(refreshTokenLifetime).UTC(),
+		UsedRefreshTokens:	[]string{},
 	}
 
 	collection := mClient.Database("farerpath").Collection("sessions")
@@ -187,85 +179,158 @@ func (s *sessionService) NewSession(ctx context.Context, in *pb.SessionRequest)
 		return &pb.SessionResponse{}, status.Error(codes.Internal, err.Error())
 	}
 
-	rClient.Set(authTokenString, v, time.Hour*time.Duration(in.SessionDurationTime))
+	rClient.Set(authTokenString, in.UserID, time.Hour*time.Duration(in.SessionDurationTime))
+
+	go updateLoginRegion(authTokenString, in.GetLoginIP())
 
 	return &pb.SessionResponse{AuthToken: authTokenString, RefreshToken:refreshTokenString}, nil
 }
 
-// TODO: extend
+// ExtendSession rotates tokens of session.
+// Presented refresh token is replaced and cannot be used again,
+// presenting replaced refresh token revokes the session
 func (s *sessionService) ExtendSession(ctx context.Context, in *pb.ExtendRequest) (*pb.ExtendResponse, error) {
 	collection := mClient.Database("farerpath").Collection("sessions")
-	result := collection.FindOne(ctx, bson.D{{"userID", in.UserID}, {"refreshToken", in.RefreshToken}, {"authToken", in.AuthToken}})
-	if result.Err() != nil {
-		return &pb.ExtendResponse{}, status.Error(codes.Internal, result.Err().Error())
-	}
 
 	userSession := &model.UserSession{}
-	result.Decode(userSession)
+	err := collection.FindOne(ctx, bson.D{{"refreshToken", in.GetRefreshToken()}}).Decode(userSession)
+	if err == mongo.ErrNoDocuments {
+		revokeSession(ctx, in.GetRefreshToken())
+		return &pb.ExtendResponse{}, status.Error(codes.Unauthenticated, "refresh token is not valid")
+	}
+	if err != nil {
+		log.Printf("DB find failed,\n%v", err)
+		return &pb.ExtendResponse{}, status.Error(codes.Internal, err.Error())
+	}
 
-	t, err := jwt.ParseWithClaims(in.RefreshToken, &FPClaim{}, func(token *jwt.Token) (interface{}, error) {
-        return []byte(userSession.Sign), nil
+	t, err := jwt.ParseWithClaims(in.GetRefreshToken(), &FPClaim{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(userSession.Sign), nil
 	})
 	if err != nil {
 		log.Printf("jwt parsing error,\n%v", err)
-		return &pb.ExtendResponse{}, status.Error(codes.InvalidArgument, err.Error())
+		return &pb.ExtendResponse{}, status.Error(codes.Unauthenticated, err.Error())
 	}
 
 	claim := t.Claims.(*FPClaim)
-	
+
 	if !t.Valid || claim.Type != "REFRESH" {
 		return &pb.ExtendResponse{}, status.Error(codes.Unauthenticated, "")
 	}
-	
-	authClaim := FPClaim{
-		claim.Key,
-		"AUTH",
-		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * time.Duration(in.SessionDurationTime)).Unix(),
-			Issuer:    "farerpath",
-		},
+
+	duration := in.GetSessionDurationTime()
+	if duration < 1 {
+		duration = userSession.SessionDuration
 	}
+	// Sessions made before duration was stored
+	if duration < 1 {
+		duration = defaultSessionDuration
+	}
+
+	sign := secureRandomString(16)
 
-	v, err := rClient.Get(in.AuthToken).Result()
+	authTokenString, refreshTokenString, err := signTokens(claim.Key, sign, userSession.UserID, duration)
 	if err != nil {
-		if err == redis.Nil {
-			return &pb.ExtendResponse{}, status.Error(codes.InvalidArgument, "authToken already expired")
+		log.Printf("Failed to generate token.\n%v", err)
+		return &pb.ExtendResponse{}, status.Error(codes.Internal, err.Error())
+	}
+
+	// New auth token is not known to anyone until session is updated
+	err = rClient.Set(authTokenString, userSession.UserID, time.Hour*time.Duration(duration)).Err()
+	if err != nil {
+		log.Printf("Redis set failed,\n%v", err)
+		return &pb.ExtendResponse{}, status.Error(codes.Internal, err.Error())
+	}
+
+	// Only one of concurrent rotations matches presented refresh token
+	result, err := collection.UpdateOne(ctx, bson.D{{"refreshToken", in.GetRefreshToken()}}, bson.D{
+		{"$set", bson.D{{"authToken", authTokenString}, {"refreshToken", refreshTokenString}, {"sign", sign}, {"sessionDuration", duration}, {"refreshExpiresAt", time.Now().Add(refreshTokenLifetime).UTC()}}},
+		{"$push", bson.D{{"usedRefreshTokens", bson.D{{"$each", bson.A{in.GetRefreshToken()}}, {"$slice", -maxUsedRefreshTokens}}}}},
+	})
+	if err != nil || result.MatchedCount == 0 {
+		rClient.Del(authTokenString)
+
+		if err != nil {
+			log.Printf("DB update failed,\n%v", err)
+			return &pb.ExtendResponse{}, status.Error(codes.Internal, err.Error())
 		}
 
-		return &pb.ExtendResponse{}, status.Error(codes.InvalidArgument, err.Error())
+		revokeSession(ctx, in.GetRefreshToken())
+		return &pb.ExtendResponse{}, status.Error(codes.Unauthenticated, "refresh token is not valid")
 	}
 
-	authToken := jwt.NewWithClaims(jwt.SigningMethodHS512, authClaim)
-	authTokenString, err := authToken.SignedString([]byte(v))
+	revokeAuthToken(userSession.AuthToken)
+
+	return &pb.ExtendResponse{RefreshToken: refreshTokenString, AuthToken: authTokenString}, nil
+}
+
+// revokeSession deletes session whose refresh token was replaced by given one,
+// as token was presented again after rotation
+func revokeSession(ctx context.Context, usedRefreshToken string) {
+	collection := mClient.Database("farerpath").Collection("sessions")
+
+	session := &model.UserSession{}
+	err := collection.FindOneAndDelete(ctx, bson.D{{"usedRefreshTokens", usedRefreshToken}}).Decode(session)
+	if err == mongo.ErrNoDocuments {
+		return
+	}
 	if err != nil {
-		log.Printf("Failed to generate token.\n%v", err)
-		return &pb.ExtendResponse{}, status.Error(codes.Internal, err.Error())
+		log.Printf("DB deletion failed,\n%v", err)
+		return
 	}
 
-	return &pb.ExtendResponse{RefreshToken: in.RefreshToken, AuthToken: authTokenString}, nil
+	log.Printf("Reused refresh token, session of %v revoked\n", session.UserID)
+
+	revokeAuthToken(session.AuthToken)
+}
+
+// storedSession is session with its DB ID, which is ID of session for user
+type storedSession struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	model.UserSession `bson:",inline"`
 }
 
-func (s *sessionService) GetAllSessions(ctx context.Context, in *pb.UserId) (*pb.UserSessions, error) {
+// GetAllSessions gives sessions of user which can still be extended, without tokens
+func (s *sessionService) GetAllSessions(ctx context.Context, in *pb.SessionsRequest) (*pb.UserSessions, error) {
 	collection := mClient.Database("farerpath").Collection("sessions")
-	cursor, err := collection.Find(ctx, bson.D{{"userID", in.UserID}})
+	cursor, err := collection.Find(ctx, bson.D{
+		{"userID", in.GetUserID()},
+		{"$or", bson.A{
+			bson.D{{"refreshExpiresAt", bson.D{{"$gt", time.Now().UTC()}}}},
+			// Sessions made before expiry was stored
+			bson.D{{"refreshExpiresAt", bson.D{{"$exists", false}}}},
+		}},
+	})
 	if err != nil {
 		log.Printf("Find failed,\n%v", err)
 		return &pb.UserSessions{}, status.Error(codes.Internal, err.Error())
 	}
+	defer cursor.Close(ctx)
 
-	var ns []*pb.UserSession
+	ns := []*pb.UserSession{}
 
 	for cursor.Next(ctx) {
-		elem := &pb.UserSession{}
-		cursor.Decode(elem)
-		ns = append(ns, elem)
+		elem := &storedSession{}
+		if err := cursor.Decode(elem); err != nil {
+			log.Printf("Decode failed,\n%v", err)
+			continue
+		}
+
+		ns = append(ns, &pb.UserSession{
+			UserID:      elem.UserID,
+			LoginTime:   elem.LoginTime.Unix(),
+			LoginIP:     elem.LoginIP,
+			LoginRegion: elem.LoginRegion,
+			DeviceType:  elem.DeviceType,
+			SessionID:   elem.ID.Hex(),
+			Current:     len(in.GetAuthToken()) > 0 && elem.AuthToken == in.GetAuthToken(),
+		})
 	}
 
 	return &pb.UserSessions{Session: ns}, nil
 }
 
 func (s *sessionService) VerifyToken(ctx context.Context, in *pb.Token) (*pb.VerifyResponse, error) {
-	v, err := rClient.Get(in.GetToken()).Result()
+	_, err := rClient.Get(in.GetToken()).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return &pb.VerifyResponse{UserID: "", Valid: false}, nil
@@ -274,9 +339,7 @@ func (s *sessionService) VerifyToken(ctx context.Context, in *pb.Token) (*pb.Ver
 		return &pb.VerifyResponse{}, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	t, err := jwt.ParseWithClaims(in.Token, &FPClaim{}, func(token *jwt.Token) (interface{}, error) {
-        return []byte(v), nil
-	})
+	t, err := jwt.ParseWithClaims(in.Token, &FPClaim{}, keys.keyFunc)
 	if err != nil {
 		log.Printf("jwt parsing error,\n%v", err)
 		return &pb.VerifyResponse{}, status.Error(codes.InvalidArgument, err.Error())
@@ -288,15 +351,7 @@ func (s *sessionService) VerifyToken(ctx context.Context, in *pb.Token) (*pb.Ver
 		return &pb.VerifyResponse{Valid: false}, nil
 	}
 
-	session := &model.UserSession{}
-
-	collection := mClient.Database("farerpath").Collection("sessions")
-	err = collection.FindOne(ctx, bson.D{{"authToken",in.GetToken()}}).Decode(session)
-	if err != nil {
-		log.Printf("DB find failed,\n%v", err)
-	}
-
-	return &pb.VerifyResponse{Valid: true, UserID: session.UserID}, nil
+	return &pb.VerifyResponse{Valid: true, UserID: claim.Subject}, nil
 }
 
 func (s *sessionService) DelSession(ctx context.Context, in *pb.Token) (*pb.Value, error) {
@@ -307,11 +362,76 @@ func (s *sessionService) DelSession(ctx context.Context, in *pb.Token) (*pb.Valu
 		return &pb.Value{}, status.Error(codes.Internal, err.Error())
 	}
 
-	rClient.Del(in.GetToken())
+	revokeAuthToken(in.GetToken())
 
 	return &pb.Value{Value: "200"}, nil
 }
 
+// RevokeSession ends session of user by its ID
+func (s *sessionService) RevokeSession(ctx context.Context, in *pb.RevokeSessionRequest) (*pb.Empty, error) {
+	id, err := primitive.ObjectIDFromHex(in.GetSessionID())
+	if err != nil {
+		return &pb.Empty{}, status.Error(codes.NotFound, "session not found")
+	}
+
+	err = deleteSession(ctx, bson.D{{"_id", id}, {"userID", in.GetUserID()}})
+	if err == mongo.ErrNoDocuments {
+		return &pb.Empty{}, status.Error(codes.NotFound, "session not found")
+	}
+	if err != nil {
+		log.Printf("DB deletion failed,\n%v", err)
+		return &pb.Empty{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.Empty{}, nil
+}
+
+// RevokeOtherSessions ends sessions of user except one of auth token
+func (s *sessionService) RevokeOtherSessions(ctx context.Context, in *pb.SessionsRequest) (*pb.Empty, error) {
+	collection := mClient.Database("farerpath").Collection("sessions")
+	cursor, err := collection.Find(ctx, bson.D{{"userID", in.GetUserID()}, {"authToken", bson.D{{"$ne", in.GetAuthToken()}}}})
+	if err != nil {
+		log.Printf("Find failed,\n%v", err)
+		return &pb.Empty{}, status.Error(codes.Internal, err.Error())
+	}
+	defer cursor.Close(ctx)
+
+	ids := []primitive.ObjectID{}
+
+	for cursor.Next(ctx) {
+		elem := &storedSession{}
+		if err := cursor.Decode(elem); err != nil {
+			log.Printf("Decode failed,\n%v", err)
+			return &pb.Empty{}, status.Error(codes.Internal, err.Error())
+		}
+		ids = append(ids, elem.ID)
+	}
+
+	for _, id := range ids {
+		err := deleteSession(ctx, bson.D{{"_id", id}})
+		if err != nil && err != mongo.ErrNoDocuments {
+			log.Printf("DB deletion failed,\n%v", err)
+			return &pb.Empty{}, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return &pb.Empty{}, nil
+}
+
+// deleteSession deletes session and revokes auth token it has when deleted,
+// which may be newer than when it was found
+func deleteSession(ctx context.Context, filter bson.D) error {
+	collection := mClient.Database("farerpath").Collection("sessions")
+
+	session := &model.UserSession{}
+	if err := collection.FindOneAndDelete(ctx, filter).Decode(session); err != nil {
+		return err
+	}
+
+	revokeAuthToken(session.AuthToken)
+	return nil
+}
+
 func secureRandomBytes(length int) []byte {
 	var randomBytes = make([]byte, length)
 	_, err := rand.Read(randomBytes)
@@ -355,4 +475,40 @@ func secureRandomString(length int) string {
 	}
 
 	return string(result)
-}
\ No newline at end of file
+}
+
+// signTokens makes auth token of user signed with signing key
+// and refresh token signed with sign for session of key
+func signTokens(key, sign, userID string, sessionDuration int32) (string, string, error) {
+	authClaim := FPClaim{
+		key,
+		"AUTH",
+		jwt.StandardClaims{
+			Id:        secureRandomString(16),
+			Subject:   userID,
+			ExpiresAt: time.Now().Add(time.Hour * time.Duration(sessionDuration)).Unix(),
+			Issuer:    "farerpath",
+		},
+	}
+
+	authToken, err := signAuthToken(authClaim)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshClaim := FPClaim{
+		key,
+		"REFRESH",
+		jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(refreshTokenLifetime).Unix(),
+			Issuer:    "farerpath",
+		},
+	}
+
+	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS512, refreshClaim).SignedString([]byte(sign))
+	if err != nil {
+		return "", "", err
+	}
+
+	return authToken, refreshToken, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"net"
+	"time"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+)
+
+// Login region is country of login IP.
+// It is looked up after session is made, login never waits for it
+// and sessions have no region when GeoIP is not configured
+
+// GeoIP finds country of IP
+type GeoIP interface {
+	// Country gives ISO 3166-1 alpha-2 code, empty when IP is unknown
+	Country(ip net.IP) (string, error)
+}
+
+var geoIP GeoIP
+
+// mmdbGeoIP reads MaxMind format database like GeoLite2 Country or City
+type mmdbGeoIP struct {
+	reader *maxminddb.Reader
+}
+
+func newMMDBGeoIP(path string) (*mmdbGeoIP, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mmdbGeoIP{reader: reader}, nil
+}
+
+func (g *mmdbGeoIP) Country(ip net.IP) (string, error) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+
+	if err := g.reader.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+
+	return record.Country.ISOCode, nil
+}
+
+// memoryGeoIP is countries of IPs given in advance, for tests
+type memoryGeoIP map[string]string
+
+func (g memoryGeoIP) Country(ip net.IP) (string, error) {
+	return g[ip.String()], nil
+}
+
+// updateLoginRegion sets region of session made with auth token
+func updateLoginRegion(authToken, loginIP string) {
+	country := lookupLoginRegion(loginIP)
+	if len(country) < 1 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := mClient.Database("farerpath").Collection("sessions")
+	_, err := collection.UpdateOne(ctx, bson.D{{"authToken", authToken}}, bson.D{{"$set", bson.D{{"loginRegion", country}}}})
+	if err != nil {
+		log.Printf("DB update failed,\n%v", err)
+	}
+}
+
+// lookupLoginRegion gives country of login IP,
+// empty when GeoIP is not configured or IP is unknown
+func lookupLoginRegion(loginIP string) string {
+	if geoIP == nil {
+		return ""
+	}
+
+	ip := net.ParseIP(loginIP)
+	if ip == nil {
+		return ""
+	}
+
+	country, err := geoIP.Country(ip)
+	if err != nil {
+		log.Printf("GeoIP lookup failed,\n%v", err)
+		return ""
+	}
+
+	return country
+}
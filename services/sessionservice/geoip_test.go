@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+type failingGeoIP struct{}
+
+func (failingGeoIP) Country(ip net.IP) (string, error) {
+	return "", errors.New("lookup failed")
+}
+
+func TestLookupLoginRegion(t *testing.T) {
+	defer func(g GeoIP) { geoIP = g }(geoIP)
+
+	regions := memoryGeoIP{"203.0.113.7": "KR", "2001:db8::1": "JP"}
+
+	tests := []struct {
+		name  string
+		geoIP GeoIP
+		ip    string
+		want  string
+	}{
+		{"not configured", nil, "203.0.113.7", ""},
+		{"IPv4", regions, "203.0.113.7", "KR"},
+		{"IPv6", regions, "2001:db8::1", "JP"},
+		{"unknown IP", regions, "198.51.100.1", ""},
+		{"invalid IP", regions, "not an ip", ""},
+		{"empty IP", regions, "", ""},
+		{"lookup error", failingGeoIP{}, "203.0.113.7", ""},
+	}
+
+	for _, tt := range tests {
+		geoIP = tt.geoIP
+
+		if got := lookupLoginRegion(tt.ip); got != tt.want {
+			t.Errorf("%v: lookupLoginRegion(%q) = %q, want %q", tt.name, tt.ip, got, tt.want)
+		}
+	}
+}
+
+// Sessions are not touched when region is unknown,
+// updateLoginRegion would use DB client which is not set in tests
+func TestUpdateLoginRegionWithoutRegion(t *testing.T) {
+	defer func(g GeoIP) { geoIP = g }(geoIP)
+
+	for _, g := range []GeoIP{nil, memoryGeoIP{}, failingGeoIP{}} {
+		geoIP = g
+		updateLoginRegion("token", "203.0.113.7")
+		updateLoginRegion("token", "not an ip")
+	}
+}
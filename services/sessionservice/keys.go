@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/farerpath/sessionservice/proto"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Auth tokens are signed with RS256 by newest signing key, so other services
+// verify them with public keys and need no call per request.
+// Keys are kept in DB for all session services and rotated on schedule,
+// replaced keys verify until tokens signed by them expire.
+// Auth tokens ended before expiry are listed in redis for verifiers
+
+// KEYROTATION : how often new signing key is made
+var KEYROTATION = 24 * time.Hour
+
+// keyRetention is how long replaced key verifies, no auth token lives longer
+const keyRetention = 30 * 24 * time.Hour
+
+// keyReload is how often keys made by other session services are loaded
+const keyReload = time.Minute
+
+// keyActivation is how long new key is published before it signs,
+// so other session services and verifiers have fetched it
+const keyActivation = 3 * time.Minute
+
+const keyBits = 2048
+
+// revokedTokensKey : redis sorted set of revoked token IDs scored by expiry
+const revokedTokensKey = "revokedTokens"
+
+type signingKey struct {
+	KeyID string `bson:"_id"`
+	// PrivateKey is PKCS #1 DER
+	PrivateKey []byte    `bson:"privateKey"`
+	CreatedAt  time.Time `bson:"createdAt"`
+
+	key *rsa.PrivateKey
+}
+
+type keyring struct {
+	sync.RWMutex
+	// newest first
+	keys []*signingKey
+}
+
+var keys = &keyring{}
+
+func keyCollection() *mongo.Collection {
+	return mClient.Database("farerpath").Collection("signingKeys")
+}
+
+// rotateKeys loads keys, makes new key when newest is due
+// and drops keys no token can be signed with anymore
+func rotateKeys(ctx context.Context) error {
+	loaded, err := loadKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(loaded) == 0 || time.Since(loaded[0].CreatedAt) > KEYROTATION {
+		key, err := newSigningKey()
+		if err != nil {
+			return err
+		}
+
+		if _, err := keyCollection().InsertOne(ctx, key); err != nil {
+			return err
+		}
+
+		log.Printf("Signing key %v made\n", key.KeyID)
+		loaded = append([]*signingKey{key}, loaded...)
+	}
+
+	for i := 1; i < len(loaded); i++ {
+		if time.Since(loaded[i-1].CreatedAt) > keyRetention {
+			_, err := keyCollection().DeleteMany(ctx, bson.D{{"createdAt", bson.D{{"$lte", loaded[i].CreatedAt}}}})
+			if err != nil {
+				log.Printf("DB deletion failed,\n%v", err)
+			}
+
+			loaded = loaded[:i]
+			break
+		}
+	}
+
+	keys.Lock()
+	keys.keys = loaded
+	keys.Unlock()
+
+	return nil
+}
+
+func loadKeys(ctx context.Context) ([]*signingKey, error) {
+	cursor, err := keyCollection().Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{"createdAt", -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	loaded := []*signingKey{}
+
+	for cursor.Next(ctx) {
+		key := &signingKey{}
+		if err := cursor.Decode(key); err != nil {
+			return nil, err
+		}
+
+		key.key, err = x509.ParsePKCS1PrivateKey(key.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %v: %v", key.KeyID, err)
+		}
+
+		loaded = append(loaded, key)
+	}
+
+	return loaded, cursor.Err()
+}
+
+func newSigningKey() (*signingKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKey{
+		KeyID:      secureRandomString(16),
+		PrivateKey: x509.MarshalPKCS1PrivateKey(key),
+		CreatedAt:  time.Now().UTC(),
+		key:        key,
+	}, nil
+}
+
+// signer gives newest active key, newest key when none is active yet
+func (k *keyring) signer() (*signingKey, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	if len(k.keys) == 0 {
+		return nil, errors.New("no signing key")
+	}
+
+	for _, key := range k.keys {
+		if time.Since(key.CreatedAt) > keyActivation {
+			return key, nil
+		}
+	}
+
+	return k.keys[0], nil
+}
+
+// keyFunc gives public key of auth token by its key ID
+func (k *keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	if token.Method != jwt.SigningMethodRS256 {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	k.RLock()
+	defer k.RUnlock()
+
+	for _, key := range k.keys {
+		if key.KeyID == kid {
+			return &key.key.PublicKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key %v", kid)
+}
+
+// signAuthToken signs claim with newest key
+func signAuthToken(claim FPClaim) (string, error) {
+	key, err := keys.signer()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claim)
+	token.Header["kid"] = key.KeyID
+
+	return token.SignedString(key.key)
+}
+
+// revokeAuthToken ends auth token before it expires
+func revokeAuthToken(token string) {
+	rClient.Del(token)
+
+	claim := &FPClaim{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claim); err != nil || len(claim.Id) < 1 {
+		return
+	}
+
+	err := rClient.ZAdd(revokedTokensKey, redis.Z{Score: float64(claim.ExpiresAt), Member: claim.Id}).Err()
+	if err != nil {
+		log.Printf("Redis add failed,\n%v", err)
+	}
+}
+
+func (s *sessionService) GetPublicKeys(ctx context.Context, in *pb.Empty) (*pb.PublicKeys, error) {
+	keys.RLock()
+	defer keys.RUnlock()
+
+	reply := &pb.PublicKeys{}
+
+	for _, key := range keys.keys {
+		reply.Key = append(reply.Key, &pb.PublicKey{
+			KeyID:    key.KeyID,
+			Modulus:  key.key.N.Bytes(),
+			Exponent: int32(key.key.E),
+		})
+	}
+
+	return reply, nil
+}
+
+// GetRevokedTokens gives revoked auth tokens not expired yet
+func (s *sessionService) GetRevokedTokens(ctx context.Context, in *pb.Empty) (*pb.RevokedTokens, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	rClient.ZRemRangeByScore(revokedTokensKey, "-inf", "("+now)
+
+	revoked, err := rClient.ZRangeByScoreWithScores(revokedTokensKey, redis.ZRangeBy{Min: now, Max: "+inf"}).Result()
+	if err != nil {
+		log.Printf("Redis range failed,\n%v", err)
+		return &pb.RevokedTokens{}, status.Error(codes.Internal, err.Error())
+	}
+
+	reply := &pb.RevokedTokens{}
+
+	for _, z := range revoked {
+		id, _ := z.Member.(string)
+		reply.Token = append(reply.Token, &pb.RevokedToken{TokenID: id, ExpiresAt: int64(z.Score)})
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+)
+
+// migrate func
+// Creates indexes on start up, idempotent.
+// Failures are logged only, service keeps running without them
+func migrate(ctx context.Context) {
+	collection := mClient.Database("farerpath").Collection("sessions")
+
+	// Refresh is unauthenticated, so lookups of unknown tokens must not scan sessions
+	for _, key := range []string{"refreshToken", "usedRefreshTokens", "authToken", "userID"} {
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{key, 1}}})
+		if err != nil {
+			log.Printf("Migration failed: sessions %v index\n%v", key, err)
+		}
+	}
+
+	// Sessions which cannot be extended any more are deleted by DB
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"refreshExpiresAt", 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Printf("Migration failed: sessions expiry index\n%v", err)
+	}
+}